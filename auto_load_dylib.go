@@ -1,21 +1,28 @@
 // Copyright (c) seasonjs. All rights reserved.
 // Licensed under the MIT License. See License.txt in the project root for license information.
 
+//go:build darwin || (linux && (amd64 || arm64)) || (windows && amd64)
+
 package rwkv
 
 import (
 	"fmt"
-
 	"os"
+	"runtime"
 )
 
 func dumpRwkvLibrary(gpu bool) (*os.File, error) {
+	var library = getDl(gpu)
+	if library == nil {
+		return nil, fmt.Errorf("%w: no library is embedded for %s/%s", ErrBackendUnsupported, runtime.GOOS, runtime.GOARCH)
+	}
+
 	file, err := os.CreateTemp("", libName)
 	if err != nil {
 		return nil, fmt.Errorf("error creating temp file: %w", err)
 	}
 
-	if err := os.WriteFile(file.Name(), getDl(gpu), 0400); err != nil {
+	if err := os.WriteFile(file.Name(), library, 0400); err != nil {
 		return nil, fmt.Errorf("error writing file: %w", err)
 	}
 	defer file.Close()
@@ -0,0 +1,26 @@
+//go:build !darwin && !(linux && (amd64 || arm64)) && !(windows && amd64)
+
+package rwkv
+
+import "os"
+
+/********************************************************************
+created:    2026-10-18
+author:     lixianmin
+
+Copyright (C) - All Rights Reserved
+*********************************************************************/
+
+// CRwkvImpl is never created on this platform, where purego cannot load rwkv.cpp
+type CRwkvImpl struct {
+	CRwkv
+}
+
+// NewCRwkv returns ErrBackendUnsupported, set RwkvOptions.Backend to BackendGo instead
+func NewCRwkv(libraryPath string) (*CRwkvImpl, error) {
+	return nil, ErrBackendUnsupported
+}
+
+func dumpRwkvLibrary(gpu bool) (*os.File, error) {
+	return nil, ErrBackendUnsupported
+}
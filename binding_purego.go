@@ -0,0 +1,218 @@
+// Copyright (c) seasonjs. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+//go:build darwin || (linux && (amd64 || arm64)) || (windows && amd64)
+
+package rwkv
+
+import (
+	"github.com/ebitengine/purego"
+	"sync/atomic"
+	"unsafe"
+)
+
+type CRwkvImpl struct {
+	libRwkv                  uintptr
+	contexts                 atomic.Int32 // live contexts, the library is closed when the last one is freed
+	cRwkvSetPrintErrors      func(uintptr, bool)
+	cRwkvGetPrintErrors      func(uintptr) bool
+	cRwkvGetLastError        func(uintptr) uint32
+	cRwkvInitFromFile        func(modelFilePath string, nThreads uint32) uintptr
+	cRwkvCloneContext        func(ctx uintptr, nThreads uint32) uintptr
+	cRwkvGpuOffloadLayers    func(ctx uintptr, nGpuLayers uint32) bool
+	cRwkvEval                func(ctx uintptr, token uint32, stateIn uintptr, stateOut uintptr, logitsOut uintptr) bool
+	cRwkvEvalSequence        func(ctx uintptr, token uint32, sequenceLen uint64, stateIn uintptr, stateOut uintptr, logitsOut uintptr) bool
+	cRwkvGetNVocab           func(ctx uintptr) uint64
+	cRwkvGetNEmbedding       func(ctx uintptr) uint64
+	cRwkvGetNLayer           func(ctx uintptr) uint64
+	cRwkvGetStateLength      func(ctx uintptr) uint64
+	cRwkvGetLogitsLength     func(ctx uintptr) uint64
+	cRwkvInitState           func(ctx uintptr, state uintptr)
+	cRwkvFree                func(ctx uintptr)
+	cRwkvQuantizeModelFile   func(modelFilePathIn string, modelFilePathOut string, formatName string) bool
+	cRwkvGetSystemInfoString func() string
+}
+
+func NewCRwkv(libraryPath string) (*CRwkvImpl, error) {
+	libRwkv, err := openLibrary(libraryPath)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		rwkvSetPrintErrors      func(uintptr, bool)
+		rwkvGetPrintErrors      func(uintptr) bool
+		rwkvGetLastError        func(uintptr) uint32
+		rwkvInitFromFile        func(modelFilePath string, nThreads uint32) uintptr
+		rwkvCloneContext        func(ctx uintptr, nThreads uint32) uintptr
+		rwkvGpuOffloadLayers    func(ctx uintptr, nGpuLayers uint32) bool
+		rwkvEval                func(ctx uintptr, token uint32, stateIn uintptr, stateOut uintptr, logitsOut uintptr) bool
+		rwkvEvalSequence        func(ctx uintptr, token uint32, sequenceLen uint64, stateIn uintptr, stateOut uintptr, logitsOut uintptr) bool
+		rwkvGetNVocab           func(ctx uintptr) uint64
+		rwkvGetNEmbedding       func(ctx uintptr) uint64
+		rwkvGetNLayer           func(ctx uintptr) uint64
+		rwkvGetStateLength      func(ctx uintptr) uint64
+		rwkvGetLogitsLength     func(ctx uintptr) uint64
+		rwkvInitState           func(ctx uintptr, state uintptr)
+		rwkvFree                func(ctx uintptr)
+		rwkvQuantizeModelFile   func(modelFilePathIn string, modelFilePathOut string, formatName string) bool
+		rwkvGetSystemInfoString func() string
+	)
+	purego.RegisterLibFunc(&rwkvSetPrintErrors, libRwkv, cRwkvSetPrintErrors)
+	purego.RegisterLibFunc(&rwkvGetPrintErrors, libRwkv, cRwkvGetPrintErrors)
+	purego.RegisterLibFunc(&rwkvGetLastError, libRwkv, cRwkvGetLastError)
+	purego.RegisterLibFunc(&rwkvInitFromFile, libRwkv, cRwkvInitFromFile)
+
+	purego.RegisterLibFunc(&rwkvCloneContext, libRwkv, cRwkvCloneContext)
+	purego.RegisterLibFunc(&rwkvGpuOffloadLayers, libRwkv, cRwkvGpuOffloadLayers)
+	purego.RegisterLibFunc(&rwkvEval, libRwkv, cRwkvEval)
+	purego.RegisterLibFunc(&rwkvEvalSequence, libRwkv, cRwkvEvalSequence)
+
+	purego.RegisterLibFunc(&rwkvGetNVocab, libRwkv, cRwkvGetNVocab)
+	purego.RegisterLibFunc(&rwkvGetNEmbedding, libRwkv, cRwkvGetNEmbedding)
+	purego.RegisterLibFunc(&rwkvGetNLayer, libRwkv, cRwkvGetNLayer)
+	purego.RegisterLibFunc(&rwkvGetStateLength, libRwkv, cRwkvGetStateLength)
+
+	purego.RegisterLibFunc(&rwkvGetLogitsLength, libRwkv, cRwkvGetLogitsLength)
+	purego.RegisterLibFunc(&rwkvInitState, libRwkv, cRwkvInitState)
+	purego.RegisterLibFunc(&rwkvFree, libRwkv, cRwkvFree)
+	purego.RegisterLibFunc(&rwkvQuantizeModelFile, libRwkv, cRwkvQuantizeModelFile)
+
+	purego.RegisterLibFunc(&rwkvGetSystemInfoString, libRwkv, cRwkvGetSystemInfoString)
+
+	return &CRwkvImpl{
+		libRwkv: libRwkv,
+
+		cRwkvSetPrintErrors: rwkvSetPrintErrors,
+		cRwkvGetPrintErrors: rwkvGetPrintErrors,
+		cRwkvGetLastError:   rwkvGetLastError,
+		cRwkvInitFromFile:   rwkvInitFromFile,
+
+		cRwkvCloneContext:     rwkvCloneContext,
+		cRwkvGpuOffloadLayers: rwkvGpuOffloadLayers,
+		cRwkvEval:             rwkvEval,
+		cRwkvEvalSequence:     rwkvEvalSequence,
+
+		cRwkvGetNVocab:      rwkvGetNVocab,
+		cRwkvGetNEmbedding:  rwkvGetNEmbedding,
+		cRwkvGetNLayer:      rwkvGetNLayer,
+		cRwkvGetStateLength: rwkvGetStateLength,
+
+		cRwkvGetLogitsLength:   rwkvGetLogitsLength,
+		cRwkvInitState:         rwkvInitState,
+		cRwkvFree:              rwkvFree,
+		cRwkvQuantizeModelFile: rwkvQuantizeModelFile,
+
+		cRwkvGetSystemInfoString: rwkvGetSystemInfoString,
+	}, nil
+}
+
+func (c *CRwkvImpl) RwkvSetPrintErrors(ctx *RwkvCtx, enable bool) {
+	c.cRwkvSetPrintErrors(ctx.ctx, enable)
+}
+
+func (c *CRwkvImpl) RwkvGetPrintErrors(ctx *RwkvCtx) bool {
+	return c.cRwkvGetPrintErrors(ctx.ctx)
+}
+
+func (c *CRwkvImpl) RwkvGetLastError(ctx *RwkvCtx) error {
+	cErr := c.cRwkvGetLastError(ctx.ctx)
+	err := RwkvErrors(cErr)
+	if err == RwkvErrorNone {
+		return nil
+	}
+	return err
+}
+
+func (c *CRwkvImpl) RwkvInitFromFile(filePath string, threads uint32) *RwkvCtx {
+	ctx := c.cRwkvInitFromFile(filePath, threads)
+	if ctx != 0 {
+		c.contexts.Add(1)
+	}
+	return &RwkvCtx{ctx: ctx}
+}
+
+func (c *CRwkvImpl) RwkvCloneContext(ctx *RwkvCtx, threads uint32) *RwkvCtx {
+	newCtx := c.cRwkvCloneContext(ctx.ctx, threads)
+	if newCtx != 0 {
+		c.contexts.Add(1)
+	}
+	return &RwkvCtx{ctx: newCtx}
+}
+
+func (c *CRwkvImpl) RwkvGpuOffloadLayers(ctx *RwkvCtx, nGpuLayers uint32) error {
+	ok := c.cRwkvGpuOffloadLayers(ctx.ctx, nGpuLayers)
+	if !ok {
+		return c.RwkvGetLastError(ctx)
+	}
+	return nil
+}
+
+func (c *CRwkvImpl) RwkvEval(ctx *RwkvCtx, token uint32, stateIn []float32, stateOut []float32, logitsOut []float32) error {
+	ok := c.cRwkvEval(ctx.ctx, token, uintptr(unsafe.Pointer(&stateIn[0])), uintptr(unsafe.Pointer(&stateOut[0])), uintptr(unsafe.Pointer(&logitsOut[0])))
+	if !ok {
+		return c.RwkvGetLastError(ctx)
+	}
+	return nil
+}
+
+func (c *CRwkvImpl) RwkvEvalSequence(ctx *RwkvCtx, token uint32, sequenceLen uint64, stateIn []float32, stateOut []float32, logitsOut []float32) error {
+	ok := c.cRwkvEvalSequence(ctx.ctx, token, sequenceLen, uintptr(unsafe.Pointer(&stateIn[0])), uintptr(unsafe.Pointer(&stateOut[0])), uintptr(unsafe.Pointer(&logitsOut[0])))
+	if !ok {
+		return c.RwkvGetLastError(ctx)
+	}
+	return nil
+}
+
+func (c *CRwkvImpl) RwkvGetNVocab(ctx *RwkvCtx) uint64 {
+	return c.cRwkvGetNVocab(ctx.ctx)
+}
+
+func (c *CRwkvImpl) RwkvGetNEmbedding(ctx *RwkvCtx) uint64 {
+	return c.cRwkvGetNEmbedding(ctx.ctx)
+}
+
+func (c *CRwkvImpl) RwkvGetNLayer(ctx *RwkvCtx) uint64 {
+	return c.cRwkvGetNLayer(ctx.ctx)
+}
+
+func (c *CRwkvImpl) RwkvGetStateLength(ctx *RwkvCtx) uint64 {
+	return c.cRwkvGetStateLength(ctx.ctx)
+}
+
+func (c *CRwkvImpl) RwkvGetLogitsLength(ctx *RwkvCtx) uint64 {
+	return c.cRwkvGetLogitsLength(ctx.ctx)
+}
+
+func (c *CRwkvImpl) RwkvInitState(ctx *RwkvCtx, state []float32) {
+	c.cRwkvInitState(ctx.ctx, uintptr(unsafe.Pointer(&state[0])))
+}
+
+func (c *CRwkvImpl) RwkvFree(ctx *RwkvCtx) error {
+	if ctx.ctx != 0 {
+		c.cRwkvFree(ctx.ctx)
+		ctx.ctx = 0
+		// cloned contexts share the library, keep it open until the last context is freed
+		if c.contexts.Add(-1) > 0 {
+			return nil
+		}
+	}
+
+	if c.libRwkv != 0 {
+		var err = closeLibrary(c.libRwkv)
+		c.libRwkv = 0
+		return err
+	}
+	return nil
+}
+
+func (c *CRwkvImpl) RwkvQuantizeModelFile(ctx *RwkvCtx, in, out string, format QuantizedFormat) error {
+	ok := c.cRwkvQuantizeModelFile(in, out, string(format))
+	if !ok {
+		return c.RwkvGetLastError(ctx)
+	}
+	return nil
+}
+
+func (c *CRwkvImpl) RwkvGetSystemInfoString() string {
+	return c.cRwkvGetSystemInfoString()
+}
@@ -29,6 +29,7 @@ func assertNonNil(t *testing.T, obj any, message ...string) {
 
 func TestNewCRwkv(t *testing.T) {
 	rwkv, err := NewCRwkv(getLibrary())
+	skipUnsupported(t, err)
 	if err != nil {
 		t.Error(err)
 	}
@@ -0,0 +1,228 @@
+package rwkv
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+)
+
+/********************************************************************
+created:    2026-10-18
+author:     lixianmin
+
+Copyright (C) - All Rights Reserved
+*********************************************************************/
+
+const defaultStateCacheSize = 16
+
+var ErrNoMessages = errors.New("there is no message to reply to")
+
+type ChatOptions struct {
+	Template  ChatTemplate            // renders the messages, nil for WorldTemplate
+	Sampling  *SamplingOptions        // nil for the sampling parameters of RwkvOptions
+	StopTexts []string                // nil for the stop texts of Template
+	OnToken   func(piece string) bool // streams the reply, returning false stops the generation
+	Retrieval *RetrievalOptions       // injects the documents relevant to the last user message, nil for no retrieval
+}
+
+// Chat replies to messages as the assistant. The messages are rendered through options.Template, and the state of
+// the longest prefix seen by a previous Chat is reused, so that a growing conversation evaluates only its new turns.
+// messages is never modified.
+func (my *ChatModel) Chat(ctx context.Context, messages []Message, options ChatOptions) (Message, error) {
+	if len(messages) == 0 {
+		return Message{}, ErrNoMessages
+	}
+
+	var template = options.Template
+	if template == nil {
+		template = WorldTemplate
+	}
+
+	var stopTexts = options.StopTexts
+	if stopTexts == nil {
+		stopTexts = template.StopTexts()
+	}
+
+	var sampling = newSamplingOptions(my.options)
+	if options.Sampling != nil {
+		sampling = *options.Sampling
+	}
+
+	if options.Retrieval != nil && options.Retrieval.Retriever != nil {
+		var retrieval, err = newRetrieval(*options.Retrieval)
+		if err != nil {
+			return Message{}, err
+		}
+
+		if messages, err = retrieval.withContext(ctx, my, messages); err != nil {
+			return Message{}, err
+		}
+	}
+
+	var history, err = template.Render(messages, false)
+	if err != nil {
+		return Message{}, err
+	}
+
+	full, err := template.Render(messages, true)
+	if err != nil {
+		return Message{}, err
+	}
+
+	// the history ends at a separator, tokenizing it apart from the generation suffix keeps its tokens the same
+	// when the conversation grows, which is what makes them reusable
+	var suffix = ""
+	if strings.HasPrefix(full, history) {
+		suffix = full[len(history):]
+	} else {
+		history = full
+	}
+
+	state, logits, err := my.evalHistory(history)
+	if err != nil {
+		return Message{}, err
+	}
+
+	suffixTokens, err := my.tokenizer.Encode(suffix)
+	if err != nil {
+		return Message{}, err
+	}
+
+	if err = my.evalTokens(suffixTokens, state, logits); err != nil {
+		return Message{}, err
+	}
+
+	adjustLogits(logits, suffixTokens, -999999999, nil)
+	content, _, err := generateReply(ctx, my, state, logits, replyOptions{
+		sampling:  sampling,
+		stopTexts: stopTexts,
+		onPiece:   options.OnToken,
+	})
+
+	return Message{Role: RoleAssistant, Content: strings.TrimSpace(content)}, err
+}
+
+// evalHistory returns the state and the logits after text, starting from the longest cached prefix
+func (my *ChatModel) evalHistory(text string) ([]float32, []float32, error) {
+	var tokens, err = my.tokenizer.Encode(text)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var cache = my.stateCache()
+	cache.sync(my.states.currentGeneration())
+	var count, state, logits, generation = cache.lookup(tokens)
+	if state == nil {
+		state, logits, generation = my.newStateOfGeneration()
+	}
+
+	if count < len(tokens) {
+		if err = my.evalTokens(tokens[count:], state, logits); err != nil {
+			return nil, nil, err
+		}
+
+		cache.add(tokens, state, logits, generation)
+	}
+
+	return state, logits, nil
+}
+
+func (my *ChatModel) stateCache() *stateCache {
+	my.cacheOnce.Do(func() {
+		my.cache = newStateCache(defaultStateCacheSize)
+	})
+
+	return my.cache
+}
+
+type stateCacheEntry struct {
+	tokens []int
+	state  []float32
+	logits []float32
+}
+
+// stateCache keeps the states after recently evaluated token sequences, and evicts the least recently used ones
+type stateCache struct {
+	lock       sync.Mutex
+	capacity   int
+	entries    []*stateCacheEntry // the most recently used is the last
+	generation uint64             // the generation of the tuned state in use, which the entries start from
+}
+
+func newStateCache(capacity int) *stateCache {
+	return &stateCache{capacity: capacity, entries: make([]*stateCacheEntry, 0, capacity)}
+}
+
+// lookup finds the longest cached sequence which is a prefix of tokens, and returns its length with copies of its
+// state and logits, and the generation they start from. The state is nil when nothing matches.
+func (my *stateCache) lookup(tokens []int) (int, []float32, []float32, uint64) {
+	my.lock.Lock()
+	defer my.lock.Unlock()
+
+	var best = -1
+	for i, entry := range my.entries {
+		var count = len(entry.tokens)
+		if count <= len(tokens) && slices.Equal(entry.tokens, tokens[:count]) {
+			if best < 0 || count > len(my.entries[best].tokens) {
+				best = i
+			}
+		}
+	}
+
+	if best < 0 {
+		return 0, nil, nil, my.generation
+	}
+
+	var entry = my.entries[best]
+	my.entries = append(slices.Delete(my.entries, best, best+1), entry)
+	return len(entry.tokens), slices.Clone(entry.state), slices.Clone(entry.logits), my.generation
+}
+
+// add caches copies of state and logits after tokens, unless the tuned state has changed since generation, which the
+// state starts from
+func (my *stateCache) add(tokens []int, state []float32, logits []float32, generation uint64) {
+	if my.capacity <= 0 {
+		return
+	}
+
+	my.lock.Lock()
+	defer my.lock.Unlock()
+
+	if generation != my.generation {
+		return
+	}
+
+	my.entries = slices.DeleteFunc(my.entries, func(entry *stateCacheEntry) bool {
+		return slices.Equal(entry.tokens, tokens)
+	})
+
+	if len(my.entries) >= my.capacity {
+		my.entries = slices.Delete(my.entries, 0, 1)
+	}
+
+	my.entries = append(my.entries, &stateCacheEntry{
+		tokens: slices.Clone(tokens),
+		state:  slices.Clone(state),
+		logits: slices.Clone(logits),
+	})
+}
+
+// sync drops all entries when the tuned state in use has changed since they were added. The generations only grow,
+// so a caller which read an older one does not bring the cache back to it.
+func (my *stateCache) sync(generation uint64) {
+	my.lock.Lock()
+	defer my.lock.Unlock()
+
+	if generation > my.generation {
+		my.generation = generation
+		my.entries = my.entries[:0]
+	}
+}
+
+func (my *stateCache) len() int {
+	my.lock.Lock()
+	defer my.lock.Unlock()
+	return len(my.entries)
+}
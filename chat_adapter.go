@@ -0,0 +1,144 @@
+package rwkv
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+/********************************************************************
+created:    2026-10-18
+author:     lixianmin
+
+Copyright (C) - All Rights Reserved
+*********************************************************************/
+
+var (
+	ErrAdapterExists      = errors.New("the adapter already exists")
+	ErrAdapterNotFound    = errors.New("the adapter is not found")
+	ErrAdapterUnsupported = errors.New("LoRA adapters need the Go backend, see RwkvOptions.Backend")
+)
+
+// adapterRegistry holds the adapters of a ChatModel, every adapter has a model of its own context which evaluates
+// with it
+type adapterRegistry struct {
+	lock   sync.Mutex
+	models map[string]*ChatModel
+}
+
+// AddAdapter registers adapter as name. The Go backend adds the deltas of adapter while evaluating, so all adapters
+// share the weights of my and each one costs only its low rank matrices. The adapters share the tuned states of my
+// too, UseState on any of them changes the initial state of all. See WithAdapter for using it.
+func (my *ChatModel) AddAdapter(name string, adapter *LoraAdapter) error {
+	var impl, ok = my.cRwkv.(*GoRwkvImpl)
+	if !ok {
+		return ErrAdapterUnsupported
+	}
+
+	var registry = &my.adapters
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	// "" is the name of my without adapters
+	if _, ok := registry.models[name]; ok || name == "" {
+		return ErrAdapterExists
+	}
+
+	var ctx, err = impl.adaptContext(my.ctx, adapter, my.options.CpuThreads)
+	if err != nil {
+		return err
+	}
+
+	impl.RwkvSetPrintErrors(ctx, my.options.PrintError)
+	if registry.models == nil {
+		registry.models = make(map[string]*ChatModel)
+	}
+
+	registry.models[name] = &ChatModel{
+		dylibPath: my.dylibPath,
+		cRwkv:     my.cRwkv,
+		options:   my.options,
+		tokenizer: my.tokenizer,
+		ctx:       ctx,
+		isClone:   true,
+		states:    my.states,
+	}
+
+	return nil
+}
+
+// LoadAdapter reads the adapter of path by LoadLoraAdapter, and registers it as name
+func (my *ChatModel) LoadAdapter(name string, path string, options LoraOptions) error {
+	var adapter, err = LoadLoraAdapter(path, options)
+	if err != nil {
+		return err
+	}
+
+	return my.AddAdapter(name, adapter)
+}
+
+// RemoveAdapter unregisters the adapter of name and closes its model, the chatbots on that model fail to eval since
+func (my *ChatModel) RemoveAdapter(name string) error {
+	var registry = &my.adapters
+	registry.lock.Lock()
+	var model, ok = registry.models[name]
+	delete(registry.models, name)
+	registry.lock.Unlock()
+
+	if !ok {
+		return ErrAdapterNotFound
+	}
+
+	return model.Close()
+}
+
+// Adapters returns the sorted names of the adapters
+func (my *ChatModel) Adapters() []string {
+	var registry = &my.adapters
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	var names = make([]string, 0, len(registry.models))
+	for name := range registry.models {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// WithAdapter returns the model which evaluates with the adapter of name, or my for "". The model shares the weights
+// of my but has its own context, so it evaluates in parallel with my and the other adapters. Pass it to NewChatbot
+// or Chat to talk to the fine-tuned persona, and leave closing it to RemoveAdapter or the Close of my.
+func (my *ChatModel) WithAdapter(name string) (*ChatModel, error) {
+	if name == "" {
+		return my, nil
+	}
+
+	var registry = &my.adapters
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	if model, ok := registry.models[name]; ok {
+		return model, nil
+	}
+
+	return nil, ErrAdapterNotFound
+}
+
+// closeAdapters closes the models of all adapters
+func (my *ChatModel) closeAdapters() error {
+	var registry = &my.adapters
+	registry.lock.Lock()
+	var models = registry.models
+	registry.models = nil
+	registry.lock.Unlock()
+
+	var err error
+	for _, model := range models {
+		if err2 := model.Close(); err2 != nil && err == nil {
+			err = err2
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,138 @@
+package rwkv
+
+import (
+	"errors"
+	"math/rand"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+/********************************************************************
+created:    2026-10-18
+author:     lixianmin
+
+Copyright (C) - All Rights Reserved
+*********************************************************************/
+
+func TestChatModelAdapters(t *testing.T) {
+	const nVocab, nEmbed, nLayer, rank = worldVocabSize, 32, 2, 4
+	var dir = t.TempDir()
+	var base, merged = filepath.Join(dir, "base.bin"), filepath.Join(dir, "merged.bin")
+	assert(t, writeFixtureModel(base, nVocab, nEmbed, nLayer, newTinyModelTensors(2, nVocab, nEmbed, nLayer)) == nil)
+
+	var random = rand.New(rand.NewSource(8))
+	var tensors = newFixtureLoraPair(random, "blocks.0.att.key", "", rank, nEmbed, nEmbed)
+	tensors = append(tensors, newFixtureLoraPair(random, "blocks.1.ffn.value", "", rank, nEmbed, 4*nEmbed)...)
+	tensors = append(tensors, newFixtureLoraPair(random, "emb", "", rank, nVocab, nEmbed)...)
+	tensors = append(tensors, newFixtureLoraPair(random, "head", "", rank, nVocab, nEmbed)...)
+	var adapterPath = filepath.Join(dir, "persona.safetensors")
+	assert(t, writeFixtureSafetensors(adapterPath, map[string]string{"lora_alpha": "8"}, "F32", tensors) == nil)
+
+	var adapter, err = LoadLoraAdapter(adapterPath, LoraOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert(t, MergeLoraModelFile(base, merged, adapter) == nil)
+
+	var options = RwkvOptions{MaxTokens: 8, Temperature: 1, TopP: 1, Backend: BackendGo, TokenizerType: Auto}
+	model, err := NewChatModel(base, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer model.Close()
+
+	mergedModel, err := NewChatModel(merged, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mergedModel.Close()
+
+	var tokens = model.Encode("hello world, how are you?")
+	var _, baseLogits = model.EvalSequence(tokens, nil)
+
+	assert(t, model.LoadAdapter("persona", adapterPath, LoraOptions{}) == nil)
+	assert(t, errors.Is(model.AddAdapter("persona", adapter), ErrAdapterExists))
+	assert(t, slices.Equal(model.Adapters(), []string{"persona"}))
+
+	persona, err := model.WithAdapter("persona")
+	assert(t, err == nil && persona != model)
+	self, _ := model.WithAdapter("")
+	assert(t, self == model)
+	_, err = model.WithAdapter("missing")
+	assert(t, errors.Is(err, ErrAdapterNotFound))
+
+	// the adapter on the fly matches the merged weights, and leaves the base model alone
+	var _, personaLogits = persona.EvalSequence(tokens, nil)
+	var _, mergedLogits = mergedModel.EvalSequence(tokens, nil)
+	var _, baseAgain = model.EvalSequence(tokens, nil)
+	assert(t, maxRelativeDiff(mergedLogits, personaLogits) < 1e-4, "the adapter should work as the merged model")
+	assert(t, maxRelativeDiff(baseLogits, personaLogits) > 1e-3, "the adapter should change the logits")
+	assert(t, slices.Equal(baseLogits, baseAgain), "the base model should not change")
+
+	// the tuned states of the base model apply to its adapters
+	var tuned, _ = model.EvalSequence(model.Encode("You are a pirate, arr!"), nil)
+	assert(t, model.AddState("pirate", tuned) == nil && model.UseState("pirate") == nil)
+	var _, tunedLogits = persona.EvalSequence(tokens, nil)
+	var _, wantLogits = persona.EvalSequence(tokens, slices.Clone(tuned))
+	assert(t, slices.Equal(tunedLogits, wantLogits), "the adapter should start from the tuned state in use")
+	assert(t, slices.Equal(persona.States(), []string{"pirate"}) && model.UseState("") == nil)
+
+	// the weights are shared, only the adapted ones are wrapped
+	var impl = model.cRwkv.(*GoRwkvImpl)
+	var baseModel, personaModel = impl.get(model.ctx).model, impl.get(persona.ctx).model
+	assert(t, personaModel.layers[1].attKey == baseModel.layers[1].attKey, "the weights should be shared")
+	assert(t, personaModel.layers[0].attKey.(*loraMatrix).base == baseModel.layers[0].attKey)
+
+	var wrong = &LoraAdapter{layers: map[string]*loraLayer{"blocks.9.att.key.weight": adapter.layers["blocks.0.att.key.weight"]}}
+	assert(t, errors.Is(model.AddAdapter("wrong", wrong), ErrInvalidLora), "the weights should be in the model")
+
+	// sessions pick their adapters, which survive eviction
+	var manager, err2 = NewSessionManager(model, SessionManagerOptions{UserName: "User", BotName: "Assistant",
+		Prompt: "You are a helpful assistant.\n\n", Dir: t.TempDir()})
+	if err2 != nil {
+		t.Fatal(err2)
+	}
+	defer manager.Close()
+
+	assert(t, manager.SetAdapter("alice", "persona") == nil)
+	assert(t, errors.Is(manager.SetAdapter("bob", "missing"), ErrAdapterNotFound))
+	var promptState, _ = persona.EvalSequence(persona.Encode("You are a helpful assistant.\n\n"), nil)
+	assert(t, slices.Equal(manager.sessions["alice"].bot.state, promptState), "the prompt should run with the adapter")
+
+	_, err = manager.Process("alice", "hello")
+	assert(t, err == nil)
+	assert(t, manager.EvictIdle(0) == 2)
+	adapterName, err := manager.Adapter("alice")
+	assert(t, err == nil && adapterName == "persona", "the adapter should be loaded back")
+	assert(t, manager.sessions["alice"].bot.model == persona)
+
+	bobAdapter, _ := manager.Adapter("bob")
+	assert(t, bobAdapter == "", "a failed switch should keep the adapter")
+
+	assert(t, manager.Evict("alice") == nil)
+	assert(t, model.RemoveAdapter("persona") == nil)
+	assert(t, errors.Is(model.RemoveAdapter("persona"), ErrAdapterNotFound))
+	assert(t, len(model.Adapters()) == 0)
+
+	// a session saved with a removed adapter fails to load, and stays on disk
+	_, err = manager.Process("alice", "hello again")
+	assert(t, errors.Is(err, ErrAdapterNotFound), "the missing adapter of a saved session should be reported")
+	var names, _ = manager.List()
+	assert(t, slices.Contains(names, "alice"), "the session should stay on disk")
+
+	// the template of a removed adapter is dropped, and an adapter added again gets a new one
+	assert(t, errors.Is(manager.SetAdapter("carol", "persona"), ErrAdapterNotFound))
+	assert(t, model.AddAdapter("persona", adapter) == nil)
+	persona, _ = model.WithAdapter("persona")
+	assert(t, manager.SetAdapter("carol", "persona") == nil)
+	assert(t, manager.sessions["carol"].bot.model == persona, "the template should be on the new adapter")
+	adapterName, err = manager.Adapter("alice")
+	assert(t, err == nil && adapterName == "persona" && manager.sessions["alice"].bot.model == persona)
+	_, err = manager.Process("carol", "hello")
+	assert(t, err == nil)
+
+	var cModel = newTinyChatModel(t)
+	defer cModel.Close()
+	assert(t, errors.Is(cModel.AddAdapter("persona", adapter), ErrAdapterUnsupported))
+}
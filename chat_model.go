@@ -5,6 +5,7 @@ import (
 	"log"
 	"os"
 	"strings"
+	"sync"
 )
 
 /********************************************************************
@@ -20,29 +21,16 @@ type ChatModel struct {
 	options   *RwkvOptions
 	tokenizer Tokenizer
 	ctx       *RwkvCtx
+	lock      sync.Mutex // a context can have only one eval running at a time
+	isClone   bool       // cloned models share the dylib with their source
+	cache     *stateCache
+	cacheOnce sync.Once
+	adapters  adapterRegistry
+	states    *tunedStates // shared by the clones
 }
 
 func NewChatModel(modelPath string, options RwkvOptions) (*ChatModel, error) {
-	file, err := dumpRwkvLibrary(options.GpuEnable)
-	if err != nil {
-		return nil, err
-	}
-
-	dylibPath := file.Name()
-
-	cRwkv, err := NewCRwkv(dylibPath)
-	if err != nil {
-		return nil, err
-	}
-
-	var tk Tokenizer
-
-	if options.TokenizerType == Normal {
-		tk, err = NewNormalTokenizer()
-	} else if options.TokenizerType == World {
-		tk, err = NewWorldTokenizer()
-	}
-
+	cRwkv, dylibPath, err := newBackend(&options)
 	if err != nil {
 		return nil, err
 	}
@@ -57,7 +45,7 @@ func NewChatModel(modelPath string, options RwkvOptions) (*ChatModel, error) {
 		dylibPath: dylibPath,
 		cRwkv:     cRwkv,
 		options:   &options,
-		tokenizer: tk,
+		states:    &tunedStates{},
 	}
 
 	var err2 = model.loadFromFile(modelPath)
@@ -65,6 +53,24 @@ func NewChatModel(modelPath string, options RwkvOptions) (*ChatModel, error) {
 		return nil, err2
 	}
 
+	if options.Tokenizer != nil {
+		model.tokenizer = options.Tokenizer
+		return model, nil
+	}
+
+	// the tokenizer is chosen after loading, so that it can be checked against the vocabulary size of the model
+	var tokenizerType, err3 = resolveTokenizerType(options.TokenizerType, cRwkv.RwkvGetNVocab(model.ctx))
+	if err3 != nil {
+		_ = cRwkv.RwkvFree(model.ctx)
+		return nil, err3
+	}
+
+	model.tokenizer, err = newTokenizer(tokenizerType)
+	if err != nil {
+		_ = cRwkv.RwkvFree(model.ctx)
+		return nil, err
+	}
+
 	return model, nil
 }
 
@@ -77,7 +83,7 @@ func (my *ChatModel) loadFromFile(path string) error {
 	var ctx = my.cRwkv.RwkvInitFromFile(path, my.options.CpuThreads)
 	var err2 = hasCtx(ctx)
 	if err2 != nil {
-		return err2
+		return initError(my.cRwkv, ctx, err2)
 	}
 
 	my.ctx = ctx
@@ -109,10 +115,69 @@ func (my *ChatModel) Decode(input []int) string {
 	return my.tokenizer.Decode(input)
 }
 
+// EOS returns the end of text token, generation stops when it is sampled
+func (my *ChatModel) EOS() int {
+	return eosOf(my.tokenizer)
+}
+
+// clone creates a model which shares the weights, the tokenizer and the options of my, but has its own context,
+// so that it can eval in parallel with my
+func (my *ChatModel) clone(threads uint32) (*ChatModel, error) {
+	if err := hasCtx(my.ctx); err != nil {
+		return nil, err
+	}
+
+	if threads == 0 {
+		threads = my.options.CpuThreads
+	}
+
+	var ctx = my.cRwkv.RwkvCloneContext(my.ctx, threads)
+	if err := hasCtx(ctx); err != nil {
+		return nil, err
+	}
+
+	my.cRwkv.RwkvSetPrintErrors(ctx, my.options.PrintError)
+	return &ChatModel{
+		dylibPath: my.dylibPath,
+		cRwkv:     my.cRwkv,
+		options:   my.options,
+		tokenizer: my.tokenizer,
+		ctx:       ctx,
+		isClone:   true,
+		states:    my.states,
+	}, nil
+}
+
+// Close frees the context and the adapters, and removes the dumped dylib when my is not a clone
+func (my *ChatModel) Close() error {
+	var adapterErr = my.closeAdapters()
+
+	my.lock.Lock()
+	defer my.lock.Unlock()
+
+	if my.ctx != nil {
+		if err := my.cRwkv.RwkvFree(my.ctx); err != nil {
+			return err
+		}
+		my.ctx = nil
+	}
+
+	if !my.isClone && my.dylibPath != "" {
+		var err = os.Remove(my.dylibPath)
+		my.dylibPath = ""
+		return err
+	}
+
+	return adapterErr
+}
+
 func (my *ChatModel) EvalSequence(tokens []int, state []float32) ([]float32, []float32) {
+	my.lock.Lock()
+	defer my.lock.Unlock()
+
 	if state == nil {
 		state = make([]float32, my.cRwkv.RwkvGetStateLength(my.ctx))
-		my.cRwkv.RwkvInitState(my.ctx, state)
+		my.initState(state)
 	}
 
 	var logits = make([]float32, my.cRwkv.RwkvGetLogitsLength(my.ctx))
@@ -127,9 +192,45 @@ func (my *ChatModel) EvalSequence(tokens []int, state []float32) ([]float32, []f
 	return state, logits
 }
 
+// newState returns an initialized state and a logits buffer
+func (my *ChatModel) newState() ([]float32, []float32) {
+	var state, logits, _ = my.newStateOfGeneration()
+	return state, logits
+}
+
+// newStateOfGeneration returns an initialized state and a logits buffer, with the generation of the tuned state which
+// the state starts from
+func (my *ChatModel) newStateOfGeneration() ([]float32, []float32, uint64) {
+	my.lock.Lock()
+	defer my.lock.Unlock()
+
+	var state = make([]float32, my.cRwkv.RwkvGetStateLength(my.ctx))
+	var generation = my.initState(state)
+	var logits = make([]float32, my.cRwkv.RwkvGetLogitsLength(my.ctx))
+	return state, logits, generation
+}
+
+// evalTokens feeds tokens into state in place, and writes the logits of the last token into logits
+func (my *ChatModel) evalTokens(tokens []int, state []float32, logits []float32) error {
+	my.lock.Lock()
+	defer my.lock.Unlock()
+
+	for _, token := range tokens {
+		var err = my.cRwkv.RwkvEval(my.ctx, uint32(token), state, state, logits)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (my *ChatModel) Eval(tokens []int) (string, error) {
+	my.lock.Lock()
+	defer my.lock.Unlock()
+
 	var state = make([]float32, my.cRwkv.RwkvGetStateLength(my.ctx))
-	my.cRwkv.RwkvInitState(my.ctx, state)
+	my.initState(state)
 	var logits = make([]float32, my.cRwkv.RwkvGetLogitsLength(my.ctx))
 
 	for _, token := range tokens {
@@ -145,6 +246,7 @@ func (my *ChatModel) Eval(tokens []int) (string, error) {
 func (my *ChatModel) generateResponse(state, logits []float32) (string, error) {
 	var responseText = ""
 	var options = my.options
+	var eos = my.EOS()
 
 	for i := 0; i < options.MaxTokens; i++ {
 		token, err := SampleLogits(logits, options.Temperature, options.TopP, nil)
@@ -152,6 +254,10 @@ func (my *ChatModel) generateResponse(state, logits []float32) (string, error) {
 			return "", err
 		}
 
+		if token == eos {
+			break
+		}
+
 		err = my.cRwkv.RwkvEval(my.ctx, uint32(token), state, state, logits)
 		if err != nil {
 			return "", err
@@ -0,0 +1,189 @@
+package rwkv
+
+import (
+	"errors"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+/********************************************************************
+created:    2026-10-18
+author:     lixianmin
+
+Copyright (C) - All Rights Reserved
+*********************************************************************/
+
+// ChatTemplate turns messages into the prompt text of a model
+type ChatTemplate interface {
+	// Render renders messages, and appends the beginning of the assistant's reply when generation is true
+	Render(messages []Message, generation bool) (string, error)
+
+	// StopTexts returns the texts which mean the assistant's reply is over
+	StopTexts() []string
+}
+
+var multiNewlines = regexp.MustCompile(`\n{2,}`)
+
+// RoleTemplate renders every message as `Name: content`, followed by Separator. Roles without a name, or with an
+// empty name, are rendered as plain paragraphs, which suits system messages of models trained without them.
+type RoleTemplate struct {
+	Names            map[string]string // the name of every role, such as RoleUser => "User"
+	Separator        string            // follows every message, "\n\n" when empty
+	GenerationSuffix string            // follows `Name:` of the assistant's reply, RWKV World models expect nothing
+}
+
+// NewRoleTemplate creates the `User: ...\n\nBot: ` format which Chatbot has always used
+func NewRoleTemplate(userName string, botName string) *RoleTemplate {
+	return &RoleTemplate{
+		Names:            map[string]string{RoleUser: userName, RoleAssistant: botName},
+		GenerationSuffix: " ",
+	}
+}
+
+func (my *RoleTemplate) separator() string {
+	if my.Separator == "" {
+		return "\n\n"
+	}
+
+	return my.Separator
+}
+
+func (my *RoleTemplate) Render(messages []Message, generation bool) (string, error) {
+	var sb strings.Builder
+	var separator = my.separator()
+
+	for _, message := range messages {
+		// a blank line inside a message would look like the end of a turn
+		var content = strings.ReplaceAll(message.Content, "\r\n", "\n")
+		content = multiNewlines.ReplaceAllString(strings.TrimSpace(content), "\n")
+
+		if name := my.Names[message.Role]; name != "" {
+			sb.WriteString(name)
+			sb.WriteString(": ")
+		}
+
+		sb.WriteString(content)
+		sb.WriteString(separator)
+	}
+
+	if generation {
+		var name = my.Names[RoleAssistant]
+		if name == "" {
+			return "", errors.New("the template has no name for the assistant")
+		}
+
+		sb.WriteString(name)
+		sb.WriteString(":")
+		sb.WriteString(my.GenerationSuffix)
+	}
+
+	return sb.String(), nil
+}
+
+// StopTexts returns the separator, and `Name: ` of the user, the assistant and then the other roles
+func (my *RoleTemplate) StopTexts() []string {
+	var stopTexts = []string{my.separator()}
+	var add = func(name string) {
+		if name != "" {
+			var stopText = name + ": "
+			if !slices.Contains(stopTexts, stopText) {
+				stopTexts = append(stopTexts, stopText)
+			}
+		}
+	}
+
+	add(my.Names[RoleUser])
+	add(my.Names[RoleAssistant])
+
+	var others = make([]string, 0, len(my.Names))
+	for role := range my.Names {
+		if role != RoleUser && role != RoleAssistant {
+			others = append(others, role)
+		}
+	}
+
+	slices.Sort(others)
+	for _, role := range others {
+		add(my.Names[role])
+	}
+
+	return stopTexts
+}
+
+// TextTemplate is a custom ChatTemplate written with text/template, the data is
+// struct{ Messages []Message; Generation bool }
+type TextTemplate struct {
+	template  *template.Template
+	stopTexts []string
+}
+
+type textTemplateData struct {
+	Messages   []Message
+	Generation bool
+}
+
+func NewTextTemplate(text string, stopTexts []string) (*TextTemplate, error) {
+	var tpl, err = template.New("chat").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TextTemplate{template: tpl, stopTexts: stopTexts}, nil
+}
+
+func (my *TextTemplate) Render(messages []Message, generation bool) (string, error) {
+	var sb strings.Builder
+	var err = my.template.Execute(&sb, textTemplateData{Messages: messages, Generation: generation})
+	return sb.String(), err
+}
+
+func (my *TextTemplate) StopTexts() []string {
+	return my.stopTexts
+}
+
+var (
+	// WorldTemplate is the chat format of RWKV World models
+	WorldTemplate ChatTemplate = &RoleTemplate{
+		Names: map[string]string{RoleSystem: "System", RoleUser: "User", RoleAssistant: "Assistant"},
+	}
+
+	// RavenTemplate is the chat format of RWKV Raven models, system messages are plain paragraphs
+	RavenTemplate ChatTemplate = &RoleTemplate{
+		Names: map[string]string{RoleUser: "Bob", RoleAssistant: "Alice"},
+	}
+
+	// InstructTemplate is the instruction/response format, the role "input" renders the optional `Input:` part
+	InstructTemplate ChatTemplate = &RoleTemplate{
+		Names: map[string]string{RoleUser: "Instruction", "input": "Input", RoleAssistant: "Response"},
+	}
+)
+
+var chatTemplates = struct {
+	sync.RWMutex
+	items map[string]ChatTemplate
+}{
+	items: map[string]ChatTemplate{
+		"world":    WorldTemplate,
+		"raven":    RavenTemplate,
+		"instruct": InstructTemplate,
+	},
+}
+
+// RegisterChatTemplate registers template by name, replacing the one with the same name
+func RegisterChatTemplate(name string, template ChatTemplate) {
+	chatTemplates.Lock()
+	chatTemplates.items[name] = template
+	chatTemplates.Unlock()
+}
+
+// GetChatTemplate returns the template registered by name, "world", "raven" and "instruct" are built in
+func GetChatTemplate(name string) (ChatTemplate, bool) {
+	chatTemplates.RLock()
+	defer chatTemplates.RUnlock()
+
+	var template, ok = chatTemplates.items[name]
+	return template, ok
+}
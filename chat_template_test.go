@@ -0,0 +1,57 @@
+package rwkv
+
+import (
+	"slices"
+	"testing"
+)
+
+/********************************************************************
+created:    2026-10-18
+author:     lixianmin
+
+Copyright (C) - All Rights Reserved
+*********************************************************************/
+
+func TestChatTemplates(t *testing.T) {
+	var messages = []Message{
+		{Role: RoleSystem, Content: "Be brief."},
+		{Role: RoleUser, Content: "hi\r\n\r\n\r\nthere "},
+		{Role: RoleAssistant, Content: "hello"},
+		{Role: RoleUser, Content: "how are you?"},
+	}
+
+	var world, _ = GetChatTemplate("world")
+	var text, err = world.Render(messages, true)
+	assert(t, err == nil)
+	assert(t, text == "System: Be brief.\n\nUser: hi\nthere\n\nAssistant: hello\n\nUser: how are you?\n\nAssistant:", text)
+	assert(t, slices.Equal(world.StopTexts(), []string{"\n\n", "User: ", "Assistant: ", "System: "}))
+
+	text, _ = RavenTemplate.Render(messages[:2], true)
+	assert(t, text == "Be brief.\n\nBob: hi\nthere\n\nAlice:", text)
+
+	text, _ = InstructTemplate.Render([]Message{{Role: RoleUser, Content: "Translate"}, {Role: "input", Content: "你好"}}, true)
+	assert(t, text == "Instruction: Translate\n\nInput: 你好\n\nResponse:", text)
+
+	// the format Chatbot has always used
+	var legacy = NewRoleTemplate("果果", "初音未来")
+	text, _ = legacy.Render([]Message{{Role: RoleUser, Content: "你在做什么呢?"}}, true)
+	assert(t, text == "果果: 你在做什么呢?\n\n初音未来: ", text)
+	assert(t, slices.Equal(legacy.StopTexts(), []string{"\n\n", "果果: ", "初音未来: "}))
+}
+
+func TestTextTemplate(t *testing.T) {
+	var custom, err = NewTextTemplate(`{{range .Messages}}<|{{.Role}}|>{{.Content}}
+{{end}}{{if .Generation}}<|assistant|>{{end}}`, []string{"<|user|>"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	RegisterChatTemplate("custom", custom)
+	var registered, ok = GetChatTemplate("custom")
+	assert(t, ok)
+
+	var text, _ = registered.Render([]Message{{Role: RoleUser, Content: "hi"}}, true)
+	assert(t, text == "<|user|>hi\n<|assistant|>", text)
+	assert(t, slices.Equal(registered.StopTexts(), []string{"<|user|>"}))
+	assert(t, messageEnd(custom) == "\n" && messageEnd(WorldTemplate) == "\n\n")
+}
@@ -0,0 +1,122 @@
+package rwkv
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+)
+
+/********************************************************************
+created:    2026-10-18
+author:     lixianmin
+
+Copyright (C) - All Rights Reserved
+*********************************************************************/
+
+func TestChat(t *testing.T) {
+	var model = newTinyChatModel(t)
+	var messages = []Message{
+		{Role: RoleSystem, Content: "Be brief."},
+		{Role: RoleUser, Content: `line\nbreak`},
+	}
+	var input = slices.Clone(messages)
+
+	var pieces []string
+	var reply, err = model.Chat(context.Background(), messages, ChatOptions{
+		Sampling: &SamplingOptions{MaxTokens: 8, Temperature: 1, TopP: 1},
+		OnToken: func(piece string) bool {
+			pieces = append(pieces, piece)
+			return true
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert(t, reply.Role == RoleAssistant)
+	assert(t, slices.Equal(messages, input), "the messages should not be modified")
+	assert(t, len(pieces) <= 8)
+
+	// the second turn starts from the cached state of the first one
+	messages = append(messages, reply, Message{Role: RoleUser, Content: "and then?"})
+	var history, _ = WorldTemplate.Render(messages[:2], false)
+	var tokens = model.Encode(history)
+	var extended, _ = WorldTemplate.Render(messages, false)
+	var count, state, _, _ = model.stateCache().lookup(model.Encode(extended))
+	assert(t, count == len(tokens) && state != nil, "the first turn should be cached")
+
+	if _, err = model.Chat(context.Background(), messages, ChatOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	assert(t, model.stateCache().len() == 2)
+
+	var ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	_, err = model.Chat(ctx, messages, ChatOptions{})
+	assert(t, errors.Is(err, context.Canceled))
+
+	_, err = model.Chat(context.Background(), nil, ChatOptions{})
+	assert(t, errors.Is(err, ErrNoMessages))
+}
+
+func TestGenerateReplyStopsAtEOS(t *testing.T) {
+	var model = newTinyChatModel(t)
+	var state, logits = model.newState()
+	logits[model.EOS()] = 1e9
+
+	var reply, stopText, err = generateReply(context.Background(), model, state, logits, replyOptions{
+		sampling: SamplingOptions{MaxTokens: 8, Temperature: 1, TopP: 1},
+	})
+	var initial, _ = model.newState()
+	assert(t, err == nil && reply == "" && stopText == "", "the reply should end at EOS")
+	assert(t, slices.Equal(state, initial), "EOS should not be fed into the state")
+}
+
+func TestChatbotProcessError(t *testing.T) {
+	var model = newTinyChatModel(t)
+	var broken, _ = NewTextTemplate(`{{range .Messages}}{{.Missing}}{{end}}`, nil)
+	var bot = NewChatbotWithTemplate(model, broken, "")
+	var state = slices.Clone(bot.state)
+
+	var reply, err = bot.ProcessWithContext(context.Background(), "hello")
+	assert(t, err != nil && reply == "", "the render error should be returned")
+	assert(t, len(bot.History()) == 0 && slices.Equal(bot.state, state), "a failed turn should not be kept")
+	assert(t, bot.Process("hello") == "")
+
+	bot = NewChatbotWithTemplate(model, WorldTemplate, "")
+	bot.SetSampling(SamplingOptions{MaxTokens: 2, Temperature: 1, TopP: 1})
+	var ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	_, err = bot.ProcessWithContext(ctx, "hello")
+	assert(t, errors.Is(err, context.Canceled) && len(bot.History()) == 0)
+}
+
+func TestStateCache(t *testing.T) {
+	var cache = newStateCache(2)
+	cache.add([]int{1, 2}, []float32{12}, []float32{0}, 0)
+	cache.add([]int{1, 2, 3}, []float32{123}, []float32{0}, 0)
+
+	var count, state, _, _ = cache.lookup([]int{1, 2, 3, 4})
+	assert(t, count == 3 && state[0] == 123, "the longest prefix should win")
+
+	state[0] = 0
+	_, state, _, _ = cache.lookup([]int{1, 2, 3})
+	assert(t, state[0] == 123, "lookup should return copies")
+
+	// [1 2] is the least recently used
+	cache.add([]int{5}, []float32{5}, []float32{0}, 0)
+	count, state, _, _ = cache.lookup([]int{1, 2})
+	assert(t, count == 0 && state == nil)
+	assert(t, cache.len() == 2)
+
+	// a state evaluated from the tuned state of before is not cached, and an old generation does not come back
+	cache.sync(1)
+	cache.add([]int{6}, []float32{6}, []float32{0}, 0)
+	cache.sync(0)
+	cache.add([]int{7}, []float32{7}, []float32{0}, 0)
+	assert(t, cache.len() == 0, "the states of an old generation should be dropped")
+	var _, _, _, generation = cache.lookup([]int{8})
+	cache.add([]int{8}, []float32{8}, []float32{0}, generation)
+	assert(t, generation == 1 && cache.len() == 1)
+}
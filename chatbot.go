@@ -1,7 +1,8 @@
 package rwkv
 
 import (
-	"fmt"
+	"context"
+	"log"
 	"slices"
 	"strings"
 )
@@ -18,29 +19,55 @@ const (
 	GEN_alpha_frequency = 0.4 // Frequency Penalty
 	GEN_penalty_decay   = 0.996
 
-	END_OF_TEXT = 0
+	END_OF_TEXT = 0 // the default end of text token, see ChatModel.EOS()
 	END_OF_LINE = 11
 
 	AVOID_REPEAT = "，：？！"
 )
 
+const (
+	RoleSystem    = "system"
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+)
+
+// Message is a turn of a conversation
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// Chatbot is a single conversation, every Process continues from the state left by the previous one
 type Chatbot struct {
 	model             *ChatModel
-	userName          string
-	botName           string
+	template          ChatTemplate
 	avoidRepeatTokens []int
-	promptState       []float32
+	promptTokens      []int
+	basePromptState   []float32 // the state after the prompt
+	promptState       []float32 // the state after the prompt and the tools, where every conversation starts
+	state             []float32 // the state after the prompt and all the turns so far
+	history           []Message
+	sampling          SamplingOptions
 	stopTexts         []string
+	historyPolicy     HistoryPolicy // nil keeps all turns
+	tools             []Tool
+	retrieval         *retrieval // nil for no retrieval
 }
 
 func NewChatbot(model *ChatModel, userName string, botName string, prompt string) *Chatbot {
+	// 目前固定使用英文的:来进行分割讲话
+	return NewChatbotWithTemplate(model, NewRoleTemplate(userName, botName), prompt)
+}
+
+// NewChatbotWithTemplate creates a chatbot which renders the turns with template, and stops at its stop texts
+func NewChatbotWithTemplate(model *ChatModel, template ChatTemplate, prompt string) *Chatbot {
 	var avoidRepeatTokens = model.Encode(AVOID_REPEAT)
 	var chatbot = &Chatbot{
 		model:             model,
-		userName:          userName,
-		botName:           botName,
+		template:          template,
 		avoidRepeatTokens: avoidRepeatTokens,
-		stopTexts:         []string{"\n\n", userName + ": ", botName + ": "}, // 目前固定使用英文的:来进行分割讲话
+		sampling:          newSamplingOptions(model.options),
+		stopTexts:         template.StopTexts(),
 	}
 
 	_ = chatbot.initPrompt(prompt)
@@ -50,40 +77,224 @@ func NewChatbot(model *ChatModel, userName string, botName string, prompt string
 func (my *Chatbot) initPrompt(prompt string) error {
 	var tokens = my.model.Encode(prompt)
 	var state, _ = my.runRnn(tokens, nil, 0)
+	my.promptTokens = tokens
+	my.basePromptState = state
 	my.promptState = state
+	my.state = slices.Clone(state)
 	return nil
 }
 
+// fork returns a new conversation with the same model, names and prompt, without evaluating the prompt again
+func (my *Chatbot) fork() *Chatbot {
+	return &Chatbot{
+		model:             my.model,
+		template:          my.template,
+		avoidRepeatTokens: my.avoidRepeatTokens,
+		promptTokens:      my.promptTokens,
+		basePromptState:   my.basePromptState,
+		promptState:       my.promptState,
+		state:             slices.Clone(my.promptState),
+		sampling:          my.sampling,
+		stopTexts:         my.stopTexts,
+		historyPolicy:     my.historyPolicy,
+		tools:             my.tools,
+		retrieval:         my.retrieval,
+	}
+}
+
 func (my *Chatbot) runRnn(tokens []int, state []float32, newlineAdj float32) ([]float32, []float32) {
 	state, logits := my.model.EvalSequence(tokens, state)
-	logits[END_OF_LINE] += newlineAdj
-
-	var lastIndex = len(tokens) - 1
-	var last = tokens[lastIndex]
+	adjustLogits(logits, tokens, newlineAdj, my.avoidRepeatTokens)
+	return state, logits
+}
 
-	if slices.Contains(my.avoidRepeatTokens, last) {
-		logits[lastIndex] = -999999999
+// Process runs a turn of the conversation and returns the reply, or "" after logging the error when it fails. See
+// ProcessWithContext for the error.
+func (my *Chatbot) Process(message string) string {
+	var output, err = my.ProcessWithContext(context.Background(), message)
+	if err != nil {
+		log.Printf("failed to process the message: %v", err)
 	}
 
-	return state, logits
+	return output
 }
 
-func (my *Chatbot) Process(message string) string {
+// ProcessWithContext runs a turn of the conversation like Process, and returns the error of rendering the template or
+// of generating the reply. A failed turn leaves the conversation as it was.
+func (my *Chatbot) ProcessWithContext(ctx context.Context, message string) (string, error) {
 	message = strings.ReplaceAll(message, "\r\n", "\n")
 	message = strings.ReplaceAll(message, "\\n", "\n")
 	message = strings.TrimSpace(message)
 
-	var current = fmt.Sprintf("%s: %s\n\n%s: ", my.userName, message, my.botName)
-	var output = my.generate(current)
-	return output
+	var current, err = my.template.Render(my.retrieve(ctx, []Message{{Role: RoleUser, Content: message}}), true)
+	if err != nil {
+		return "", err
+	}
+
+	output, state, err := my.generate(ctx, my.state, current, my.stopTexts)
+	if err != nil {
+		return "", err
+	}
+
+	my.state = state
+	my.history = append(my.history, Message{Role: RoleUser, Content: message}, Message{Role: RoleAssistant, Content: output})
+	my.applyHistoryPolicy()
+	return output, nil
 }
 
-func (my *Chatbot) generate(text string) string {
-	var tokens = my.model.Encode(text)
+// applyHistoryPolicy cuts the history by the policy, and rebuilds the state from the prompt plus the turns left
+func (my *Chatbot) applyHistoryPolicy() {
+	if my.historyPolicy == nil {
+		return
+	}
+
+	var history, changed, err = my.historyPolicy.Apply(my, my.history)
+	if err != nil {
+		log.Printf("failed to apply the history policy: %v", err)
+		return
+	}
+
+	if !changed {
+		return
+	}
+
+	if err = my.rebuildState(history); err != nil {
+		log.Printf("failed to rebuild the state: %v", err)
+	}
+}
+
+// rebuildState replaces the state and the history with the ones of the prompt followed by history
+func (my *Chatbot) rebuildState(history []Message) error {
+	var text, err = my.template.Render(history, false)
+	if err != nil {
+		return err
+	}
+
 	var state = slices.Clone(my.promptState)
+	if text != "" {
+		state, _ = my.runRnn(my.model.Encode(text), state, 0)
+	}
+
+	my.state = state
+	my.history = history
+	return nil
+}
+
+// SetRetrieval makes the chatbot consult options.Retriever before every turn, and inject the documents found into
+// the turn. The documents are fed into the state but not kept in the history. A nil Retriever turns it off.
+func (my *Chatbot) SetRetrieval(options RetrievalOptions) error {
+	if options.Retriever == nil {
+		my.retrieval = nil
+		return nil
+	}
+
+	var retrieval, err = newRetrieval(options)
+	if err != nil {
+		return err
+	}
+
+	my.retrieval = retrieval
+	return nil
+}
+
+// retrieve returns turn with the documents relevant to it, the failures of retrieval are logged and ignored
+func (my *Chatbot) retrieve(ctx context.Context, turn []Message) []Message {
+	if my.retrieval == nil {
+		return turn
+	}
+
+	var result, err = my.retrieval.withContext(ctx, my.model, turn)
+	if err != nil {
+		log.Printf("failed to retrieve documents: %v", err)
+		return turn
+	}
+
+	return result
+}
+
+// Reset forgets the turns so far, and restarts the conversation from the prompt
+func (my *Chatbot) Reset() {
+	my.state = slices.Clone(my.promptState)
+	my.history = nil
+}
+
+// History returns the turns so far
+func (my *Chatbot) History() []Message {
+	return slices.Clone(my.history)
+}
+
+func (my *Chatbot) Sampling() SamplingOptions {
+	return my.sampling
+}
+
+// SetHistoryPolicy changes how the turns are cut as the conversation grows, nil keeps all turns
+func (my *Chatbot) SetHistoryPolicy(policy HistoryPolicy) {
+	my.historyPolicy = policy
+}
+
+// SetSampling changes the sampling parameters of the following turns
+func (my *Chatbot) SetSampling(sampling SamplingOptions) {
+	my.sampling = sampling
+}
+
+// generate continues from a copy of state with text, and returns the reply and the state after it. A reply which
+// ends at EOS or MaxTokens is followed by the end of an assistant message in the state, so that the next turn does
+// not run into it.
+func (my *Chatbot) generate(ctx context.Context, state []float32, text string, stopTexts []string) (string, []float32, error) {
+	var tokens = my.model.Encode(text)
+	state = slices.Clone(state)
 	state, logits := my.runRnn(tokens, state, -999999999)
 
-	tokens = tokens[:0]
+	var output, stopText, err = generateReply(ctx, my.model, state, logits, replyOptions{
+		sampling:          my.sampling,
+		stopTexts:         stopTexts,
+		avoidRepeatTokens: my.avoidRepeatTokens,
+	})
+
+	if err == nil && stopText == "" {
+		err = my.model.evalTokens(my.model.Encode(messageEnd(my.template)), state, logits)
+	}
+
+	return output, state, err
+}
+
+// messageEnd returns what template renders after the content of an assistant message, such as the separator
+func messageEnd(template ChatTemplate) string {
+	const content = "\x00"
+	var text, err = template.Render([]Message{{Role: RoleAssistant, Content: content}}, false)
+	var index = strings.LastIndex(text, content)
+	if err != nil || index < 0 {
+		return ""
+	}
+
+	return text[index+len(content):]
+}
+
+type replyOptions struct {
+	sampling          SamplingOptions
+	stopTexts         []string
+	avoidRepeatTokens []int
+	onPiece           func(piece string) bool // returning false stops the generation
+}
+
+// adjustLogits nudges the newline token by newlineAdj, and forbids repeating the last token if it is one of avoid
+func adjustLogits(logits []float32, tokens []int, newlineAdj float32, avoid []int) {
+	logits[END_OF_LINE] += newlineAdj
+
+	if len(tokens) > 0 {
+		var last = tokens[len(tokens)-1]
+		if slices.Contains(avoid, last) {
+			logits[last] = -999999999
+		}
+	}
+}
+
+// generateReply samples a reply from logits, and feeds the sampled tokens into state in place. The reply ends at the
+// EOS token or is cut at the first stop text, and its length is controlled by penalizing newlines early and favoring
+// them late. The stop text which ended the reply is returned too, "" when there is none.
+func generateReply(ctx context.Context, model *ChatModel, state []float32, logits []float32,
+	options replyOptions) (string, string, error) {
+	var tokens = make([]int, 0, 16)
 	var outLast = 0
 	var existing = make(map[int]float32)
 
@@ -91,10 +302,13 @@ func (my *Chatbot) generate(text string) string {
 	var chatLenLong = 150
 	var pieces = make([]string, 0, 16)
 	var stopText = ""
+	var err error
 
-	var options = my.model.options
+	for i := 0; i < options.sampling.MaxTokens; i++ {
+		if err = ctx.Err(); err != nil {
+			break
+		}
 
-	for i := 0; i < options.MaxTokens; i++ {
 		var newlineAdj float32 = 0
 		if i <= 0 {
 			newlineAdj = -999999999
@@ -110,7 +324,12 @@ func (my *Chatbot) generate(text string) string {
 			logits[k] -= GEN_alpha_presence + v*GEN_alpha_frequency
 		}
 
-		var token, _ = SampleLogits(logits, options.Temperature, options.TopP, nil)
+		var token int
+		token, err = SampleLogits(logits, options.sampling.Temperature, options.sampling.TopP, nil)
+		if err != nil || token == model.EOS() {
+			break
+		}
+
 		for t := range existing {
 			existing[t] *= GEN_penalty_decay
 		}
@@ -118,16 +337,23 @@ func (my *Chatbot) generate(text string) string {
 		existing[token] += 1
 		tokens = append(tokens, token)
 
-		state, logits = my.runRnn([]int{token}, state, newlineAdj)
-		logits[END_OF_TEXT] = -999999999 // disable <|endoftext|>
+		if err = model.evalTokens([]int{token}, state, logits); err != nil {
+			break
+		}
+
+		adjustLogits(logits, tokens, newlineAdj, options.avoidRepeatTokens)
 
-		var piece = my.model.Decode(tokens[outLast:])
+		var piece = model.Decode(tokens[outLast:])
 		if !strings.Contains(piece, "\ufffd") {
 			pieces = append(pieces, piece)
 			outLast = i + 1
+
+			if options.onPiece != nil && !options.onPiece(piece) {
+				break
+			}
 		}
 
-		stopText = my.meetStopText(pieces)
+		stopText = meetStopText(pieces, options.stopTexts)
 		if stopText != "" {
 			break
 		}
@@ -139,13 +365,13 @@ func (my *Chatbot) generate(text string) string {
 		output = output[:len(output)-len(stopText)]
 	}
 
-	return output
+	return output, stopText, err
 }
 
 // 发现stopText尾巴, 就代表要结束了
-func (my *Chatbot) meetStopText(pieces []string) string {
-	for _, stopText := range my.stopTexts {
-		if isEndsWith(pieces, stopText) {
+func meetStopText(pieces []string, stopTexts []string) string {
+	for _, stopText := range stopTexts {
+		if stopText != "" && isEndsWith(pieces, stopText) {
 			return stopText
 		}
 	}
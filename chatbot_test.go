@@ -1,7 +1,10 @@
 package rwkv
 
 import (
+	"context"
 	"fmt"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 )
@@ -46,3 +49,23 @@ func TestNewChatbot(t *testing.T) {
 		fmt.Printf("deltaTime=%v, 初音未来：%s\n", duration, output)
 	}
 }
+
+func TestChatbotSeparatesTurns(t *testing.T) {
+	var model = newTinyChatModel(t)
+	var script = newScriptedRwkv(model, "I am fine.")
+	var bot = NewChatbotWithTemplate(model, WorldTemplate, "")
+	bot.SetSampling(SamplingOptions{MaxTokens: 64, Temperature: 1, TopP: 1})
+
+	// the reply ends at EOS, which leaves no separator in the sampled tokens
+	var reply, err = bot.ProcessWithContext(context.Background(), "How are you?")
+	assert(t, err == nil && reply == "I am fine.", reply)
+	var separator = model.Encode("\n\n")
+	var fed = script.fed[len(script.fed)-len(separator):]
+	assert(t, slices.Equal(fed, separator), "the separator should be evaluated after the reply")
+
+	var count = len(script.fed)
+	_, err = bot.ProcessWithContext(context.Background(), "And you?")
+	assert(t, err == nil)
+	var text = model.Decode(script.fed[count-len(separator)-1:])
+	assert(t, strings.HasPrefix(text, ".\n\nUser: And you?\n\nAssistant:"), text)
+}
@@ -0,0 +1,55 @@
+// Command rwkv-lora-merge merges a LoRA adapter in safetensors into a FP32 or FP16 model file of rwkv.cpp:
+//
+//	rwkv-lora-merge [-alpha 32] base.bin adapter.safetensors merged.bin
+//
+// The merged file loads by NewChatModel, or quantizes by rwkv-quantize.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/lixianmin/rwkv.go"
+)
+
+/********************************************************************
+created:    2026-10-18
+author:     lixianmin
+
+Copyright (C) - All Rights Reserved
+*********************************************************************/
+
+func main() {
+	var alpha = flag.Float64("alpha", 0, "the lora_alpha of training, 0 reads it from the metadata of the adapter")
+	flag.Usage = func() {
+		_, _ = fmt.Fprintln(flag.CommandLine.Output(), "usage: rwkv-lora-merge [flags] base adapter.safetensors out")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 3 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var adapter, err = rwkv.LoadLoraAdapter(flag.Arg(1), rwkv.LoraOptions{Alpha: *alpha})
+	if err != nil {
+		exit(err)
+	}
+
+	for _, name := range adapter.Ignored() {
+		_, _ = fmt.Fprintln(os.Stderr, "rwkv-lora-merge: ignored", name, "which is not a LoRA matrix")
+	}
+
+	if err = rwkv.MergeLoraModelFile(flag.Arg(0), flag.Arg(2), adapter); err != nil {
+		exit(err)
+	}
+
+	fmt.Printf("merged %d weights into %s\n", len(adapter.Targets()), flag.Arg(2))
+}
+
+func exit(err error) {
+	_, _ = fmt.Fprintln(os.Stderr, "rwkv-lora-merge:", err)
+	os.Exit(1)
+}
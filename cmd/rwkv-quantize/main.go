@@ -0,0 +1,65 @@
+// Command rwkv-quantize quantizes a FP32 or FP16 model file of rwkv.cpp in pure Go:
+//
+//	rwkv-quantize [-embeddings] [-plan rules] in.bin out.bin Q5_1
+//
+// The rules of -plan override the format for the matrices they match, the first matching rule wins:
+//
+//	rwkv-quantize -plan 'head.weight=Q8_0,blocks.0.*=FP16,blocks.23.*=FP16' in.bin out.bin Q4_0
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/lixianmin/rwkv.go"
+)
+
+/********************************************************************
+created:    2026-10-18
+author:     lixianmin
+
+Copyright (C) - All Rights Reserved
+*********************************************************************/
+
+func main() {
+	var embeddings = flag.Bool("embeddings", false, "quantize emb.weight and head.weight too")
+	var planText = flag.String("plan", "", "comma separated pattern=format rules, such as head.weight=Q8_0,blocks.0.*=FP16")
+	var quiet = flag.Bool("quiet", false, "do not print the report")
+	flag.Usage = func() {
+		_, _ = fmt.Fprintln(flag.CommandLine.Output(), "usage: rwkv-quantize [flags] in out Q4_0|Q4_1|Q5_0|Q5_1|Q8_0")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 3 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var plan, err = rwkv.ParseQuantizePlan(*planText)
+	if err != nil {
+		exit(err)
+	}
+
+	var options = rwkv.QuantizeOptions{
+		Format:             rwkv.QuantizedFormat(strings.ToUpper(flag.Arg(2))),
+		QuantizeEmbeddings: *embeddings,
+		Plan:               plan,
+	}
+
+	report, err := rwkv.QuantizeModelFile(flag.Arg(0), flag.Arg(1), options)
+	if err != nil {
+		exit(err)
+	}
+
+	if !*quiet {
+		fmt.Print(report.String())
+	}
+}
+
+func exit(err error) {
+	_, _ = fmt.Fprintln(os.Stderr, "rwkv-quantize:", err)
+	os.Exit(1)
+}
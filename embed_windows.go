@@ -52,6 +52,5 @@ func getDl(gpu bool) []byte {
 		return libRwkvAvx
 	}
 	//return libRwkvHipLAS
-	panic("Automatic loading of dynamic library failed, please use `NewRwkvModel` method load manually. ")
-	return nil
+	return nil // every embedded library needs AVX
 }
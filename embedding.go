@@ -0,0 +1,117 @@
+package rwkv
+
+import (
+	"errors"
+	"math"
+)
+
+/********************************************************************
+created:    2026-10-18
+author:     lixianmin
+
+Copyright (C) - All Rights Reserved
+*********************************************************************/
+
+// Pooling decides how the vectors of the tokens are combined into one
+type Pooling int
+
+const (
+	PoolingMean Pooling = iota // the average over all tokens
+	PoolingLast                // the vector after the last token, which the recurrence has already summarized
+)
+
+var ErrEmptyText = errors.New("the text has no token")
+
+type EmbeddingOptions struct {
+	Layer     int        // the layer to read, a negative one counts from the last layer
+	Field     StateField // the state field to read, StateAttHeads is flattened
+	Pooling   Pooling
+	Normalize bool // scales the embedding to unit L2 norm, so that a dot product is the cosine similarity
+}
+
+// DefaultEmbeddingOptions reads ffn_xx of the last layer, averaged over the tokens and normalized
+var DefaultEmbeddingOptions = EmbeddingOptions{Layer: -1, Field: StateFfnX, Pooling: PoolingMean, Normalize: true}
+
+// StateLayout returns where the fields of every layer are in the states of the model
+func (my *ChatModel) StateLayout() (StateLayout, error) {
+	my.lock.Lock()
+	defer my.lock.Unlock()
+
+	if err := hasCtx(my.ctx); err != nil {
+		return StateLayout{}, err
+	}
+
+	var nLayer = int(my.cRwkv.RwkvGetNLayer(my.ctx))
+	var nEmbed = int(my.cRwkv.RwkvGetNEmbedding(my.ctx))
+	var stateLength = int(my.cRwkv.RwkvGetStateLength(my.ctx))
+	return NewStateLayout(nLayer, nEmbed, stateLength)
+}
+
+// Embed evaluates text from a fresh state, and returns a field of the hidden state as the embedding of text. It
+// needs no second model for semantic search, texts with close embeddings tend to be close in meaning.
+func (my *ChatModel) Embed(text string, options EmbeddingOptions) ([]float32, error) {
+	var layout, err = my.StateLayout()
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, err := my.tokenizer.Encode(text)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tokens) == 0 {
+		return nil, ErrEmptyText
+	}
+
+	var state, logits = my.newState()
+	var field []float32
+	if field, err = layout.Field(state, options.Layer, options.Field); err != nil {
+		return nil, err
+	}
+
+	var embedding = make([]float32, len(field))
+	for i, token := range tokens {
+		if err = my.evalTokens([]int{token}, state, logits); err != nil {
+			return nil, err
+		}
+
+		if options.Pooling == PoolingMean {
+			for j, v := range field {
+				embedding[j] += v
+			}
+		} else if i == len(tokens)-1 {
+			copy(embedding, field)
+		}
+	}
+
+	if options.Pooling == PoolingMean {
+		var scale = 1 / float32(len(tokens))
+		for j := range embedding {
+			embedding[j] *= scale
+		}
+	}
+
+	if options.Normalize {
+		normalizeL2(embedding)
+	}
+
+	return embedding, nil
+}
+
+// normalizeL2 scales vector to unit L2 norm in place, a zero vector is left as it is
+func normalizeL2(vector []float32) {
+	var sum float64
+	for _, v := range vector {
+		sum += float64(v) * float64(v)
+	}
+
+	if sum == 0 {
+		return
+	}
+
+	var scale = float32(1 / math.Sqrt(sum))
+	for i := range vector {
+		vector[i] *= scale
+	}
+}
@@ -0,0 +1,67 @@
+package rwkv
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+/********************************************************************
+created:    2026-10-18
+author:     lixianmin
+
+Copyright (C) - All Rights Reserved
+*********************************************************************/
+
+func TestStateLayout(t *testing.T) {
+	var v4, err = NewStateLayout(2, 4, 2*5*4)
+	assert(t, err == nil && v4.IsV4())
+
+	var state = make([]float32, v4.StateLength())
+	for i := range state {
+		state[i] = float32(i)
+	}
+
+	var field, _ = v4.Field(state, -1, StateAttP)
+	assert(t, len(field) == 4 && field[0] == 36)
+	_, err = v4.Field(state, 0, StateAttHeads)
+	assert(t, errors.Is(err, ErrInvalidStateField))
+
+	// v5 with head size 2: ffn_xx, att_xx and 4*2 elements of heads per layer
+	v5, err := NewStateLayout(2, 4, 2*(2*4+4*2))
+	assert(t, err == nil && !v5.IsV4())
+	field, _ = v5.Field(make([]float32, v5.StateLength()), 1, StateAttHeads)
+	assert(t, len(field) == 8)
+	_, err = v5.Field(state, 0, StateAttA)
+	assert(t, errors.Is(err, ErrInvalidStateField))
+
+	_, err = NewStateLayout(2, 4, 2*7)
+	assert(t, errors.Is(err, ErrInvalidStateLayout))
+}
+
+func TestEmbed(t *testing.T) {
+	var model = newTinyChatModel(t)
+	var layout, err = model.StateLayout()
+	assert(t, err == nil && layout.IsV4() && layout.NLayer == 2 && layout.NEmbed == 32)
+
+	// rwkv.cpp initializes att_pp to -1e30, which tells that the layout matches
+	var state, _ = model.newState()
+	var pp, _ = layout.Field(state, 1, StateAttP)
+	assert(t, pp[0] < -1e29)
+
+	embedding, err := model.Embed("the quick brown fox", DefaultEmbeddingOptions)
+	assert(t, err == nil && len(embedding) == 32)
+
+	var norm float64
+	for _, v := range embedding {
+		norm += float64(v) * float64(v)
+	}
+	assert(t, math.Abs(norm-1) < 1e-4, "the embedding should be normalized")
+
+	var options = EmbeddingOptions{Layer: 0, Field: StateAttX, Pooling: PoolingLast}
+	last, err := model.Embed("the quick brown fox", options)
+	assert(t, err == nil && len(last) == 32)
+
+	_, err = model.Embed("", DefaultEmbeddingOptions)
+	assert(t, errors.Is(err, ErrEmptyText))
+}
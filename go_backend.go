@@ -0,0 +1,302 @@
+package rwkv
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"runtime"
+	"sync"
+)
+
+/********************************************************************
+created:    2026-10-18
+author:     lixianmin
+
+Copyright (C) - All Rights Reserved
+*********************************************************************/
+
+// Backend is the implementation of CRwkv which a model runs on
+type Backend int
+
+const (
+	BackendC  Backend = iota // rwkv.cpp, the dynamic library embedded in this package
+	BackendGo                // the pure Go implementation, which needs no dynamic library but runs slower
+)
+
+// newBackend returns the CRwkv of options, and the path of the dynamic library dumped for it
+func newBackend(options *RwkvOptions) (CRwkv, string, error) {
+	if options.Backend == BackendGo {
+		return NewGoRwkv(), "", nil
+	}
+
+	file, err := dumpRwkvLibrary(options.GpuEnable)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var dylibPath = file.Name()
+	cRwkv, err := NewCRwkv(dylibPath)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return cRwkv, dylibPath, nil
+}
+
+// GoRwkvImpl runs RWKV v4, v5 and v6 models of FP32, FP16 or any QuantizedFormat in pure Go, implementing CRwkv so
+// that the models work the same as on rwkv.cpp. It needs no dynamic library, which suits the platforms rwkv.cpp is
+// not built for.
+type GoRwkvImpl struct {
+	lock        sync.Mutex
+	contexts    map[uintptr]*goContext
+	nextHandle  uintptr
+	printErrors bool  // the setting of new contexts and of loading
+	lastError   error // the error of loading
+}
+
+type goContext struct {
+	model       *goModel
+	threads     int
+	printErrors bool
+	lastError   error
+	scratch     *goScratch
+}
+
+// ErrBackendUnsupported is returned for BackendC on the platforms without an embedded rwkv.cpp library, such as
+// linux/arm64, 32-bit ones and freebsd, which run on BackendGo only
+var ErrBackendUnsupported = errors.New("rwkv.cpp cannot be loaded on this platform, use BackendGo")
+
+var (
+	errGoInvalidContext = errors.New("the context is invalid or freed")
+	errGoInvalidBuffer  = errors.New("the state or logits buffer is too small")
+	errGoInvalidToken   = errors.New("the token is out of the vocabulary")
+)
+
+func NewGoRwkv() *GoRwkvImpl {
+	return &GoRwkvImpl{contexts: make(map[uintptr]*goContext), printErrors: true}
+}
+
+func (my *GoRwkvImpl) get(ctx *RwkvCtx) *goContext {
+	if ctx == nil {
+		return nil
+	}
+
+	my.lock.Lock()
+	defer my.lock.Unlock()
+	return my.contexts[ctx.ctx]
+}
+
+func (my *GoRwkvImpl) add(item *goContext) *RwkvCtx {
+	my.lock.Lock()
+	defer my.lock.Unlock()
+
+	my.nextHandle++
+	my.contexts[my.nextHandle] = item
+	return &RwkvCtx{ctx: my.nextHandle}
+}
+
+// fail records err as the last error of item, or of loading when item is nil
+func (my *GoRwkvImpl) fail(item *goContext, err error) error {
+	var print bool
+	if item != nil {
+		item.lastError = err
+		print = item.printErrors
+	} else {
+		my.lock.Lock()
+		my.lastError = err
+		print = my.printErrors
+		my.lock.Unlock()
+	}
+
+	if print {
+		log.Printf("rwkv: %v", err)
+	}
+
+	return err
+}
+
+func (my *GoRwkvImpl) RwkvSetPrintErrors(ctx *RwkvCtx, enable bool) {
+	if item := my.get(ctx); item != nil {
+		item.printErrors = enable
+		return
+	}
+
+	my.lock.Lock()
+	my.printErrors = enable
+	my.lock.Unlock()
+}
+
+func (my *GoRwkvImpl) RwkvGetPrintErrors(ctx *RwkvCtx) bool {
+	if item := my.get(ctx); item != nil {
+		return item.printErrors
+	}
+
+	my.lock.Lock()
+	defer my.lock.Unlock()
+	return my.printErrors
+}
+
+func (my *GoRwkvImpl) RwkvGetLastError(ctx *RwkvCtx) error {
+	if item := my.get(ctx); item != nil {
+		var err = item.lastError
+		item.lastError = nil
+		return err
+	}
+
+	my.lock.Lock()
+	defer my.lock.Unlock()
+	var err = my.lastError
+	my.lastError = nil
+	return err
+}
+
+func (my *GoRwkvImpl) RwkvInitFromFile(filePath string, threads uint32) *RwkvCtx {
+	var model, err = loadGoModel(filePath)
+	if err != nil {
+		_ = my.fail(nil, fmt.Errorf("failed to load %s: %w", filePath, err))
+		return &RwkvCtx{}
+	}
+
+	return my.newContext(model, threads)
+}
+
+func (my *GoRwkvImpl) newContext(model *goModel, threads uint32) *RwkvCtx {
+	if threads == 0 {
+		threads = uint32(runtime.NumCPU())
+	}
+
+	return my.add(&goContext{
+		model:       model,
+		threads:     int(threads),
+		printErrors: my.RwkvGetPrintErrors(nil),
+		scratch:     newGoScratch(model),
+	})
+}
+
+func (my *GoRwkvImpl) RwkvCloneContext(ctx *RwkvCtx, threads uint32) *RwkvCtx {
+	var item = my.get(ctx)
+	if item == nil {
+		_ = my.fail(nil, errGoInvalidContext)
+		return &RwkvCtx{}
+	}
+
+	return my.newContext(item.model, threads)
+}
+
+// RwkvGpuOffloadLayers does nothing, the Go backend runs on the CPU only
+func (my *GoRwkvImpl) RwkvGpuOffloadLayers(ctx *RwkvCtx, nGpuLayers uint32) error {
+	if my.get(ctx) == nil {
+		return errGoInvalidContext
+	}
+
+	return nil
+}
+
+func (my *GoRwkvImpl) RwkvEval(ctx *RwkvCtx, token uint32, stateIn []float32, stateOut []float32, logitsOut []float32) error {
+	var item = my.get(ctx)
+	if item == nil {
+		return errGoInvalidContext
+	}
+
+	var model = item.model
+	if int(token) >= model.nVocab {
+		return my.fail(item, errGoInvalidToken)
+	}
+
+	var stateLength = model.stateLength()
+	if len(stateOut) < stateLength || len(stateIn) != 0 && len(stateIn) < stateLength ||
+		logitsOut != nil && len(logitsOut) < model.nVocab {
+		return my.fail(item, errGoInvalidBuffer)
+	}
+
+	if len(stateIn) == 0 {
+		model.initState(stateOut)
+	} else if &stateIn[0] != &stateOut[0] {
+		copy(stateOut, stateIn[:stateLength])
+	}
+
+	model.eval(int(token), stateOut[:stateLength], logitsOut, item.scratch, item.threads)
+	return nil
+}
+
+// RwkvEvalSequence evaluates token sequenceLen times, the binding passes a single token instead of an array
+func (my *GoRwkvImpl) RwkvEvalSequence(ctx *RwkvCtx, token uint32, sequenceLen uint64, stateIn []float32, stateOut []float32, logitsOut []float32) error {
+	for i := uint64(0); i < sequenceLen; i++ {
+		var err = my.RwkvEval(ctx, token, stateIn, stateOut, logitsOut)
+		if err != nil {
+			return err
+		}
+
+		stateIn = stateOut
+	}
+
+	return nil
+}
+
+func (my *GoRwkvImpl) RwkvGetNVocab(ctx *RwkvCtx) uint64 {
+	if item := my.get(ctx); item != nil {
+		return uint64(item.model.nVocab)
+	}
+
+	return 0
+}
+
+func (my *GoRwkvImpl) RwkvGetNEmbedding(ctx *RwkvCtx) uint64 {
+	if item := my.get(ctx); item != nil {
+		return uint64(item.model.nEmbed)
+	}
+
+	return 0
+}
+
+func (my *GoRwkvImpl) RwkvGetNLayer(ctx *RwkvCtx) uint64 {
+	if item := my.get(ctx); item != nil {
+		return uint64(item.model.nLayer)
+	}
+
+	return 0
+}
+
+func (my *GoRwkvImpl) RwkvGetStateLength(ctx *RwkvCtx) uint64 {
+	if item := my.get(ctx); item != nil {
+		return uint64(item.model.stateLength())
+	}
+
+	return 0
+}
+
+func (my *GoRwkvImpl) RwkvGetLogitsLength(ctx *RwkvCtx) uint64 {
+	return my.RwkvGetNVocab(ctx)
+}
+
+func (my *GoRwkvImpl) RwkvInitState(ctx *RwkvCtx, state []float32) {
+	if item := my.get(ctx); item != nil {
+		item.model.initState(state[:item.model.stateLength()])
+	}
+}
+
+func (my *GoRwkvImpl) RwkvFree(ctx *RwkvCtx) error {
+	if ctx == nil || ctx.ctx == 0 {
+		return nil
+	}
+
+	my.lock.Lock()
+	delete(my.contexts, ctx.ctx)
+	my.lock.Unlock()
+
+	ctx.ctx = 0
+	return nil
+}
+
+// RwkvQuantizeModelFile quantizes by QuantizeModelFile, which does not need ctx
+func (my *GoRwkvImpl) RwkvQuantizeModelFile(ctx *RwkvCtx, in, out string, format QuantizedFormat) error {
+	if _, err := QuantizeModelFile(in, out, QuantizeOptions{Format: format}); err != nil {
+		return my.fail(my.get(ctx), err)
+	}
+
+	return nil
+}
+
+func (my *GoRwkvImpl) RwkvGetSystemInfoString() string {
+	return fmt.Sprintf("GO = 1 | GOOS = %s | GOARCH = %s | NumCPU = %d", runtime.GOOS, runtime.GOARCH, runtime.NumCPU())
+}
@@ -0,0 +1,168 @@
+package rwkv
+
+import (
+	"context"
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+/********************************************************************
+created:    2026-10-18
+author:     lixianmin
+
+Copyright (C) - All Rights Reserved
+*********************************************************************/
+
+func TestHalfConversion(t *testing.T) {
+	for h := 0; h < 1<<16; h++ {
+		var f = halfToFloat32(uint16(h))
+		if f != f {
+			continue // nan
+		}
+
+		assert(t, float32ToHalf(f) == uint16(h), "every half should survive a round trip")
+	}
+
+	assert(t, halfToFloat32(0x3c00) == 1 && halfToFloat32(0xc000) == -2 && halfToFloat32(0x0001) == 5.960464477539063e-8)
+	assert(t, float32ToHalf(1+1.0/2048) == 0x3c00, "a tie rounds to the even mantissa")
+	assert(t, float32ToHalf(1+3.0/2048) == 0x3c02, "a tie rounds to the even mantissa")
+	assert(t, float32ToHalf(65520) == 0x7c00, "the values over the half range become inf")
+	assert(t, float32ToHalf(1e-10) == 0)
+}
+
+func TestMulVecAllocations(t *testing.T) {
+	const rows, cols = 8, 16
+	var matrix = &f16Matrix{rows: rows, cols: cols, data: make([]uint16, rows*cols)}
+	var out, x = make([]float32, rows), make([]float32, cols)
+
+	var scratch = new(goScratch)
+	var allocs = testing.AllocsPerRun(10, func() {
+		scratch.mulVec(matrix, out, x, 1)
+	})
+	assert(t, allocs == 0, "the rounded input should be kept in the scratch")
+
+	const rank = 2
+	var lora = &loraMatrix{base: matrix, layer: &loraLayer{out: rows, in: cols, rank: rank, scale: 1,
+		a: make([]float32, rank*cols), b: make([]float32, rows*rank)}}
+	allocs = testing.AllocsPerRun(10, func() {
+		scratch.mulVec(lora, out, x, 1)
+	})
+	assert(t, allocs == 0, "A·x of the adapter should be kept in the scratch")
+}
+
+// newBackendPair loads the model of path on both backends
+func newBackendPair(t *testing.T, path string) (CRwkv, *RwkvCtx, CRwkv, *RwkvCtx) {
+	var file, err = dumpRwkvLibrary(false)
+	skipUnsupported(t, err)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cBackend, err := NewCRwkv(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cCtx = cBackend.RwkvInitFromFile(path, 1)
+	assert(t, hasCtx(cCtx) == nil, "the C backend should load the fixture")
+
+	var goBackend = NewGoRwkv()
+	var goCtx = goBackend.RwkvInitFromFile(path, 2)
+	if err = hasCtx(goCtx); err != nil {
+		t.Fatal(goBackend.RwkvGetLastError(goCtx))
+	}
+
+	t.Cleanup(func() {
+		_ = goBackend.RwkvFree(goCtx)
+		_ = cBackend.RwkvFree(cCtx)
+	})
+
+	return cBackend, cCtx, goBackend, goCtx
+}
+
+// maxRelativeDiff returns the largest difference between a and b relative to the magnitude of a
+func maxRelativeDiff(a []float32, b []float32) float64 {
+	var scale, diff float64
+	for i := range a {
+		scale = math.Max(scale, math.Abs(float64(a[i])))
+		diff = math.Max(diff, math.Abs(float64(a[i]-b[i])))
+	}
+
+	return diff / math.Max(scale, 1e-6)
+}
+
+func TestGoBackendMatchesC(t *testing.T) {
+	const nVocab, nEmbed, nLayer = 256, 64, 3
+	var tensors = newTinyModelTensors(2, nVocab, nEmbed, nLayer)
+
+	for _, item := range []struct {
+		dataType  rwkvType
+		tolerance float64
+	}{
+		{rwkvTypeFP32, 1e-5},
+		{rwkvTypeFP16, 2e-3},
+	} {
+		t.Run(item.dataType.String(), func(t *testing.T) {
+			var path = filepath.Join(t.TempDir(), "tiny.bin")
+			if err := writeFixtureModelType(path, item.dataType, nVocab, nEmbed, nLayer, tensors); err != nil {
+				t.Fatal(err)
+			}
+
+			var cBackend, cCtx, goBackend, goCtx = newBackendPair(t, path)
+			assert(t, goBackend.RwkvGetStateLength(goCtx) == cBackend.RwkvGetStateLength(cCtx))
+			assert(t, goBackend.RwkvGetNVocab(goCtx) == nVocab && goBackend.RwkvGetNLayer(goCtx) == nLayer)
+
+			var stateLength = cBackend.RwkvGetStateLength(cCtx)
+			var cState, goState = make([]float32, stateLength), make([]float32, stateLength)
+			cBackend.RwkvInitState(cCtx, cState)
+			goBackend.RwkvInitState(goCtx, goState)
+			assert(t, maxRelativeDiff(cState, goState) == 0, "the initial states should be the same")
+
+			var cLogits, goLogits = make([]float32, nVocab), make([]float32, nVocab)
+			for i, token := range []uint32{1, 17, 255, 0, 42, 42, 42, 99, 3, 128, 64, 7} {
+				assert(t, cBackend.RwkvEval(cCtx, token, cState, cState, cLogits) == nil)
+				assert(t, goBackend.RwkvEval(goCtx, token, goState, goState, goLogits) == nil)
+
+				var diff = maxRelativeDiff(cLogits, goLogits)
+				if diff > item.tolerance {
+					t.Fatalf("the logits of token %d differ by %g", i, diff)
+				}
+			}
+
+			// pp is an exponent, compare the other fields of the state
+			var layout, _ = NewStateLayout(nLayer, nEmbed, int(stateLength))
+			for layer := 0; layer < nLayer; layer++ {
+				for _, field := range []StateField{StateFfnX, StateAttX} {
+					var c, _ = layout.Field(cState, layer, field)
+					var g, _ = layout.Field(goState, layer, field)
+					assert(t, maxRelativeDiff(c, g) <= item.tolerance, field.String())
+				}
+			}
+		})
+	}
+}
+
+func TestGoBackendChatModel(t *testing.T) {
+	const nVocab, nEmbed, nLayer = worldVocabSize, 32, 2
+	var path = filepath.Join(t.TempDir(), "tiny-world-fp32.bin")
+	if err := writeFixtureModel(path, nVocab, nEmbed, nLayer, newTinyModelTensors(1, nVocab, nEmbed, nLayer)); err != nil {
+		t.Fatal(err)
+	}
+
+	var model, err = NewChatModel(path, RwkvOptions{MaxTokens: 8, Temperature: 1, TopP: 1, Backend: BackendGo,
+		TokenizerType: Auto})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer model.Close()
+
+	var reply, err2 = model.Chat(context.Background(), []Message{{Role: RoleUser, Content: "hello"}}, ChatOptions{})
+	assert(t, err2 == nil && reply.Role == RoleAssistant)
+
+	var embedding, err3 = model.Embed("hello", DefaultEmbeddingOptions)
+	assert(t, err3 == nil && len(embedding) == nEmbed)
+
+	_, err = NewChatModel(filepath.Join(t.TempDir(), "missing.bin"), RwkvOptions{Backend: BackendGo})
+	assert(t, err != nil)
+}
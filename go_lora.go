@@ -0,0 +1,116 @@
+package rwkv
+
+import "fmt"
+
+/********************************************************************
+created:    2026-10-18
+author:     lixianmin
+
+Copyright (C) - All Rights Reserved
+*********************************************************************/
+
+// loraMatrix adds the delta B·A·scale of an adapter to a weight while multiplying, so that the weight is shared by
+// the models with and without the adapter
+type loraMatrix struct {
+	base  goMatrix
+	layer *loraLayer
+}
+
+func (my *loraMatrix) shape() (int, int) {
+	return my.base.shape()
+}
+
+// mulRows is only for callers without a goScratch, goScratch.mulVec computes A·x once into its buffers instead
+func (my *loraMatrix) mulRows(out []float32, x []float32, start int, end int) {
+	my.base.mulRows(out, x, start, end)
+	my.addRows(out, my.project(make([]float32, my.layer.rank), x), start, end)
+}
+
+// project writes A·x·scale into ax, and returns ax
+func (my *loraMatrix) project(ax []float32, x []float32) []float32 {
+	var layer = my.layer
+	for k := range ax {
+		ax[k] = dotFloat32(layer.a[k*layer.in:(k+1)*layer.in], x) * layer.scale
+	}
+
+	return ax
+}
+
+// addRows adds B·ax to the rows [start, end) of out
+func (my *loraMatrix) addRows(out []float32, ax []float32, start int, end int) {
+	var layer = my.layer
+	for r := start; r < end; r++ {
+		out[r] += dotFloat32(layer.b[r*layer.rank:(r+1)*layer.rank], ax)
+	}
+}
+
+func (my *loraMatrix) row(out []float32, r int) {
+	my.base.row(out, r)
+
+	var layer = my.layer
+	for k, bk := range layer.b[r*layer.rank : (r+1)*layer.rank] {
+		bk *= layer.scale
+		for i, a := range layer.a[k*layer.in : (k+1)*layer.in] {
+			out[i] += bk * a
+		}
+	}
+}
+
+// withAdapter returns a copy of my whose weights named by adapter add its deltas, the weights themselves are shared
+func (my *goModel) withAdapter(adapter *LoraAdapter) (*goModel, error) {
+	var model = *my
+	model.layers = append([]goLayer(nil), my.layers...)
+
+	var matrices = model.namedMatrices()
+	for name, layer := range adapter.layers {
+		var matrix, ok = matrices[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s is not a weight of the model", ErrInvalidLora, name)
+		}
+
+		if rows, cols := (*matrix).shape(); rows != layer.out || cols != layer.in {
+			return nil, fmt.Errorf("%w: %s is %dx%d, but the adapter is %dx%d", ErrInvalidLora, name, rows, cols,
+				layer.out, layer.in)
+		}
+
+		*matrix = &loraMatrix{base: *matrix, layer: layer}
+	}
+
+	return &model, nil
+}
+
+// namedMatrices returns the linear weights of my by their names in the model file, the ones LoRA adapts
+func (my *goModel) namedMatrices() map[string]*goMatrix {
+	var matrices = map[string]*goMatrix{"emb.weight": &my.emb, "head.weight": &my.head}
+	for i := range my.layers {
+		var prefix = fmt.Sprintf("blocks.%d.", i)
+		var layer = &my.layers[i]
+		matrices[prefix+"att.key.weight"] = &layer.attKey
+		matrices[prefix+"att.value.weight"] = &layer.attValue
+		matrices[prefix+"att.receptance.weight"] = &layer.attReceptance
+		matrices[prefix+"att.output.weight"] = &layer.attOutput
+		matrices[prefix+"ffn.key.weight"] = &layer.ffnKey
+		matrices[prefix+"ffn.value.weight"] = &layer.ffnValue
+		matrices[prefix+"ffn.receptance.weight"] = &layer.ffnReceptance
+		if layer.attGate != nil {
+			matrices[prefix+"att.gate.weight"] = &layer.attGate
+		}
+	}
+
+	return matrices
+}
+
+// adaptContext creates a context of the model of ctx, which evaluates with adapter
+func (my *GoRwkvImpl) adaptContext(ctx *RwkvCtx, adapter *LoraAdapter, threads uint32) (*RwkvCtx, error) {
+	var item = my.get(ctx)
+	if item == nil {
+		return nil, errGoInvalidContext
+	}
+
+	var model, err = item.model.withAdapter(adapter)
+	if err != nil {
+		return nil, err
+	}
+
+	return my.newContext(model, threads), nil
+}
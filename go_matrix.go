@@ -0,0 +1,230 @@
+package rwkv
+
+import (
+	"encoding/binary"
+	"math"
+	"sync"
+)
+
+/********************************************************************
+created:    2026-10-18
+author:     lixianmin
+
+Copyright (C) - All Rights Reserved
+*********************************************************************/
+
+// the matrices smaller than this are multiplied in one goroutine, as starting more costs more than it saves
+const parallelMinElements = 1 << 16
+
+// goMatrix is a weight matrix of the Go backend, stored in the data type of the model file
+type goMatrix interface {
+	shape() (rows int, cols int)
+
+	// mulRows writes the dot products of the rows [start, end) with x into out[start:end]
+	mulRows(out []float32, x []float32, start int, end int)
+
+	// row decodes the row r into out
+	row(out []float32, r int)
+}
+
+// mulVec computes out = m·x, splitting the rows among threads goroutines. The buffers it needs are kept in my.
+func (my *goScratch) mulVec(m goMatrix, out []float32, x []float32, threads int) {
+	var rows, cols = m.shape()
+	var base = m
+	var lora, _ = m.(*loraMatrix)
+	if lora != nil {
+		base = lora.base
+	}
+
+	if _, ok := base.(*f16Matrix); ok {
+		// ggml multiplies FP16 weights with x rounded to FP16, do the same to get the same logits
+		my.rounded = roundToHalf(growFloat32s(my.rounded, len(x)), x)
+		x = my.rounded
+	}
+
+	// A·x of an adapter is small, it is computed once for all goroutines
+	var ax []float32
+	if lora != nil {
+		my.ax = lora.project(growFloat32s(my.ax, lora.layer.rank), x)
+		ax = my.ax
+	}
+
+	if threads <= 1 || rows*cols < parallelMinElements {
+		mulRowsWithDelta(base, lora, out, x, ax, 0, rows)
+		return
+	}
+
+	mulRowsInParallel(base, lora, out, x, ax, rows, threads)
+}
+
+// mulRowsInParallel splits the rows among threads goroutines. It is out of mulVec, whose variables captured by the
+// goroutines would be moved to the heap on every call.
+func mulRowsInParallel(base goMatrix, lora *loraMatrix, out []float32, x []float32, ax []float32, rows int,
+	threads int) {
+	var wg sync.WaitGroup
+	var step = (rows + threads - 1) / threads
+	for start := 0; start < rows; start += step {
+		wg.Add(1)
+		go func(start int, end int) {
+			defer wg.Done()
+			mulRowsWithDelta(base, lora, out, x, ax, start, end)
+		}(start, min(start+step, rows))
+	}
+	wg.Wait()
+}
+
+// mulRowsWithDelta multiplies the rows [start, end) of base, and adds the delta of lora from ax = A·x when lora is
+// not nil
+func mulRowsWithDelta(base goMatrix, lora *loraMatrix, out []float32, x []float32, ax []float32, start int, end int) {
+	base.mulRows(out, x, start, end)
+	if lora != nil {
+		lora.addRows(out, ax, start, end)
+	}
+}
+
+func newGoMatrix(tensor *rwkvTensor) (goMatrix, error) {
+	switch tensor.DataType {
+	case rwkvTypeFP32:
+		var data, err = tensor.float32s()
+		if err != nil {
+			return nil, err
+		}
+		return &f32Matrix{rows: tensor.Height * tensor.Depth, cols: tensor.Width, data: data}, nil
+	case rwkvTypeFP16:
+		var data = make([]uint16, tensor.elements())
+		for i := range data {
+			data[i] = binary.LittleEndian.Uint16(tensor.Data[i*2:])
+		}
+		return &f16Matrix{rows: tensor.Height * tensor.Depth, cols: tensor.Width, data: data}, nil
+	default:
+		return newQuantMatrix(tensor)
+	}
+}
+
+type f32Matrix struct {
+	rows int
+	cols int
+	data []float32
+}
+
+func (my *f32Matrix) shape() (int, int) {
+	return my.rows, my.cols
+}
+
+func (my *f32Matrix) mulRows(out []float32, x []float32, start int, end int) {
+	for r := start; r < end; r++ {
+		out[r] = dotFloat32(my.data[r*my.cols:(r+1)*my.cols], x)
+	}
+}
+
+func (my *f32Matrix) row(out []float32, r int) {
+	copy(out, my.data[r*my.cols:(r+1)*my.cols])
+}
+
+type f16Matrix struct {
+	rows int
+	cols int
+	data []uint16
+}
+
+func (my *f16Matrix) shape() (int, int) {
+	return my.rows, my.cols
+}
+
+func (my *f16Matrix) mulRows(out []float32, x []float32, start int, end int) {
+	for r := start; r < end; r++ {
+		var row = my.data[r*my.cols : (r+1)*my.cols]
+		var sum float32
+		for i, h := range row {
+			sum += halfToFloat32(h) * x[i]
+		}
+		out[r] = sum
+	}
+}
+
+func (my *f16Matrix) row(out []float32, r int) {
+	for i, h := range my.data[r*my.cols : (r+1)*my.cols] {
+		out[i] = halfToFloat32(h)
+	}
+}
+
+// roundToHalf writes x rounded to FP16 into out, and returns out
+func roundToHalf(out []float32, x []float32) []float32 {
+	for i, v := range x {
+		out[i] = halfToFloat32(float32ToHalf(v))
+	}
+
+	return out
+}
+
+// growFloat32s returns buffer resized to n, reallocated only when it is too small
+func growFloat32s(buffer []float32, n int) []float32 {
+	if cap(buffer) < n {
+		return make([]float32, n)
+	}
+
+	return buffer[:n]
+}
+
+// dotFloat32 returns the dot product of a and b, unrolled by 4 which lets the compiler drop the bound checks
+func dotFloat32(a []float32, b []float32) float32 {
+	b = b[:len(a)]
+	var s0, s1, s2, s3 float32
+	var i = 0
+	for ; i+4 <= len(a); i += 4 {
+		s0 += a[i] * b[i]
+		s1 += a[i+1] * b[i+1]
+		s2 += a[i+2] * b[i+2]
+		s3 += a[i+3] * b[i+3]
+	}
+
+	for ; i < len(a); i++ {
+		s0 += a[i] * b[i]
+	}
+
+	return s0 + s1 + s2 + s3
+}
+
+func sigmoid(x float32) float32 {
+	return float32(1 / (1 + math.Exp(-float64(x))))
+}
+
+// layerNorm writes the normalized x scaled by weight and shifted by bias into out, the same as ggml_norm
+func layerNorm(out []float32, x []float32, weight []float32, bias []float32) {
+	normalize(out, x, weight, bias, 1e-5)
+}
+
+// groupNorm normalizes every one of the groups of x on its own, it is the layerNorm of the wkv heads
+func groupNorm(out []float32, x []float32, weight []float32, bias []float32, groups int, eps float64) {
+	var size = len(x) / groups
+	for i := 0; i < len(x); i += size {
+		normalize(out[i:i+size], x[i:i+size], weight[i:i+size], bias[i:i+size], eps)
+	}
+}
+
+func normalize(out []float32, x []float32, weight []float32, bias []float32, eps float64) {
+	var sum float64
+	for _, v := range x {
+		sum += float64(v)
+	}
+
+	var mean = float32(sum / float64(len(x)))
+	var sum2 float64
+	for _, v := range x {
+		var d = v - mean
+		sum2 += float64(d * d)
+	}
+
+	var scale = float32(1 / math.Sqrt(sum2/float64(len(x))+eps))
+	for i, v := range x {
+		out[i] = (v-mean)*scale*weight[i] + bias[i]
+	}
+}
+
+func tanh(x float32) float32 {
+	return float32(math.Tanh(float64(x)))
+}
+
+func silu(x float32) float32 {
+	return x * sigmoid(x)
+}
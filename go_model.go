@@ -0,0 +1,646 @@
+package rwkv
+
+import (
+	"fmt"
+	"math"
+)
+
+/********************************************************************
+created:    2026-10-18
+author:     lixianmin
+
+Copyright (C) - All Rights Reserved
+*********************************************************************/
+
+// goArch is the architecture of a model, told apart by its parameters the same way as rwkv.cpp
+type goArch int
+
+const (
+	goArchV4   goArch = iota
+	goArchV5          // the wkv has heads, and the state of every head is a matrix
+	goArchV5_1        // adds the gate of the time mixing
+	goArchV5_2        // time_decay and time_faaaa have a value per channel instead of per head
+	goArchV6          // the token shift and the decay depend on the input
+)
+
+var goArchNames = [...]string{"RWKV v4", "RWKV v5", "RWKV v5.1", "RWKV v5.2", "RWKV v6"}
+
+func (arch goArch) String() string {
+	if arch >= 0 && int(arch) < len(goArchNames) {
+		return goArchNames[arch]
+	}
+
+	return fmt.Sprintf("goArch(%d)", int(arch))
+}
+
+// the group norm of the wkv heads, rwkv.cpp uses a larger eps for RWKV v6
+const (
+	goGroupNormEpsV5 = 1e-5
+	goGroupNormEpsV6 = 64e-5
+)
+
+// goModel holds the weights of a model for the Go backend, they are shared by all contexts of the model
+type goModel struct {
+	arch      goArch
+	nVocab    int
+	nEmbed    int
+	nLayer    int
+	headCount int // RWKV v5 and later
+	headSize  int
+
+	emb         goMatrix
+	ln0Weight   []float32
+	ln0Bias     []float32
+	layers      []goLayer
+	lnOutWeight []float32
+	lnOutBias   []float32
+	head        goMatrix
+}
+
+type goLayer struct {
+	ln1Weight []float32
+	ln1Bias   []float32
+	ln2Weight []float32
+	ln2Bias   []float32
+
+	attTimeMixK   []float32
+	attTimeMixV   []float32
+	attTimeMixR   []float32
+	attTimeFirst  []float32 // time_faaaa since RWKV v5
+	attTimeDecay  []float32 // -exp(w) for RWKV v4 and exp(-exp(w)) for RWKV v5 as the converter stores them, w for RWKV v6
+	attKey        goMatrix
+	attValue      goMatrix
+	attReceptance goMatrix
+	attOutput     goMatrix
+
+	// RWKV v5 and later
+	attTimeMixG []float32
+	attGate     goMatrix // nil for RWKV v5
+	lnXWeight   []float32
+	lnXBias     []float32
+
+	// RWKV v6, the matrices are transposed by the converter so that the rows are the outputs
+	attTimeMaaX    []float32
+	attTimeMaaW    []float32
+	attTimeMaaK    []float32
+	attTimeMaaV    []float32
+	attTimeMaaR    []float32
+	attTimeMaaG    []float32
+	attTimeMaaW1   goMatrix   // projects the input into 5 vectors of a small dimension
+	attTimeMaaW2   []goMatrix // projects the 5 vectors back to the offsets of w, k, v, r and g
+	attTimeDecayW1 goMatrix
+	attTimeDecayW2 goMatrix
+
+	ffnTimeMixK   []float32 // 1-time_maa_k for RWKV v6, which mixes the other way round
+	ffnTimeMixR   []float32
+	ffnKey        goMatrix
+	ffnValue      goMatrix
+	ffnReceptance goMatrix
+}
+
+// the state of every layer is ffn_xx, att_xx, att_aa, att_bb and att_pp for RWKV v4, each of n_embed elements
+const goStateFields = 5
+
+func loadGoModel(path string) (*goModel, error) {
+	var header, tensors, err = readRwkvModelFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var params = make(map[string]*rwkvTensor, len(tensors))
+	for i := range tensors {
+		params[tensors[i].Name] = &tensors[i]
+	}
+
+	var model = &goModel{nVocab: int(header.NVocab), nEmbed: int(header.NEmbed), nLayer: int(header.NLayer)}
+	if model.nVocab <= 0 || model.nEmbed <= 0 || model.nLayer <= 0 {
+		return nil, fmt.Errorf("%w: the model has %d tokens, %d embeddings and %d layers", ErrInvalidModelFile,
+			model.nVocab, model.nEmbed, model.nLayer)
+	}
+
+	// RWKV v5.1 adds the gate to v5, and v5.2 has time_decay per channel instead of per head
+	var decay = params["blocks.0.att.time_decay"]
+	switch {
+	case params["blocks.0.att.time_maa_x"] != nil:
+		model.arch = goArchV6
+	case params["blocks.0.att.ln_x.weight"] == nil:
+		model.arch = goArchV4
+	case params["blocks.0.att.gate.weight"] == nil:
+		model.arch = goArchV5
+	case decay != nil && decay.elements() == model.nEmbed:
+		model.arch = goArchV5_2
+	default:
+		model.arch = goArchV5_1
+	}
+
+	if model.arch != goArchV4 {
+		if err = model.setHeads(params["blocks.0.att.time_faaaa"]); err != nil {
+			return nil, err
+		}
+	}
+
+	var loader = goModelLoader{params: params, nEmbed: model.nEmbed}
+	model.emb = loader.matrix("emb.weight", model.nVocab, model.nEmbed)
+	model.ln0Weight = loader.vector("blocks.0.ln0.weight")
+	model.ln0Bias = loader.vector("blocks.0.ln0.bias")
+	model.lnOutWeight = loader.vector("ln_out.weight")
+	model.lnOutBias = loader.vector("ln_out.bias")
+	model.head = loader.matrix("head.weight", model.nVocab, model.nEmbed)
+
+	var n = model.nEmbed
+	var nFfn = loader.rows("blocks.0.ffn.key.weight", n*4)
+	model.layers = make([]goLayer, model.nLayer)
+	for i := range model.layers {
+		var prefix = fmt.Sprintf("blocks.%d.", i)
+		var layer = &model.layers[i]
+		layer.ln1Weight = loader.vector(prefix + "ln1.weight")
+		layer.ln1Bias = loader.vector(prefix + "ln1.bias")
+		layer.ln2Weight = loader.vector(prefix + "ln2.weight")
+		layer.ln2Bias = loader.vector(prefix + "ln2.bias")
+
+		layer.attTimeDecay = loader.perChannel(prefix+"att.time_decay", model.headCount)
+		layer.attKey = loader.matrix(prefix+"att.key.weight", n, n)
+		layer.attValue = loader.matrix(prefix+"att.value.weight", n, n)
+		layer.attReceptance = loader.matrix(prefix+"att.receptance.weight", n, n)
+		layer.attOutput = loader.matrix(prefix+"att.output.weight", n, n)
+
+		layer.ffnKey = loader.matrix(prefix+"ffn.key.weight", nFfn, n)
+		layer.ffnValue = loader.matrix(prefix+"ffn.value.weight", n, nFfn)
+		layer.ffnReceptance = loader.matrix(prefix+"ffn.receptance.weight", n, n)
+
+		if model.arch == goArchV4 {
+			layer.attTimeFirst = loader.vector(prefix + "att.time_first")
+		} else {
+			layer.attTimeFirst = loader.perChannel(prefix+"att.time_faaaa", model.headCount)
+			layer.lnXWeight = loader.vector(prefix + "att.ln_x.weight")
+			layer.lnXBias = loader.vector(prefix + "att.ln_x.bias")
+		}
+
+		if model.arch >= goArchV5_1 {
+			layer.attGate = loader.matrix(prefix+"att.gate.weight", n, n)
+		}
+
+		if model.arch != goArchV6 {
+			layer.attTimeMixK = loader.vector(prefix + "att.time_mix_k")
+			layer.attTimeMixV = loader.vector(prefix + "att.time_mix_v")
+			layer.attTimeMixR = loader.vector(prefix + "att.time_mix_r")
+			layer.ffnTimeMixK = loader.vector(prefix + "ffn.time_mix_k")
+			layer.ffnTimeMixR = loader.vector(prefix + "ffn.time_mix_r")
+			if model.arch >= goArchV5_1 {
+				layer.attTimeMixG = loader.vector(prefix + "att.time_mix_g")
+			}
+			continue
+		}
+
+		var mixExtra = loader.rows(prefix+"att.time_maa_w1", 0) / 5
+		var decayExtra = loader.rows(prefix+"att.time_decay_w1", 0)
+		layer.attTimeMaaX = loader.vector(prefix + "att.time_maa_x")
+		layer.attTimeMaaW = loader.vector(prefix + "att.time_maa_w")
+		layer.attTimeMaaK = loader.vector(prefix + "att.time_maa_k")
+		layer.attTimeMaaV = loader.vector(prefix + "att.time_maa_v")
+		layer.attTimeMaaR = loader.vector(prefix + "att.time_maa_r")
+		layer.attTimeMaaG = loader.vector(prefix + "att.time_maa_g")
+		layer.attTimeMaaW1 = loader.matrix(prefix+"att.time_maa_w1", 5*mixExtra, n)
+		layer.attTimeMaaW2 = loader.matrices(prefix+"att.time_maa_w2", 5, n, mixExtra)
+		layer.attTimeDecayW1 = loader.matrix(prefix+"att.time_decay_w1", decayExtra, n)
+		layer.attTimeDecayW2 = loader.matrix(prefix+"att.time_decay_w2", n, decayExtra)
+		layer.ffnTimeMixK = loader.complement(prefix + "ffn.time_maa_k")
+		layer.ffnTimeMixR = loader.complement(prefix + "ffn.time_maa_r")
+	}
+
+	if loader.err != nil {
+		return nil, loader.err
+	}
+
+	return model, nil
+}
+
+// setHeads reads the head count from time_faaaa. It has a value per head before RWKV v5.2, and a value per channel
+// since, which the converter stores as (n_head, head_size) or (n_head, head_size, 1).
+func (my *goModel) setHeads(timeFirst *rwkvTensor) error {
+	if timeFirst == nil {
+		return fmt.Errorf("%w: the model is missing the parameter blocks.0.att.time_faaaa", ErrInvalidModelFile)
+	}
+
+	my.headCount = timeFirst.elements()
+	if my.headCount == my.nEmbed {
+		my.headCount = timeFirst.Height
+		if timeFirst.DimCount == 3 {
+			my.headCount = timeFirst.Depth
+		}
+	}
+
+	if my.headCount <= 0 || my.nEmbed%my.headCount != 0 {
+		return fmt.Errorf("%w: %d embeddings can not be split into %d heads", ErrInvalidModelFile, my.nEmbed, my.headCount)
+	}
+
+	my.headSize = my.nEmbed / my.headCount
+	return nil
+}
+
+// goModelLoader converts the tensors of a model file, and keeps the first error
+type goModelLoader struct {
+	params map[string]*rwkvTensor
+	nEmbed int
+	err    error
+}
+
+func (my *goModelLoader) lookup(name string) *rwkvTensor {
+	if my.err != nil {
+		return nil
+	}
+
+	var tensor, ok = my.params[name]
+	if !ok {
+		my.err = fmt.Errorf("%w: the model is missing the parameter %s", ErrInvalidModelFile, name)
+		return nil
+	}
+
+	return tensor
+}
+
+// rows returns the row count of the tensor of name, or fallback when the model does not have it
+func (my *goModelLoader) rows(name string, fallback int) int {
+	if tensor, ok := my.params[name]; ok {
+		return tensor.Height
+	}
+
+	return fallback
+}
+
+func (my *goModelLoader) tensor(name string, depth int, rows int, cols int) *rwkvTensor {
+	var tensor = my.lookup(name)
+	if tensor == nil {
+		return nil
+	}
+
+	if tensor.Depth != depth || tensor.Height != rows || tensor.Width != cols {
+		my.err = fmt.Errorf("%w: %s is %dx%dx%d, but %dx%dx%d is expected", ErrInvalidModelFile, name,
+			tensor.Depth, tensor.Height, tensor.Width, depth, rows, cols)
+		return nil
+	}
+
+	return tensor
+}
+
+// vector reads a vector of n_embed elements, the converter may keep it in any shape, such as (n_head, head_size, 1)
+func (my *goModelLoader) vector(name string) []float32 {
+	var tensor = my.lookup(name)
+	if tensor == nil {
+		return nil
+	}
+
+	if tensor.elements() != my.nEmbed {
+		my.err = fmt.Errorf("%w: %s has %d elements, but %d is expected", ErrInvalidModelFile, name, tensor.elements(), my.nEmbed)
+		return nil
+	}
+
+	var data, err = tensor.float32s()
+	if err != nil {
+		my.err = err
+	}
+
+	return data
+}
+
+// perChannel reads a vector of name which has a value per channel, or a value per head of headCount heads, which is
+// repeated for the channels of the head
+func (my *goModelLoader) perChannel(name string, headCount int) []float32 {
+	var tensor = my.lookup(name)
+	if tensor == nil || tensor.elements() != headCount || headCount == my.nEmbed {
+		return my.vector(name)
+	}
+
+	var heads, err = tensor.float32s()
+	if err != nil {
+		my.err = err
+		return nil
+	}
+
+	var data = make([]float32, my.nEmbed)
+	var headSize = my.nEmbed / headCount
+	for i := range data {
+		data[i] = heads[i/headSize]
+	}
+
+	return data
+}
+
+// complement reads a vector v of name, and returns 1-v
+func (my *goModelLoader) complement(name string) []float32 {
+	var data = my.vector(name)
+	for i, v := range data {
+		data[i] = 1 - v
+	}
+
+	return data
+}
+
+func (my *goModelLoader) matrix(name string, rows int, cols int) goMatrix {
+	var tensor = my.tensor(name, 1, rows, cols)
+	if tensor == nil {
+		return nil
+	}
+
+	var matrix, err = newGoMatrix(tensor)
+	if err != nil {
+		my.err = err
+	}
+
+	return matrix
+}
+
+// matrices splits the tensor of name into count matrices
+func (my *goModelLoader) matrices(name string, count int, rows int, cols int) []goMatrix {
+	var tensor = my.tensor(name, count, rows, cols)
+	if tensor == nil {
+		return nil
+	}
+
+	var matrices = make([]goMatrix, count)
+	var size = len(tensor.Data) / count
+	for i := range matrices {
+		var part = *tensor
+		part.DimCount, part.Depth, part.Data = 2, 1, tensor.Data[i*size:(i+1)*size]
+
+		var matrix, err = newGoMatrix(&part)
+		if err != nil {
+			my.err = err
+			return nil
+		}
+		matrices[i] = matrix
+	}
+
+	return matrices
+}
+
+// layerSize returns the state length of a layer, RWKV v5 and later keep a head_size x head_size matrix per head
+// after ffn_xx and att_xx
+func (my *goModel) layerSize() int {
+	if my.arch == goArchV4 {
+		return goStateFields * my.nEmbed
+	}
+
+	return 2*my.nEmbed + my.nEmbed*my.headSize
+}
+
+func (my *goModel) stateLength() int {
+	return my.nLayer * my.layerSize()
+}
+
+// initState writes the state before the first token, att_pp of RWKV v4 starts from a very small exponent
+func (my *goModel) initState(state []float32) {
+	clear(state)
+	if my.arch != goArchV4 {
+		return
+	}
+
+	for i := 0; i < my.nLayer; i++ {
+		var pp = state[(i*goStateFields+4)*my.nEmbed : (i*goStateFields+5)*my.nEmbed]
+		for j := range pp {
+			pp[j] = -1e30
+		}
+	}
+}
+
+// goScratch holds the buffers of one eval, a context owns one since it runs one eval at a time
+type goScratch struct {
+	x, xx, sx, xk, xv, xr, xg, xw []float32
+	k, v, r, g, w, wkv, out       []float32
+	ffnK                          []float32
+	maa, mix, decay               []float32 // RWKV v6 only
+	rounded                       []float32 // the input of mulVec rounded to FP16, grown on demand
+	ax                            []float32 // A·x of the adapter of a weight in mulVec, grown on demand
+}
+
+func newGoScratch(model *goModel) *goScratch {
+	var n = model.nEmbed
+	var vector = func() []float32 { return make([]float32, n) }
+	var scratch = &goScratch{
+		x: vector(), xx: vector(), sx: vector(), xk: vector(), xv: vector(), xr: vector(), xg: vector(), xw: vector(),
+		k: vector(), v: vector(), r: vector(), g: vector(), w: vector(), wkv: vector(), out: vector(),
+		ffnK: make([]float32, model.ffnSize()),
+	}
+
+	if model.arch == goArchV6 {
+		var layer = &model.layers[0]
+		var mixRows, _ = layer.attTimeMaaW1.shape()
+		var decayRows, _ = layer.attTimeDecayW1.shape()
+		scratch.maa = make([]float32, mixRows)
+		scratch.mix = make([]float32, len(layer.attTimeMaaW2)*n)
+		scratch.decay = make([]float32, decayRows)
+	}
+
+	return scratch
+}
+
+func (my *goModel) ffnSize() int {
+	var rows, _ = my.layers[0].ffnKey.shape()
+	return rows
+}
+
+// eval feeds token into state in place, and writes the logits into logits when it is not nil
+func (my *goModel) eval(token int, state []float32, logits []float32, scratch *goScratch, threads int) {
+	var n = my.nEmbed
+	var s = scratch
+	var x = s.x
+	var layerSize = my.layerSize()
+
+	my.emb.row(x, token)
+	layerNorm(x, x, my.ln0Weight, my.ln0Bias)
+
+	for i := range my.layers {
+		var layer = &my.layers[i]
+		var layerState = state[i*layerSize : (i+1)*layerSize]
+		var ffnXX, attXX = layerState[0:n], layerState[n : 2*n]
+
+		// time mixing
+		layerNorm(s.xx, x, layer.ln1Weight, layer.ln1Bias)
+		switch my.arch {
+		case goArchV4:
+			my.timeMixV4(layer, attXX, layerState[2*n:], s, threads)
+		case goArchV6:
+			my.timeMixV6(layer, attXX, layerState[2*n:], s, threads)
+		default:
+			my.timeMixV5(layer, attXX, layerState[2*n:], s, threads)
+		}
+
+		for j, v := range s.out {
+			x[j] += v
+		}
+
+		// channel mixing
+		layerNorm(s.xx, x, layer.ln2Weight, layer.ln2Bias)
+		for j, v := range s.xx {
+			var last = ffnXX[j]
+			s.xk[j] = v*layer.ffnTimeMixK[j] + last*(1-layer.ffnTimeMixK[j])
+			s.xr[j] = v*layer.ffnTimeMixR[j] + last*(1-layer.ffnTimeMixR[j])
+		}
+		copy(ffnXX, s.xx)
+
+		s.mulVec(layer.ffnReceptance, s.r, s.xr, threads)
+		s.mulVec(layer.ffnKey, s.ffnK, s.xk, threads)
+		for j, v := range s.ffnK {
+			v = max(v, 0)
+			s.ffnK[j] = v * v
+		}
+
+		s.mulVec(layer.ffnValue, s.out, s.ffnK, threads)
+		for j, v := range s.out {
+			x[j] += sigmoid(s.r[j]) * v
+		}
+	}
+
+	if logits != nil {
+		layerNorm(s.xx, x, my.lnOutWeight, my.lnOutBias)
+		s.mulVec(my.head, logits, s.xx, threads)
+	}
+}
+
+// timeMixV4 mixes scratch.xx, the normalized input, with attXX, and writes the output into scratch.out. The wkv
+// state is att_aa, att_bb and att_pp.
+func (my *goModel) timeMixV4(layer *goLayer, attXX []float32, wkvState []float32, s *goScratch, threads int) {
+	var n = my.nEmbed
+	for j, v := range s.xx {
+		var last = attXX[j]
+		s.xk[j] = v*layer.attTimeMixK[j] + last*(1-layer.attTimeMixK[j])
+		s.xv[j] = v*layer.attTimeMixV[j] + last*(1-layer.attTimeMixV[j])
+		s.xr[j] = v*layer.attTimeMixR[j] + last*(1-layer.attTimeMixR[j])
+	}
+	copy(attXX, s.xx)
+
+	s.mulVec(layer.attReceptance, s.r, s.xr, threads)
+	s.mulVec(layer.attKey, s.k, s.xk, threads)
+	s.mulVec(layer.attValue, s.v, s.xv, threads)
+
+	var aa, bb, pp = wkvState[0:n], wkvState[n : 2*n], wkvState[2*n : 3*n]
+	for j := range s.wkv {
+		var k, v = float64(s.k[j]), float64(s.v[j])
+		var a, b, p = float64(aa[j]), float64(bb[j]), float64(pp[j])
+
+		var ww = float64(layer.attTimeFirst[j]) + k
+		var qq = math.Max(p, ww)
+		var e1, e2 = math.Exp(p - qq), math.Exp(ww - qq)
+		s.wkv[j] = sigmoid(s.r[j]) * float32((e1*a+e2*v)/(e1*b+e2))
+
+		ww = p + float64(layer.attTimeDecay[j])
+		qq = math.Max(ww, k)
+		e1, e2 = math.Exp(ww-qq), math.Exp(k-qq)
+		aa[j] = float32(e1*a + e2*v)
+		bb[j] = float32(e1*b + e2)
+		pp[j] = float32(qq)
+	}
+
+	s.mulVec(layer.attOutput, s.out, s.wkv, threads)
+}
+
+// timeMixV5 is the time mixing of RWKV v5, v5.1 and v5.2, heads is the wkv state
+func (my *goModel) timeMixV5(layer *goLayer, attXX []float32, heads []float32, s *goScratch, threads int) {
+	var gated = layer.attGate != nil
+	for j, v := range s.xx {
+		var last = attXX[j]
+		s.xk[j] = v*layer.attTimeMixK[j] + last*(1-layer.attTimeMixK[j])
+		s.xv[j] = v*layer.attTimeMixV[j] + last*(1-layer.attTimeMixV[j])
+		s.xr[j] = v*layer.attTimeMixR[j] + last*(1-layer.attTimeMixR[j])
+		if gated {
+			s.xg[j] = v*layer.attTimeMixG[j] + last*(1-layer.attTimeMixG[j])
+		}
+	}
+	copy(attXX, s.xx)
+
+	s.mulVec(layer.attReceptance, s.r, s.xr, threads)
+	s.mulVec(layer.attKey, s.k, s.xk, threads)
+	s.mulVec(layer.attValue, s.v, s.xv, threads)
+	if gated {
+		s.mulVec(layer.attGate, s.g, s.xg, threads)
+		for j, v := range s.g {
+			s.g[j] = silu(v)
+		}
+	}
+
+	my.attention(layer, heads, layer.attTimeDecay, s, threads, goGroupNormEpsV5)
+}
+
+// timeMixV6 is the time mixing of RWKV v6, whose token shift and decay are computed from the input by low rank
+// projections
+func (my *goModel) timeMixV6(layer *goLayer, attXX []float32, heads []float32, s *goScratch, threads int) {
+	var n = my.nEmbed
+	for j, v := range s.xx {
+		s.sx[j] = attXX[j] - v
+		s.xw[j] = v + s.sx[j]*layer.attTimeMaaX[j]
+	}
+	copy(attXX, s.xx)
+
+	s.mulVec(layer.attTimeMaaW1, s.maa, s.xw, threads)
+	for j, v := range s.maa {
+		s.maa[j] = tanh(v)
+	}
+
+	var extra = len(s.maa) / len(layer.attTimeMaaW2)
+	for i, m := range layer.attTimeMaaW2 {
+		s.mulVec(m, s.mix[i*n:(i+1)*n], s.maa[i*extra:(i+1)*extra], threads)
+	}
+
+	var mw, mk, mv, mr, mg = s.mix[0:n], s.mix[n : 2*n], s.mix[2*n : 3*n], s.mix[3*n : 4*n], s.mix[4*n : 5*n]
+	for j, v := range s.xx {
+		var sx = s.sx[j]
+		s.xw[j] = v + sx*(layer.attTimeMaaW[j]+mw[j])
+		s.xk[j] = v + sx*(layer.attTimeMaaK[j]+mk[j])
+		s.xv[j] = v + sx*(layer.attTimeMaaV[j]+mv[j])
+		s.xr[j] = v + sx*(layer.attTimeMaaR[j]+mr[j])
+		s.xg[j] = v + sx*(layer.attTimeMaaG[j]+mg[j])
+	}
+
+	s.mulVec(layer.attReceptance, s.r, s.xr, threads)
+	s.mulVec(layer.attKey, s.k, s.xk, threads)
+	s.mulVec(layer.attValue, s.v, s.xv, threads)
+	s.mulVec(layer.attGate, s.g, s.xg, threads)
+	for j, v := range s.g {
+		s.g[j] = silu(v)
+	}
+
+	s.mulVec(layer.attTimeDecayW1, s.decay, s.xw, threads)
+	for j, v := range s.decay {
+		s.decay[j] = tanh(v)
+	}
+
+	s.mulVec(layer.attTimeDecayW2, s.w, s.decay, threads)
+	for j, v := range s.w {
+		s.w[j] = float32(math.Exp(-math.Exp(float64(layer.attTimeDecay[j] + v))))
+	}
+
+	my.attention(layer, heads, s.w, s, threads, goGroupNormEpsV6)
+}
+
+// attention runs the wkv heads on scratch.r, scratch.k and scratch.v, updating their states in heads, then writes
+// the output of the time mixing into scratch.out
+func (my *goModel) attention(layer *goLayer, heads []float32, decay []float32, s *goScratch, threads int, eps float64) {
+	var size = my.headSize
+	clear(s.wkv)
+	for h := 0; h < my.headCount; h++ {
+		var base = h * size
+		var state = heads[base*size : (base+size)*size]
+		var v = s.v[base : base+size]
+		var out = s.wkv[base : base+size]
+
+		for i := 0; i < size; i++ {
+			var k, r = s.k[base+i], s.r[base+i]
+			var first, w = layer.attTimeFirst[base+i], decay[base+i]
+			var row = state[i*size : (i+1)*size]
+			for j, vj := range v {
+				var kv = k * vj
+				out[j] += r * (first*kv + row[j])
+				row[j] = row[j]*w + kv
+			}
+		}
+	}
+
+	groupNorm(s.wkv, s.wkv, layer.lnXWeight, layer.lnXBias, my.headCount, eps)
+	if layer.attGate != nil {
+		for j, v := range s.g {
+			s.wkv[j] *= v
+		}
+	}
+
+	s.mulVec(layer.attOutput, s.out, s.wkv, threads)
+}
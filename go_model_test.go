@@ -0,0 +1,524 @@
+package rwkv
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+/********************************************************************
+created:    2026-10-18
+author:     lixianmin
+
+Copyright (C) - All Rights Reserved
+*********************************************************************/
+
+// newTinyModelTensorsV5 creates the PyTorch parameters of a randomly initialized model of RWKV v5, v5.1, v5.2 or v6.
+// Before v5.2, time_decay and time_faaaa have a value per head, in the shape of (n_head).
+func newTinyModelTensorsV5(seed int64, arch goArch, nVocab, nEmbed, nLayer, headSize int) []fixtureTensor {
+	var random = rand.New(rand.NewSource(seed))
+	var nHead, nFfn = nEmbed / headSize, 4 * nEmbed
+	const mixExtra, decayExtra = 4, 8
+
+	var uniform = func(name string, low, high float32, shape ...int) fixtureTensor {
+		var count = 1
+		for _, dim := range shape {
+			count *= dim
+		}
+
+		var data = make([]float32, count)
+		for i := range data {
+			data[i] = low + (high-low)*random.Float32()
+		}
+		return fixtureTensor{name: name, shape: shape, data: data}
+	}
+
+	var matrix = func(name string, shape ...int) fixtureTensor {
+		var scale = float32(1 / math.Sqrt(float64(shape[len(shape)-1])))
+		return uniform(name, -scale, scale, shape...)
+	}
+
+	var vector = func(name string, low, high float32) fixtureTensor {
+		return uniform(name, low, high, nEmbed)
+	}
+
+	var headShape = []int{nHead, headSize}
+	if arch < goArchV5_2 {
+		headShape = []int{nHead}
+	}
+
+	var decay = func(name string) fixtureTensor {
+		if arch == goArchV6 {
+			return vector(name, -4, 0.5)
+		}
+		return uniform(name, -4, 0.5, headShape...)
+	}
+
+	var tensors = []fixtureTensor{
+		matrix("emb.weight", nVocab, nEmbed),
+		vector("blocks.0.ln0.weight", 0.8, 1.2),
+		vector("blocks.0.ln0.bias", -0.1, 0.1),
+	}
+
+	for i := 0; i < nLayer; i++ {
+		var prefix = fmt.Sprintf("blocks.%d.", i)
+		tensors = append(tensors,
+			vector(prefix+"ln1.weight", 0.8, 1.2),
+			vector(prefix+"ln1.bias", -0.1, 0.1),
+			vector(prefix+"ln2.weight", 0.8, 1.2),
+			vector(prefix+"ln2.bias", -0.1, 0.1),
+			decay(prefix+"att.time_decay"),
+			uniform(prefix+"att.time_faaaa", -1, 1, headShape...),
+			matrix(prefix+"att.key.weight", nEmbed, nEmbed),
+			matrix(prefix+"att.value.weight", nEmbed, nEmbed),
+			matrix(prefix+"att.receptance.weight", nEmbed, nEmbed),
+			matrix(prefix+"att.output.weight", nEmbed, nEmbed),
+			vector(prefix+"att.ln_x.weight", 0.8, 1.2),
+			vector(prefix+"att.ln_x.bias", -0.1, 0.1),
+			matrix(prefix+"ffn.key.weight", nFfn, nEmbed),
+			matrix(prefix+"ffn.receptance.weight", nEmbed, nEmbed),
+			matrix(prefix+"ffn.value.weight", nEmbed, nFfn),
+		)
+
+		if arch >= goArchV5_1 {
+			tensors = append(tensors, matrix(prefix+"att.gate.weight", nEmbed, nEmbed))
+		}
+
+		switch arch {
+		case goArchV6:
+			tensors = append(tensors,
+				vector(prefix+"att.time_maa_x", 0, 1),
+				vector(prefix+"att.time_maa_w", 0, 1),
+				vector(prefix+"att.time_maa_k", 0, 1),
+				vector(prefix+"att.time_maa_v", 0, 1),
+				vector(prefix+"att.time_maa_r", 0, 1),
+				vector(prefix+"att.time_maa_g", 0, 1),
+				uniform(prefix+"att.time_maa_w1", -0.1, 0.1, nEmbed, 5*mixExtra),
+				uniform(prefix+"att.time_maa_w2", -0.1, 0.1, 5, mixExtra, nEmbed),
+				uniform(prefix+"att.time_decay_w1", -0.1, 0.1, nEmbed, decayExtra),
+				uniform(prefix+"att.time_decay_w2", -0.1, 0.1, decayExtra, nEmbed),
+				vector(prefix+"ffn.time_maa_k", 0, 1),
+				vector(prefix+"ffn.time_maa_r", 0, 1),
+			)
+		default:
+			tensors = append(tensors,
+				vector(prefix+"att.time_mix_k", 0, 1),
+				vector(prefix+"att.time_mix_v", 0, 1),
+				vector(prefix+"att.time_mix_r", 0, 1),
+				vector(prefix+"ffn.time_mix_k", 0, 1),
+				vector(prefix+"ffn.time_mix_r", 0, 1),
+			)
+			if arch >= goArchV5_1 {
+				tensors = append(tensors, vector(prefix+"att.time_mix_g", 0, 1))
+			}
+		}
+	}
+
+	return append(tensors,
+		vector("ln_out.weight", 0.8, 1.2),
+		vector("ln_out.bias", -0.1, 0.1),
+		matrix("head.weight", nVocab, nEmbed),
+	)
+}
+
+// convertFixtureTensors processes the PyTorch parameters of RWKV v5 and v6 the same as convert_pytorch_to_ggml.py.
+// The time_decay and time_faaaa of RWKV v5 become (n_head, 1, 1) per head, or (n_head, head_size, 1) per channel,
+// which the graph of rwkv.cpp repeats to (n_head, head_size, 1).
+func convertFixtureTensors(arch goArch, tensors []fixtureTensor) []fixtureTensor {
+	var converted = make([]fixtureTensor, 0, len(tensors))
+	for _, tensor := range tensors {
+		var name, shape = tensor.name, tensor.shape
+		var data = append([]float32(nil), tensor.data...)
+		var perHead = arch != goArchV6 && len(shape) == 1
+
+		switch {
+		case arch != goArchV6 && strings.HasSuffix(name, ".time_decay"):
+			for i, v := range data {
+				data[i] = float32(math.Exp(-math.Exp(float64(v))))
+			}
+			shape = headTensorShape(shape, perHead)
+		case arch != goArchV6 && strings.HasSuffix(name, ".time_faaaa"):
+			shape = headTensorShape(shape, perHead)
+		case strings.HasSuffix(name, ".time_maa_w1") || strings.HasSuffix(name, ".time_decay_w1") || strings.HasSuffix(name, ".time_decay_w2"):
+			data, shape = transposeFixture(data, 1, shape[0], shape[1]), []int{shape[1], shape[0]}
+		case strings.HasSuffix(name, ".time_maa_w2"):
+			data, shape = transposeFixture(data, shape[0], shape[1], shape[2]), []int{shape[0], shape[2], shape[1]}
+		}
+
+		converted = append(converted, fixtureTensor{name: name, shape: shape, data: data})
+	}
+
+	return converted
+}
+
+func headTensorShape(shape []int, perHead bool) []int {
+	if perHead {
+		return []int{shape[0], 1, 1}
+	}
+
+	return []int{shape[0], shape[1], 1}
+}
+
+// transposeFixture transposes the last 2 dimensions of count matrices of rows x cols
+func transposeFixture(data []float32, count, rows, cols int) []float32 {
+	var result = make([]float32, len(data))
+	for m := 0; m < count; m++ {
+		var base = m * rows * cols
+		for r := 0; r < rows; r++ {
+			for c := 0; c < cols; c++ {
+				result[base+c*rows+r] = data[base+r*cols+c]
+			}
+		}
+	}
+
+	return result
+}
+
+// referenceModel runs a model of RWKV v5 or v6 in float64 from its PyTorch parameters, following ChatRWKV
+type referenceModel struct {
+	arch     goArch
+	params   map[string]fixtureTensor
+	nEmbed   int
+	headSize int
+	attX     [][]float64
+	ffnX     [][]float64
+	heads    [][]float64
+}
+
+func newReferenceModel(arch goArch, tensors []fixtureTensor, nEmbed, nLayer, headSize int) *referenceModel {
+	var model = &referenceModel{arch: arch, params: make(map[string]fixtureTensor), nEmbed: nEmbed, headSize: headSize}
+	for _, tensor := range tensors {
+		model.params[tensor.name] = tensor
+	}
+
+	for i := 0; i < nLayer; i++ {
+		model.attX = append(model.attX, make([]float64, nEmbed))
+		model.ffnX = append(model.ffnX, make([]float64, nEmbed))
+		model.heads = append(model.heads, make([]float64, nEmbed*headSize))
+	}
+
+	return model
+}
+
+// perChannel returns the parameter of name with a value per channel, repeating the values of a parameter per head
+func (my *referenceModel) perChannel(name string) []float64 {
+	var data = my.param(name)
+	if len(data) == my.nEmbed {
+		return data
+	}
+
+	var result = make([]float64, my.nEmbed)
+	for i := range result {
+		result[i] = data[i/my.headSize]
+	}
+
+	return result
+}
+
+func (my *referenceModel) param(name string) []float64 {
+	var data = make([]float64, len(my.params[name].data))
+	for i, v := range my.params[name].data {
+		data[i] = float64(v)
+	}
+
+	return data
+}
+
+// linear returns W·x with W of name in the shape of (out, in)
+func (my *referenceModel) linear(name string, x []float64) []float64 {
+	var w = my.param(name)
+	var out = make([]float64, len(w)/len(x))
+	for r := range out {
+		for c, v := range x {
+			out[r] += w[r*len(x)+c] * v
+		}
+	}
+
+	return out
+}
+
+// project returns x@W with W of (len(x), out)
+func project(w []float64, x []float64) []float64 {
+	var out = make([]float64, len(w)/len(x))
+	for r, v := range x {
+		for c := range out {
+			out[c] += v * w[r*len(out)+c]
+		}
+	}
+
+	return out
+}
+
+func referenceNorm(x []float64, weight []float64, bias []float64, eps float64) []float64 {
+	var mean, variance float64
+	for _, v := range x {
+		mean += v
+	}
+	mean /= float64(len(x))
+
+	for _, v := range x {
+		variance += (v - mean) * (v - mean)
+	}
+	variance /= float64(len(x))
+
+	var out = make([]float64, len(x))
+	for i, v := range x {
+		out[i] = (v-mean)/math.Sqrt(variance+eps)*weight[i] + bias[i]
+	}
+
+	return out
+}
+
+func (my *referenceModel) norm(prefix string, x []float64) []float64 {
+	return referenceNorm(x, my.param(prefix+".weight"), my.param(prefix+".bias"), 1e-5)
+}
+
+func (my *referenceModel) forward(token int) []float64 {
+	var n = my.nEmbed
+	var x = my.param("emb.weight")[token*n : (token+1)*n]
+	x = my.norm("blocks.0.ln0", x)
+
+	for i := range my.attX {
+		var prefix = fmt.Sprintf("blocks.%d.", i)
+		var att = my.timeMix(i, prefix, my.norm(prefix+"ln1", x))
+		for j := range x {
+			x[j] += att[j]
+		}
+
+		var xx = my.norm(prefix+"ln2", x)
+		var xk, xr = make([]float64, n), make([]float64, n)
+		for j, v := range xx {
+			var last = my.ffnX[i][j]
+			if my.arch == goArchV6 {
+				xk[j] = v + (last-v)*my.param(prefix + "ffn.time_maa_k")[j]
+				xr[j] = v + (last-v)*my.param(prefix + "ffn.time_maa_r")[j]
+			} else {
+				var mixK, mixR = my.param(prefix + "ffn.time_mix_k")[j], my.param(prefix + "ffn.time_mix_r")[j]
+				xk[j] = v*mixK + last*(1-mixK)
+				xr[j] = v*mixR + last*(1-mixR)
+			}
+		}
+		my.ffnX[i] = xx
+
+		var r = my.linear(prefix+"ffn.receptance.weight", xr)
+		var k = my.linear(prefix+"ffn.key.weight", xk)
+		for j, v := range k {
+			k[j] = math.Max(v, 0) * math.Max(v, 0)
+		}
+
+		var v = my.linear(prefix+"ffn.value.weight", k)
+		for j := range x {
+			x[j] += v[j] / (1 + math.Exp(-r[j]))
+		}
+	}
+
+	return my.linear("head.weight", my.norm("ln_out", x))
+}
+
+func (my *referenceModel) timeMix(layer int, prefix string, x []float64) []float64 {
+	var n = my.nEmbed
+	var last = my.attX[layer]
+	my.attX[layer] = x
+
+	var mix = func(name string, offset []float64) []float64 {
+		var out = make([]float64, n)
+		var weight = my.param(prefix + name)
+		for j, v := range x {
+			if my.arch == goArchV6 {
+				out[j] = v + (last[j]-v)*(weight[j]+offset[j])
+			} else {
+				out[j] = v*weight[j] + last[j]*(1-weight[j])
+			}
+		}
+		return out
+	}
+
+	var xw, xk, xv, xr, xg []float64
+	var decay = my.perChannel(prefix + "att.time_decay")
+	if my.arch == goArchV6 {
+		var xxx = make([]float64, n)
+		for j, v := range x {
+			xxx[j] = v + (last[j]-v)*my.param(prefix + "att.time_maa_x")[j]
+		}
+
+		var hidden = project(my.param(prefix+"att.time_maa_w1"), xxx)
+		for j, v := range hidden {
+			hidden[j] = math.Tanh(v)
+		}
+
+		var w2, extra = my.param(prefix + "att.time_maa_w2"), len(hidden) / 5
+		var offset = func(i int) []float64 {
+			return project(w2[i*extra*n:(i+1)*extra*n], hidden[i*extra:(i+1)*extra])
+		}
+		xw, xk, xv = mix("att.time_maa_w", offset(0)), mix("att.time_maa_k", offset(1)), mix("att.time_maa_v", offset(2))
+		xr, xg = mix("att.time_maa_r", offset(3)), mix("att.time_maa_g", offset(4))
+
+		var dw = project(my.param(prefix+"att.time_decay_w1"), xw)
+		for j, v := range dw {
+			dw[j] = math.Tanh(v)
+		}
+		dw = project(my.param(prefix+"att.time_decay_w2"), dw)
+		for j := range decay {
+			decay[j] += dw[j]
+		}
+	} else {
+		xk, xv, xr = mix("att.time_mix_k", nil), mix("att.time_mix_v", nil), mix("att.time_mix_r", nil)
+		if my.arch >= goArchV5_1 {
+			xg = mix("att.time_mix_g", nil)
+		}
+	}
+
+	for j, v := range decay {
+		decay[j] = math.Exp(-math.Exp(v))
+	}
+
+	var r, k, v = my.linear(prefix+"att.receptance.weight", xr), my.linear(prefix+"att.key.weight", xk), my.linear(prefix+"att.value.weight", xv)
+	var first = my.perChannel(prefix + "att.time_faaaa")
+	var size, state = my.headSize, my.heads[layer]
+	var out = make([]float64, n)
+	for h := 0; h < n/size; h++ {
+		for i := h * size; i < (h+1)*size; i++ {
+			for j := h * size; j < (h+1)*size; j++ {
+				var s = &state[i*size+j-h*size]
+				var kv = k[i] * v[j]
+				out[j] += r[i] * (first[i]*kv + *s)
+				*s = *s*decay[i] + kv
+			}
+		}
+	}
+
+	var eps = 1e-5
+	if my.arch == goArchV6 {
+		eps = 64e-5
+	}
+
+	var lnX = make([]float64, 0, n)
+	var weight, bias = my.param(prefix + "att.ln_x.weight"), my.param(prefix + "att.ln_x.bias")
+	for h := 0; h < n; h += size {
+		lnX = append(lnX, referenceNorm(out[h:h+size], weight[h:h+size], bias[h:h+size], eps)...)
+	}
+
+	if xg != nil {
+		var g = my.linear(prefix+"att.gate.weight", xg)
+		for j, v := range g {
+			lnX[j] *= v / (1 + math.Exp(-v))
+		}
+	}
+
+	return my.linear(prefix+"att.output.weight", lnX)
+}
+
+func TestGoBackendV5(t *testing.T) {
+	const nVocab, nEmbed, nLayer, headSize = 64, 32, 2, 8
+	var tokens = []int{1, 17, 63, 0, 42, 42, 42, 9, 3, 30}
+
+	for _, arch := range []goArch{goArchV5, goArchV5_1, goArchV5_2, goArchV6} {
+		t.Run(arch.String(), func(t *testing.T) {
+			var tensors = newTinyModelTensorsV5(3, arch, nVocab, nEmbed, nLayer, headSize)
+			var path = filepath.Join(t.TempDir(), "tiny.bin")
+			if err := writeFixtureModel(path, nVocab, nEmbed, nLayer, convertFixtureTensors(arch, tensors)); err != nil {
+				t.Fatal(err)
+			}
+
+			var backend = NewGoRwkv()
+			var ctx = backend.RwkvInitFromFile(path, 2)
+			if err := hasCtx(ctx); err != nil {
+				t.Fatal(backend.RwkvGetLastError(ctx))
+			}
+			defer backend.RwkvFree(ctx)
+			var model = backend.get(ctx).model
+			assert(t, model.arch == arch && model.headCount == nEmbed/headSize, "the architecture should be detected")
+
+			var stateLength = backend.RwkvGetStateLength(ctx)
+			assert(t, stateLength == nLayer*(2*nEmbed+nEmbed*headSize), "the state should hold the heads of every layer")
+
+			var layout, err = NewStateLayout(nLayer, nEmbed, int(stateLength))
+			assert(t, err == nil && !layout.IsV4())
+
+			var reference = newReferenceModel(arch, tensors, nEmbed, nLayer, headSize)
+			var state, logits = make([]float32, stateLength), make([]float32, nVocab)
+			backend.RwkvInitState(ctx, state)
+			for i, token := range tokens {
+				assert(t, backend.RwkvEval(ctx, uint32(token), state, state, logits) == nil)
+
+				var expected = reference.forward(token)
+				var want = make([]float32, nVocab)
+				for j, v := range expected {
+					want[j] = float32(v)
+				}
+
+				if diff := maxRelativeDiff(want, logits); diff > 1e-4 {
+					t.Fatalf("the logits of token %d differ by %g", i, diff)
+				}
+			}
+
+			var heads, _ = layout.Field(state, -1, StateAttHeads)
+			var last = reference.heads[nLayer-1]
+			for j, v := range heads {
+				var near = math.Abs(float64(v)-last[j]) <= 1e-4*(1+math.Abs(last[j]))
+				assert(t, near, "the heads should be the same as the reference")
+			}
+		})
+	}
+}
+
+func TestGoModelHeadLayouts(t *testing.T) {
+	const nVocab, nEmbed, nLayer, headSize = 64, 32, 1, 8
+	const nHead = nEmbed / headSize
+	var fixture = newTinyModelTensorsV5(5, goArchV5_1, nVocab, nEmbed, nLayer, headSize)
+	var tensors = convertFixtureTensors(goArchV5_1, fixture)
+
+	// the values per head of RWKV v5 and v5.1 load the same in any shape
+	var decays [][]float32
+	for _, shape := range [][]int{{nHead}, {nHead, 1}, {nHead, 1, 1}} {
+		for i, tensor := range tensors {
+			if strings.HasSuffix(tensor.name, ".time_decay") || strings.HasSuffix(tensor.name, ".time_faaaa") {
+				tensors[i].shape = shape
+			}
+		}
+
+		var path = filepath.Join(t.TempDir(), "tiny.bin")
+		if err := writeFixtureModel(path, nVocab, nEmbed, nLayer, tensors); err != nil {
+			t.Fatal(err)
+		}
+
+		var model, err = loadGoModel(path)
+		assert(t, err == nil && model.arch == goArchV5_1 && model.headCount == nHead, fmt.Sprint(shape, err))
+		decays = append(decays, model.layers[0].attTimeDecay)
+	}
+
+	for _, decay := range decays {
+		assert(t, slices.Equal(decay, decays[0]), "the shapes should load the same values")
+	}
+	assert(t, decays[0][0] == decays[0][headSize-1] && decays[0][0] != decays[0][headSize],
+		"a value per head should be repeated for the channels of the head")
+}
+
+func TestGoBackendV5FP16(t *testing.T) {
+	const nVocab, nEmbed, nLayer, headSize = 64, 32, 2, 8
+	var tensors = convertFixtureTensors(goArchV6, newTinyModelTensorsV5(4, goArchV6, nVocab, nEmbed, nLayer, headSize))
+
+	var logits [2][]float32
+	for i, dataType := range []rwkvType{rwkvTypeFP32, rwkvTypeFP16} {
+		var path = filepath.Join(t.TempDir(), "tiny.bin")
+		if err := writeFixtureModelType(path, dataType, nVocab, nEmbed, nLayer, tensors); err != nil {
+			t.Fatal(err)
+		}
+
+		var backend = NewGoRwkv()
+		var ctx = backend.RwkvInitFromFile(path, 1)
+		if err := hasCtx(ctx); err != nil {
+			t.Fatal(backend.RwkvGetLastError(ctx))
+		}
+
+		logits[i] = make([]float32, nVocab)
+		assert(t, backend.RwkvEvalSequence(ctx, 5, 4, nil, make([]float32, backend.RwkvGetStateLength(ctx)), logits[i]) == nil)
+		_ = backend.RwkvFree(ctx)
+	}
+
+	assert(t, maxRelativeDiff(logits[0], logits[1]) < 1e-2, "FP16 should stay close to FP32")
+}
@@ -0,0 +1,220 @@
+package rwkv
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+)
+
+/********************************************************************
+created:    2026-10-18
+author:     lixianmin
+
+Copyright (C) - All Rights Reserved
+*********************************************************************/
+
+// quantFormat is the ggml block layout of a quantized type. Every block holds 32 elements: a FP16 scale d at the
+// offset 0, a FP16 minimum m for Q4_1 and Q5_1, the 5th bits of Q5_0 and Q5_1 as a uint32, then the quants. The 4 bit
+// quants are packed with the elements j and j+16 in the low and the high nibble of the byte j, and an element is
+// (q-bias)*d+m.
+type quantFormat struct {
+	blockBytes  int
+	bits        int // 4, 5 or 8
+	minOffset   int // -1 for the formats without a minimum
+	highOffset  int // the offset of the 5th bits, -1 for the formats without them
+	quantOffset int
+	bias        int8
+}
+
+var quantFormats = map[rwkvType]*quantFormat{
+	rwkvTypeQ4_0: {blockBytes: 18, bits: 4, minOffset: -1, highOffset: -1, quantOffset: 2, bias: 8},
+	rwkvTypeQ4_1: {blockBytes: 20, bits: 4, minOffset: 2, highOffset: -1, quantOffset: 4},
+	rwkvTypeQ5_0: {blockBytes: 22, bits: 5, minOffset: -1, highOffset: 2, quantOffset: 6, bias: 16},
+	rwkvTypeQ5_1: {blockBytes: 24, bits: 5, minOffset: 2, highOffset: 4, quantOffset: 8},
+	rwkvTypeQ8_0: {blockBytes: 34, bits: 8, minOffset: -1, highOffset: -1, quantOffset: 2},
+}
+
+// quantKernel returns Σ scales[b]*Σ(q-bias)*x over the blocks, data starts with the first block at the 5th bits of
+// the 5 bit formats, or at the quants of the others, and stride is the byte count of a block
+type quantKernel func(data []byte, stride int, scales []float32, x []float32, bias int8) float32
+
+// the kernels of the 3 quant widths, replaced by faster ones where the CPU supports them
+var (
+	dotQ4 quantKernel = dotQ4Generic
+	dotQ5 quantKernel = dotQ5Generic
+	dotQ8 quantKernel = dotQ8Generic
+
+	quantAccelerated = false // true when the kernels above are replaced
+)
+
+func (my *quantFormat) kernel() quantKernel {
+	switch my.bits {
+	case 4:
+		return dotQ4
+	case 5:
+		return dotQ5
+	default:
+		return dotQ8
+	}
+}
+
+// halfTable maps every FP16 to float32 the same as ggml's table_f32_f16, it is filled by the first quantized matrix
+var (
+	halfTable     [1 << 16]float32
+	halfTableOnce sync.Once
+)
+
+func initHalfTable() {
+	halfTableOnce.Do(func() {
+		for i := range halfTable {
+			halfTable[i] = halfToFloat32(uint16(i))
+		}
+	})
+}
+
+// quantMatrix keeps the blocks of a quantized tensor as they are in the model file, and multiplies them without
+// dequantizing the whole matrix
+type quantMatrix struct {
+	rows     int
+	cols     int
+	rowBytes int
+	format   *quantFormat
+	data     []byte
+}
+
+func newQuantMatrix(tensor *rwkvTensor) (goMatrix, error) {
+	var format, ok = quantFormats[tensor.DataType]
+	if !ok || tensor.Width%quantBlockSize != 0 {
+		return nil, fmt.Errorf("%w: %s is %v, which the Go backend does not support", ErrInvalidModelFile, tensor.Name, tensor.DataType)
+	}
+
+	initHalfTable()
+	var rowBytes = tensor.Width / quantBlockSize * format.blockBytes
+	return &quantMatrix{rows: tensor.Height * tensor.Depth, cols: tensor.Width, rowBytes: rowBytes, format: format, data: tensor.Data}, nil
+}
+
+func (my *quantMatrix) shape() (int, int) {
+	return my.rows, my.cols
+}
+
+func (my *quantMatrix) mulRows(out []float32, x []float32, start int, end int) {
+	var format = my.format
+	var kernel = format.kernel()
+	var scales = make([]float32, my.cols/quantBlockSize)
+
+	// the minimums multiply the sums of x in every block
+	var sums []float32
+	if format.minOffset >= 0 {
+		sums = make([]float32, len(scales))
+		for b := range sums {
+			for _, v := range x[b*quantBlockSize : (b+1)*quantBlockSize] {
+				sums[b] += v
+			}
+		}
+	}
+
+	var offset = format.quantOffset
+	if format.highOffset >= 0 {
+		offset = format.highOffset
+	}
+
+	for r := start; r < end; r++ {
+		var data = my.data[r*my.rowBytes : (r+1)*my.rowBytes]
+		for b := range scales {
+			scales[b] = halfTable[binary.LittleEndian.Uint16(data[b*format.blockBytes:])]
+		}
+
+		var sum = kernel(data[offset:], format.blockBytes, scales, x, format.bias)
+		for b, v := range sums {
+			sum += halfTable[binary.LittleEndian.Uint16(data[b*format.blockBytes+format.minOffset:])] * v
+		}
+		out[r] = sum
+	}
+}
+
+func (my *quantMatrix) row(out []float32, r int) {
+	dequantizeBlocks(my.format, my.data[r*my.rowBytes:(r+1)*my.rowBytes], out)
+}
+
+// dequantizeBlocks decodes the blocks of data into out, the same as ggml's dequantize_row functions
+func dequantizeBlocks(format *quantFormat, data []byte, out []float32) {
+	var blocks = len(data) / format.blockBytes
+	for b := 0; b < blocks; b++ {
+		var block = data[b*format.blockBytes : (b+1)*format.blockBytes]
+		var y = out[b*quantBlockSize : (b+1)*quantBlockSize]
+		var d = halfToFloat32(binary.LittleEndian.Uint16(block))
+		var m float32
+		if format.minOffset >= 0 {
+			m = halfToFloat32(binary.LittleEndian.Uint16(block[format.minOffset:]))
+		}
+
+		var qs = block[format.quantOffset:]
+		if format.bits == 8 {
+			for j := range y {
+				y[j] = float32(int8(qs[j])) * d
+			}
+			continue
+		}
+
+		var qh uint32
+		if format.highOffset >= 0 {
+			qh = binary.LittleEndian.Uint32(block[format.highOffset:])
+		}
+
+		var bias = int(format.bias)
+		for j := 0; j < quantBlockSize/2; j++ {
+			var low = int(qs[j]&0x0f) | int(qh>>j&1)<<4
+			var high = int(qs[j]>>4) | int(qh>>(j+16)&1)<<4
+			y[j] = float32(low-bias)*d + m
+			y[j+16] = float32(high-bias)*d + m
+		}
+	}
+}
+
+func dotQ4Generic(data []byte, stride int, scales []float32, x []float32, bias int8) float32 {
+	var total float32
+	for b, scale := range scales {
+		var qs = data[b*stride : b*stride+16]
+		var xs = x[b*quantBlockSize : (b+1)*quantBlockSize]
+		var sum float32
+		for j, q := range qs {
+			sum += float32(int8(q&0x0f)-bias)*xs[j] + float32(int8(q>>4)-bias)*xs[j+16]
+		}
+		total += scale * sum
+	}
+
+	return total
+}
+
+func dotQ5Generic(data []byte, stride int, scales []float32, x []float32, bias int8) float32 {
+	var total float32
+	for b, scale := range scales {
+		var block = data[b*stride : b*stride+20]
+		var qh = binary.LittleEndian.Uint32(block)
+		var xs = x[b*quantBlockSize : (b+1)*quantBlockSize]
+		var sum float32
+		for j, q := range block[4:] {
+			var low = int8(q&0x0f) | int8(qh>>j&1)<<4
+			var high = int8(q>>4) | int8(qh>>(j+16)&1)<<4
+			sum += float32(low-bias)*xs[j] + float32(high-bias)*xs[j+16]
+		}
+		total += scale * sum
+	}
+
+	return total
+}
+
+func dotQ8Generic(data []byte, stride int, scales []float32, x []float32, bias int8) float32 {
+	var total float32
+	for b, scale := range scales {
+		var qs = data[b*stride : b*stride+quantBlockSize]
+		var xs = x[b*quantBlockSize : (b+1)*quantBlockSize]
+		var sum float32
+		for j, q := range qs {
+			sum += float32(int8(q)-bias) * xs[j]
+		}
+		total += scale * sum
+	}
+
+	return total
+}
@@ -0,0 +1,46 @@
+//go:build amd64
+
+package rwkv
+
+import "golang.org/x/sys/cpu"
+
+/********************************************************************
+created:    2026-10-18
+author:     lixianmin
+
+Copyright (C) - All Rights Reserved
+*********************************************************************/
+
+// the AVX2 kernels, see go_quant_amd64.s. They compute the same sums as the generic ones, in another order.
+
+//go:noescape
+func dotQ4AVX2(data *byte, stride int, scales *float32, x *float32, blocks int, bias int8) float32
+
+//go:noescape
+func dotQ5AVX2(data *byte, stride int, scales *float32, x *float32, blocks int, bias int8) float32
+
+//go:noescape
+func dotQ8AVX2(data *byte, stride int, scales *float32, x *float32, blocks int, bias int8) float32
+
+func init() {
+	if cpu.X86.HasAVX2 && cpu.X86.HasFMA {
+		quantAccelerated = true
+		dotQ4 = newQuantKernelAVX2(dotQ4AVX2, 16)
+		dotQ5 = newQuantKernelAVX2(dotQ5AVX2, 20)
+		dotQ8 = newQuantKernelAVX2(dotQ8AVX2, quantBlockSize)
+	}
+}
+
+// newQuantKernelAVX2 wraps an assembly kernel which reads blockBytes from every block, checking the bounds first
+func newQuantKernelAVX2(kernel func(*byte, int, *float32, *float32, int, int8) float32, blockBytes int) quantKernel {
+	return func(data []byte, stride int, scales []float32, x []float32, bias int8) float32 {
+		var blocks = len(scales)
+		if blocks == 0 {
+			return 0
+		}
+
+		_ = data[(blocks-1)*stride+blockBytes-1]
+		_ = x[blocks*quantBlockSize-1]
+		return kernel(&data[0], stride, &scales[0], &x[0], blocks, bias)
+	}
+}
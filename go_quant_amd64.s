@@ -0,0 +1,164 @@
+//go:build amd64
+
+#include "textflag.h"
+
+// the byte j of a 5th bit mask reads the byte j/8 of qh
+DATA q5Shuffle<>+0(SB)/8, $0x0000000000000000
+DATA q5Shuffle<>+8(SB)/8, $0x0101010101010101
+DATA q5Shuffle<>+16(SB)/8, $0x0202020202020202
+DATA q5Shuffle<>+24(SB)/8, $0x0303030303030303
+GLOBL q5Shuffle<>(SB), RODATA|NOPTR, $32
+
+// every bit but the bit j%8 in the byte j, so that the byte becomes 0xff when its bit of qh is set
+DATA q5Bits<>+0(SB)/8, $0x7fbfdfeff7fbfdfe
+DATA q5Bits<>+8(SB)/8, $0x7fbfdfeff7fbfdfe
+DATA q5Bits<>+16(SB)/8, $0x7fbfdfeff7fbfdfe
+DATA q5Bits<>+24(SB)/8, $0x7fbfdfeff7fbfdfe
+GLOBL q5Bits<>(SB), RODATA|NOPTR, $32
+
+// BLOCK_DOT adds the scale at R8 times the dot product of the 32 int8 in Y1 and the 32 float32 at DI to Y0
+#define BLOCK_DOT \
+	VPMOVSXBD    X1, Y2;        \
+	VCVTDQ2PS    Y2, Y2;        \
+	VMULPS       (DI), Y2, Y3;  \
+	VPSRLDQ      $8, X1, X4;    \
+	VPMOVSXBD    X4, Y2;        \
+	VCVTDQ2PS    Y2, Y2;        \
+	VFMADD231PS  32(DI), Y2, Y3; \
+	VEXTRACTI128 $1, Y1, X4;    \
+	VPMOVSXBD    X4, Y2;        \
+	VCVTDQ2PS    Y2, Y2;        \
+	VFMADD231PS  64(DI), Y2, Y3; \
+	VPSRLDQ      $8, X4, X4;    \
+	VPMOVSXBD    X4, Y2;        \
+	VCVTDQ2PS    Y2, Y2;        \
+	VFMADD231PS  96(DI), Y2, Y3; \
+	VBROADCASTSS (R8), Y5;      \
+	VFMADD231PS  Y5, Y3, Y0
+
+// NIBBLES expands the 16 bytes at the address into the 32 nibbles in Y1, low nibbles first
+#define NIBBLES(addr) \
+	VMOVDQU      addr, X1;      \
+	VPSRLW       $4, X1, X2;    \
+	VPAND        X6, X1, X1;    \
+	VPAND        X6, X2, X2;    \
+	VINSERTI128  $1, X2, Y1, Y1
+
+// HSUM adds the 8 float32 in Y0 up into X0
+#define HSUM \
+	VEXTRACTF128 $1, Y0, X1;    \
+	VADDPS       X1, X0, X0;    \
+	VHADDPS      X0, X0, X0;    \
+	VHADDPS      X0, X0, X0;    \
+	VZEROUPPER
+
+// func dotQ4AVX2(data *byte, stride int, scales *float32, x *float32, blocks int, bias int8) float32
+TEXT ·dotQ4AVX2(SB), NOSPLIT, $0-52
+	MOVQ    data+0(FP), SI
+	MOVQ    stride+8(FP), DX
+	MOVQ    scales+16(FP), R8
+	MOVQ    x+24(FP), DI
+	MOVQ    blocks+32(FP), CX
+	MOVBLZX bias+40(FP), AX
+
+	MOVQ         AX, X7
+	VPBROADCASTB X7, Y7
+	MOVL         $0x0f0f0f0f, AX
+	MOVQ         AX, X6
+	VPBROADCASTD X6, Y6
+	VXORPS       Y0, Y0, Y0
+
+q4Loop:
+	TESTQ CX, CX
+	JZ    q4Done
+	NIBBLES((SI))
+	VPSUBB Y7, Y1, Y1
+	BLOCK_DOT
+	ADDQ  DX, SI
+	ADDQ  $4, R8
+	ADDQ  $128, DI
+	DECQ  CX
+	JMP   q4Loop
+
+q4Done:
+	HSUM
+	MOVSS X0, ret+48(FP)
+	RET
+
+// func dotQ5AVX2(data *byte, stride int, scales *float32, x *float32, blocks int, bias int8) float32
+TEXT ·dotQ5AVX2(SB), NOSPLIT, $0-52
+	MOVQ    data+0(FP), SI
+	MOVQ    stride+8(FP), DX
+	MOVQ    scales+16(FP), R8
+	MOVQ    x+24(FP), DI
+	MOVQ    blocks+32(FP), CX
+	MOVBLZX bias+40(FP), AX
+
+	MOVQ         AX, X7
+	VPBROADCASTB X7, Y7
+	MOVL         $0x0f0f0f0f, AX
+	MOVQ         AX, X6
+	VPBROADCASTD X6, Y6
+	MOVL         $0x10101010, AX
+	MOVQ         AX, X8
+	VPBROADCASTD X8, Y8
+	VPCMPEQB     Y9, Y9, Y9
+	VMOVDQU      q5Shuffle<>(SB), Y10
+	VMOVDQU      q5Bits<>(SB), Y11
+	VXORPS       Y0, Y0, Y0
+
+q5Loop:
+	TESTQ CX, CX
+	JZ    q5Done
+
+	// the 5th bits become 0x10 in the bytes of their elements
+	VPBROADCASTD (SI), Y12
+	VPSHUFB      Y10, Y12, Y12
+	VPOR         Y11, Y12, Y12
+	VPCMPEQB     Y9, Y12, Y12
+	VPAND        Y8, Y12, Y12
+
+	NIBBLES(4(SI))
+	VPOR   Y12, Y1, Y1
+	VPSUBB Y7, Y1, Y1
+	BLOCK_DOT
+	ADDQ  DX, SI
+	ADDQ  $4, R8
+	ADDQ  $128, DI
+	DECQ  CX
+	JMP   q5Loop
+
+q5Done:
+	HSUM
+	MOVSS X0, ret+48(FP)
+	RET
+
+// func dotQ8AVX2(data *byte, stride int, scales *float32, x *float32, blocks int, bias int8) float32
+TEXT ·dotQ8AVX2(SB), NOSPLIT, $0-52
+	MOVQ    data+0(FP), SI
+	MOVQ    stride+8(FP), DX
+	MOVQ    scales+16(FP), R8
+	MOVQ    x+24(FP), DI
+	MOVQ    blocks+32(FP), CX
+	MOVBLZX bias+40(FP), AX
+
+	MOVQ         AX, X7
+	VPBROADCASTB X7, Y7
+	VXORPS       Y0, Y0, Y0
+
+q8Loop:
+	TESTQ CX, CX
+	JZ    q8Done
+	VMOVDQU (SI), Y1
+	VPSUBB  Y7, Y1, Y1
+	BLOCK_DOT
+	ADDQ  DX, SI
+	ADDQ  $4, R8
+	ADDQ  $128, DI
+	DECQ  CX
+	JMP   q8Loop
+
+q8Done:
+	HSUM
+	MOVSS X0, ret+48(FP)
+	RET
@@ -0,0 +1,279 @@
+package rwkv
+
+import (
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"path/filepath"
+	"testing"
+)
+
+/********************************************************************
+created:    2026-10-18
+author:     lixianmin
+
+Copyright (C) - All Rights Reserved
+*********************************************************************/
+
+var quantTypes = []rwkvType{rwkvTypeQ4_0, rwkvTypeQ4_1, rwkvTypeQ5_0, rwkvTypeQ5_1, rwkvTypeQ8_0}
+
+// referenceQuantValue decodes the element j of a block by the definitions of ggml's block_q4_0 to block_q8_0
+func referenceQuantValue(dataType rwkvType, block []byte, j int) float64 {
+	var half = func(offset int) float64 {
+		return float64(halfToFloat32(binary.LittleEndian.Uint16(block[offset:])))
+	}
+
+	var nibble = func(qs []byte) int {
+		if j < 16 {
+			return int(qs[j] & 0x0f)
+		}
+		return int(qs[j-16] >> 4)
+	}
+
+	var fifthBit = func(offset int) int {
+		return int(binary.LittleEndian.Uint32(block[offset:])>>j&1) << 4
+	}
+
+	switch dataType {
+	case rwkvTypeQ4_0: // d, qs[16]
+		return float64(nibble(block[2:])-8) * half(0)
+	case rwkvTypeQ4_1: // d, m, qs[16]
+		return float64(nibble(block[4:]))*half(0) + half(2)
+	case rwkvTypeQ5_0: // d, qh[4], qs[16]
+		return float64(nibble(block[6:])|fifthBit(2)-16) * half(0)
+	case rwkvTypeQ5_1: // d, m, qh[4], qs[16]
+		return float64(nibble(block[8:])|fifthBit(4))*half(0) + half(2)
+	default: // d, qs[32]
+		return float64(int8(block[2+j])) * half(0)
+	}
+}
+
+// newFuzzBlocks fills count blocks of dataType with seed, keeping the FP16 scales and minimums finite
+func newFuzzBlocks(dataType rwkvType, seed []byte, count int) []byte {
+	var format = quantFormats[dataType]
+	var data = make([]byte, count*format.blockBytes)
+	for i := range data {
+		if len(seed) > 0 {
+			data[i] = seed[i%len(seed)] ^ byte(i/len(seed)*31)
+		}
+	}
+
+	var finite = func(offset int) {
+		var h = binary.LittleEndian.Uint16(data[offset:])
+		h = h&0x83ff | (h>>10&0x0f+7)<<10
+		binary.LittleEndian.PutUint16(data[offset:], h)
+	}
+
+	for b := 0; b < count; b++ {
+		finite(b * format.blockBytes)
+		if format.minOffset >= 0 {
+			finite(b*format.blockBytes + format.minOffset)
+		}
+	}
+
+	return data
+}
+
+// quantKernelSets returns the generic kernels, and the ones in use when they are faster
+func quantKernelSets() [][3]quantKernel {
+	var sets = [][3]quantKernel{{dotQ4Generic, dotQ5Generic, dotQ8Generic}}
+	if quantAccelerated {
+		sets = append(sets, [3]quantKernel{dotQ4, dotQ5, dotQ8})
+	}
+
+	return sets
+}
+
+// useQuantKernels replaces the kernels in use until the test ends
+func useQuantKernels(t testing.TB, kernels [3]quantKernel) {
+	var q4, q5, q8 = dotQ4, dotQ5, dotQ8
+	dotQ4, dotQ5, dotQ8 = kernels[0], kernels[1], kernels[2]
+	t.Cleanup(func() { dotQ4, dotQ5, dotQ8 = q4, q5, q8 })
+}
+
+func FuzzQuantDequantize(f *testing.F) {
+	f.Add(uint8(0), []byte{0x00, 0x3c, 0x12, 0x34, 0xff})
+	f.Add(uint8(3), []byte("the quick brown fox jumps over the lazy dog"))
+	f.Add(uint8(4), []byte{0x80, 0x7f, 0x01, 0xfe})
+
+	f.Fuzz(func(t *testing.T, index uint8, seed []byte) {
+		var dataType = quantTypes[int(index)%len(quantTypes)]
+		var count = 1 + len(seed)%3
+		var data = newFuzzBlocks(dataType, seed, count)
+		var format = quantFormats[dataType]
+
+		var out = make([]float32, count*quantBlockSize)
+		dequantizeBlocks(format, data, out)
+		for i, v := range out {
+			var block = data[i/quantBlockSize*format.blockBytes:]
+			var want = referenceQuantValue(dataType, block, i%quantBlockSize)
+			if float32(want) != v {
+				t.Fatalf("%v: the element %d is %v, but %v is expected", dataType, i, v, want)
+			}
+		}
+	})
+}
+
+func FuzzQuantDot(f *testing.F) {
+	f.Add(uint8(0), []byte{0x00, 0x3c, 0x12, 0x34, 0xff}, int64(1))
+	f.Add(uint8(1), []byte("the quick brown fox jumps over the lazy dog"), int64(2))
+	f.Add(uint8(2), []byte{0xaa, 0x55, 0x0f, 0xf0, 0x11, 0x39}, int64(3))
+	f.Add(uint8(3), []byte{0x9c, 0x3b, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08}, int64(4))
+	f.Add(uint8(4), []byte{0x80, 0x7f, 0x01, 0xfe}, int64(5))
+
+	f.Fuzz(func(t *testing.T, index uint8, seed []byte, xSeed int64) {
+		var dataType = quantTypes[int(index)%len(quantTypes)]
+		var format = quantFormats[dataType]
+		var count = 1 + len(seed)%9
+		var data = newFuzzBlocks(dataType, seed, count)
+
+		var random = rand.New(rand.NewSource(xSeed))
+		var x = make([]float32, count*quantBlockSize)
+		for i := range x {
+			x[i] = 2*random.Float32() - 1
+		}
+
+		// the sums are exact in float64, and the kernels may round every term
+		var want, scale float64
+		for i, v := range x {
+			var block = data[i/quantBlockSize*format.blockBytes:]
+			var d, m = math.Abs(float64(halfToFloat32(binary.LittleEndian.Uint16(block)))), 0.0
+			if format.minOffset >= 0 {
+				m = math.Abs(float64(halfToFloat32(binary.LittleEndian.Uint16(block[format.minOffset:]))))
+			}
+
+			want += referenceQuantValue(dataType, block, i%quantBlockSize) * float64(v)
+			scale += (128*d + m) * math.Abs(float64(v))
+		}
+
+		var tensor = rwkvTensor{Name: "fuzz", DataType: dataType, DimCount: 2, Width: len(x), Height: 1, Depth: 1, Data: data}
+		var matrix, err = newGoMatrix(&tensor)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, kernels := range quantKernelSets() {
+			useQuantKernels(t, kernels)
+			var out = make([]float32, 1)
+			matrix.mulRows(out, x, 0, 1)
+			if math.Abs(float64(out[0])-want) > 1e-5*scale {
+				t.Fatalf("%v: the dot product is %v, but %v is expected", dataType, out[0], want)
+			}
+		}
+	})
+}
+
+func TestQuantMatrix(t *testing.T) {
+	const rows, cols = 37, 96
+	var random = rand.New(rand.NewSource(7))
+	var seed = make([]byte, 101)
+	random.Read(seed)
+
+	var x = make([]float32, cols)
+	for i := range x {
+		x[i] = 2*random.Float32() - 1
+	}
+
+	for _, dataType := range quantTypes {
+		var data = newFuzzBlocks(dataType, seed, rows*cols/quantBlockSize)
+		var tensor = rwkvTensor{Name: "matrix", DataType: dataType, DimCount: 2, Width: cols, Height: rows, Depth: 1, Data: data}
+		var matrix, err = newGoMatrix(&tensor)
+		assert(t, err == nil)
+
+		var out = make([]float32, rows)
+		new(goScratch).mulVec(matrix, out, x, 4)
+
+		var row = make([]float32, cols)
+		for r := 0; r < rows; r++ {
+			matrix.row(row, r)
+			var want = float64(dotFloat32(row, x))
+			assert(t, math.Abs(float64(out[r])-want) <= 1e-3*(1+math.Abs(want)), "a row should be its dequantized dot product")
+		}
+	}
+
+	var odd = rwkvTensor{Name: "odd", DataType: rwkvTypeQ4_0, DimCount: 2, Width: 48, Height: 1, Depth: 1, Data: make([]byte, 27)}
+	var _, err = newGoMatrix(&odd)
+	assert(t, err != nil, "a row should be made of whole blocks")
+}
+
+func BenchmarkQuantMatrix(b *testing.B) {
+	const rows, cols = 1024, 1024
+	var seed = make([]byte, 997)
+	rand.New(rand.NewSource(11)).Read(seed)
+
+	var x, out = make([]float32, cols), make([]float32, rows)
+	for i := range x {
+		x[i] = float32(i%7) - 3
+	}
+
+	var names = []string{"generic", "accelerated"}
+	for _, dataType := range quantTypes {
+		var tensor = rwkvTensor{Name: "matrix", DataType: dataType, DimCount: 2, Width: cols, Height: rows, Depth: 1,
+			Data: newFuzzBlocks(dataType, seed, rows*cols/quantBlockSize)}
+		var matrix, _ = newGoMatrix(&tensor)
+
+		for i, kernels := range quantKernelSets() {
+			b.Run(dataType.String()+"/"+names[i], func(b *testing.B) {
+				useQuantKernels(b, kernels)
+				b.SetBytes(int64(len(tensor.Data)))
+				for n := 0; n < b.N; n++ {
+					matrix.mulRows(out, x, 0, rows)
+				}
+			})
+		}
+	}
+}
+
+func TestGoBackendQuantizedMatchesC(t *testing.T) {
+	const nVocab, nEmbed, nLayer = 256, 64, 2
+	var dir = t.TempDir()
+	var source = filepath.Join(dir, "tiny-fp32.bin")
+	if err := writeFixtureModel(source, nVocab, nEmbed, nLayer, newTinyModelTensors(5, nVocab, nEmbed, nLayer)); err != nil {
+		t.Fatal(err)
+	}
+
+	var file, err = dumpRwkvLibrary(false)
+	skipUnsupported(t, err)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	quantizer, err := NewCRwkv(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range []QuantizedFormat{Q4_0, Q4_1, Q5_0, Q5_1, Q8_0} {
+		t.Run(string(format), func(t *testing.T) {
+			var path = filepath.Join(dir, "tiny-"+string(format)+".bin")
+			if err := quantizer.RwkvQuantizeModelFile(&RwkvCtx{}, source, path, format); err != nil {
+				t.Fatal(err)
+			}
+
+			var _, tensors, err = readRwkvModelFile(path)
+			assert(t, err == nil)
+			for _, tensor := range tensors {
+				if tensor.Name == "blocks.0.att.key.weight" {
+					assert(t, tensor.DataType.String() == string(format), "the matrices of the blocks should be quantized")
+				}
+			}
+
+			var cBackend, cCtx, goBackend, goCtx = newBackendPair(t, path)
+			var stateLength = cBackend.RwkvGetStateLength(cCtx)
+			var cState, goState = make([]float32, stateLength), make([]float32, stateLength)
+			cBackend.RwkvInitState(cCtx, cState)
+			goBackend.RwkvInitState(goCtx, goState)
+
+			// ggml quantizes the inputs of the matrices to 8 bits, the Go backend keeps them in float32
+			var cLogits, goLogits = make([]float32, nVocab), make([]float32, nVocab)
+			for i, token := range []uint32{1, 17, 255, 0, 42, 99, 3, 128} {
+				assert(t, cBackend.RwkvEval(cCtx, token, cState, cState, cLogits) == nil)
+				assert(t, goBackend.RwkvEval(goCtx, token, goState, goState, goLogits) == nil)
+
+				if diff := maxRelativeDiff(cLogits, goLogits); diff > 5e-3 {
+					t.Fatalf("the logits of token %d differ by %g", i, diff)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !darwin && !linux && !(windows && amd64)
+
+package rwkv
+
+/********************************************************************
+created:    2026-10-18
+author:     lixianmin
+
+Copyright (C) - All Rights Reserved
+*********************************************************************/
+
+// GetGPUInfo returns ErrBackendUnsupported, the GPU builds of rwkv.cpp cannot be loaded on this platform either
+func GetGPUInfo() (string, error) {
+	return "", ErrBackendUnsupported
+}
@@ -0,0 +1,172 @@
+package rwkv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+/********************************************************************
+created:    2026-10-18
+author:     lixianmin
+
+Copyright (C) - All Rights Reserved
+*********************************************************************/
+
+var ErrInvalidHistoryPolicy = errors.New("the history policy is invalid")
+
+// HistoryPolicy decides which turns a Chatbot keeps. When it cuts the history, the chatbot rebuilds its state from
+// the prompt plus the turns left, so that the model no longer sees the dropped ones.
+type HistoryPolicy interface {
+	// Apply returns the messages to keep in place of history, and false when history stays as it is
+	Apply(bot *Chatbot, history []Message) ([]Message, bool, error)
+}
+
+// KeepLastTurns keeps the last Turns turns, a turn is a user message with the replies to it. Turns must be at least
+// 1, since the turn just answered is always kept.
+type KeepLastTurns struct {
+	Turns int
+}
+
+func (my KeepLastTurns) Apply(bot *Chatbot, history []Message) ([]Message, bool, error) {
+	if my.Turns < 1 {
+		return nil, false, fmt.Errorf("%w: KeepLastTurns.Turns is %d, less than 1", ErrInvalidHistoryPolicy, my.Turns)
+	}
+
+	var turns = splitTurns(history)
+	if len(turns) <= my.Turns {
+		return history, false, nil
+	}
+
+	return joinTurns(turns[len(turns)-my.Turns:]), true, nil
+}
+
+// TokenBudget drops the oldest turns until the rendered history takes at most MaxTokens tokens, the last turn is
+// always kept
+type TokenBudget struct {
+	MaxTokens int
+}
+
+func (my TokenBudget) Apply(bot *Chatbot, history []Message) ([]Message, bool, error) {
+	var turns = splitTurns(history)
+	var first = 0
+	for ; first < len(turns)-1; first++ {
+		var text, err = bot.template.Render(joinTurns(turns[first:]), false)
+		if err != nil {
+			return nil, false, err
+		}
+
+		if len(bot.model.Encode(text)) <= my.MaxTokens {
+			break
+		}
+	}
+
+	if first == 0 {
+		return history, false, nil
+	}
+
+	return joinTurns(turns[first:]), true, nil
+}
+
+const defaultSummaryInstruction = "Summarize the following conversation in a few sentences, keep the names, facts and decisions."
+
+// SummarizeHistory lets the model summarize the older turns once there are more than MaxTurns turns. The summary
+// replaces them as a system message, followed by the last KeepTurns turns.
+type SummarizeHistory struct {
+	MaxTurns         int
+	KeepTurns        int    // 0 for MaxTurns/2
+	MaxSummaryTokens int    // 0 for the MaxTokens of the chatbot's sampling
+	Instruction      string // "" for a default instruction in English
+}
+
+func (my SummarizeHistory) Apply(bot *Chatbot, history []Message) ([]Message, bool, error) {
+	var turns = splitTurns(history)
+	if len(turns) <= my.MaxTurns {
+		return history, false, nil
+	}
+
+	var keep = my.KeepTurns
+	if keep <= 0 {
+		keep = my.MaxTurns / 2
+	}
+	keep = min(keep, len(turns)-1)
+
+	var older = joinTurns(turns[:len(turns)-keep])
+	var summary, err = my.summarize(bot, older)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var kept = make([]Message, 0, len(history))
+	if summary != "" {
+		kept = append(kept, Message{Role: RoleSystem, Content: summary})
+	}
+
+	kept = append(kept, joinTurns(turns[len(turns)-keep:])...)
+	return kept, true, nil
+}
+
+// summarize asks the model for a summary of messages, starting from a blank state instead of the chatbot's prompt
+func (my SummarizeHistory) summarize(bot *Chatbot, messages []Message) (string, error) {
+	var transcript, err = bot.template.Render(messages, false)
+	if err != nil {
+		return "", err
+	}
+
+	var instruction = my.Instruction
+	if instruction == "" {
+		instruction = defaultSummaryInstruction
+	}
+
+	var request = []Message{{Role: RoleUser, Content: instruction + "\n" + transcript}}
+	text, err := bot.template.Render(request, true)
+	if err != nil {
+		return "", err
+	}
+
+	var model = bot.model
+	var tokens = model.Encode(text)
+	var state, logits = model.newState()
+	if err = model.evalTokens(tokens, state, logits); err != nil {
+		return "", err
+	}
+
+	var sampling = bot.sampling
+	if my.MaxSummaryTokens > 0 {
+		sampling.MaxTokens = my.MaxSummaryTokens
+	}
+
+	adjustLogits(logits, tokens, -999999999, nil)
+	summary, _, err := generateReply(context.Background(), model, state, logits, replyOptions{
+		sampling:  sampling,
+		stopTexts: bot.stopTexts,
+	})
+
+	return strings.TrimSpace(summary), err
+}
+
+// splitTurns groups messages into turns, every user message starts a new turn. Messages before the first user
+// message, such as a summary, form a turn of their own.
+func splitTurns(messages []Message) [][]Message {
+	var turns [][]Message
+	for i, message := range messages {
+		if i == 0 || message.Role == RoleUser {
+			turns = append(turns, nil)
+		}
+
+		var last = len(turns) - 1
+		turns[last] = append(turns[last], message)
+	}
+
+	return turns
+}
+
+func joinTurns(turns [][]Message) []Message {
+	var messages []Message
+	for _, turn := range turns {
+		messages = append(messages, turn...)
+	}
+
+	return messages
+}
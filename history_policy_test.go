@@ -0,0 +1,64 @@
+package rwkv
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+)
+
+/********************************************************************
+created:    2026-10-18
+author:     lixianmin
+
+Copyright (C) - All Rights Reserved
+*********************************************************************/
+
+func newTestHistory(turns int) []Message {
+	var history = []Message{{Role: RoleSystem, Content: "summary"}}
+	for i := 0; i < turns; i++ {
+		history = append(history,
+			Message{Role: RoleUser, Content: fmt.Sprintf("question %d", i)},
+			Message{Role: RoleAssistant, Content: fmt.Sprintf("answer %d", i)},
+		)
+	}
+
+	return history
+}
+
+func TestKeepLastTurns(t *testing.T) {
+	var history = newTestHistory(3)
+	assert(t, len(splitTurns(history)) == 4)
+
+	var kept, changed, _ = KeepLastTurns{Turns: 2}.Apply(nil, history)
+	assert(t, changed && slices.Equal(kept, history[3:]))
+
+	kept, changed, _ = KeepLastTurns{Turns: 4}.Apply(nil, history)
+	assert(t, !changed && len(kept) == len(history))
+
+	var _, _, err = KeepLastTurns{}.Apply(nil, history)
+	assert(t, errors.Is(err, ErrInvalidHistoryPolicy), "0 turns should be rejected")
+}
+
+func TestHistoryPolicies(t *testing.T) {
+	var model = newTinyChatModel(t)
+	var bot = NewChatbotWithTemplate(model, WorldTemplate, "")
+	var history = newTestHistory(4)
+
+	var text, _ = WorldTemplate.Render(history[5:], false)
+	var budget = len(model.Encode(text))
+	var kept, changed, err = TokenBudget{MaxTokens: budget}.Apply(bot, history)
+	assert(t, err == nil && changed && slices.Equal(kept, history[5:]))
+
+	kept, changed, err = SummarizeHistory{MaxTurns: 4, KeepTurns: 1, MaxSummaryTokens: 4}.Apply(bot, history)
+	assert(t, err == nil && changed)
+	assert(t, slices.Equal(kept[len(kept)-2:], history[7:]), "the last turn should be kept")
+	assert(t, len(kept) == 2 || kept[0].Role == RoleSystem, "the older turns should be summarized")
+
+	// the chatbot rebuilds its state from the turns left
+	bot.SetHistoryPolicy(KeepLastTurns{Turns: 1})
+	bot.SetSampling(SamplingOptions{MaxTokens: 2, Temperature: 1, TopP: 1})
+	bot.Process("hello")
+	bot.Process("again")
+	assert(t, len(bot.History()) == 2 && bot.History()[0].Content == "again")
+}
@@ -0,0 +1,411 @@
+package rwkv
+
+import (
+	"container/heap"
+	"encoding/gob"
+	"io"
+	"math"
+	"math/rand"
+	"slices"
+	"sync"
+)
+
+/********************************************************************
+created:    2026-10-18
+author:     lixianmin
+
+Copyright (C) - All Rights Reserved
+*********************************************************************/
+
+type HNSWOptions struct {
+	M              int // the neighbor count of a vector above the bottom layer, twice on the bottom layer. 16 by default
+	EfConstruction int // the candidate count when adding, larger builds a better graph slower. 200 by default
+	EfSearch       int // the candidate count when searching, larger is more accurate and slower. 64 by default
+}
+
+// HNSWIndex is an approximate index built on a hierarchical navigable small world graph, it searches in about
+// logarithmic time. A deleted vector stays in the graph as a waypoint, but is no longer returned, and the graph is
+// rebuilt from the live vectors once the deleted ones outnumber them.
+type HNSWIndex struct {
+	lock       sync.RWMutex
+	dim        int
+	similarity Similarity
+	options    HNSWOptions
+	levelScale float64
+	random     *rand.Rand
+
+	nodes    []hnswNode
+	ids      map[string]int32 // the live node of every id
+	entry    int32            // the node to start searching from, -1 when empty
+	maxLevel int
+	count    int // the count of live nodes
+}
+
+type hnswNode struct {
+	id        string
+	vector    []float32
+	neighbors [][]int32 // the neighbors on every level, from the bottom up
+	deleted   bool
+}
+
+func NewHNSWIndex(dim int, similarity Similarity, options HNSWOptions) *HNSWIndex {
+	if options.M <= 1 {
+		options.M = 16
+	}
+
+	if options.EfConstruction <= 0 {
+		options.EfConstruction = 200
+	}
+
+	if options.EfSearch <= 0 {
+		options.EfSearch = 64
+	}
+
+	return &HNSWIndex{
+		dim:        dim,
+		similarity: similarity,
+		options:    options,
+		levelScale: 1 / math.Log(float64(options.M)),
+		random:     rand.New(rand.NewSource(1)),
+		ids:        make(map[string]int32),
+		entry:      -1,
+	}
+}
+
+func newHNSWIndexFromSnapshot(snapshot *vectorIndexSnapshot) (*HNSWIndex, error) {
+	var count = len(snapshot.IDs)
+	if len(snapshot.Vectors) != count || len(snapshot.Neighbors) != count || len(snapshot.Deleted) != count {
+		return nil, ErrUnknownVectorIndex
+	}
+
+	var index = NewHNSWIndex(snapshot.Dim, snapshot.Similarity, snapshot.Options)
+	index.nodes = make([]hnswNode, count)
+	for i := range index.nodes {
+		if len(snapshot.Vectors[i]) != snapshot.Dim {
+			return nil, ErrDimensionMismatch
+		}
+
+		for _, neighbors := range snapshot.Neighbors[i] {
+			for _, neighbor := range neighbors {
+				if neighbor < 0 || int(neighbor) >= count {
+					return nil, ErrUnknownVectorIndex
+				}
+			}
+		}
+
+		index.nodes[i] = hnswNode{
+			id:        snapshot.IDs[i],
+			vector:    snapshot.Vectors[i],
+			neighbors: snapshot.Neighbors[i],
+			deleted:   snapshot.Deleted[i],
+		}
+
+		if !snapshot.Deleted[i] {
+			index.ids[snapshot.IDs[i]] = int32(i)
+			index.count++
+		}
+	}
+
+	if snapshot.Entry < -1 || snapshot.Entry >= count {
+		return nil, ErrUnknownVectorIndex
+	}
+
+	index.entry = int32(snapshot.Entry)
+	index.maxLevel = snapshot.MaxLevel
+	if count-index.count > index.count {
+		index.rebuild()
+	}
+
+	return index, nil
+}
+
+func (my *HNSWIndex) Add(id string, vector []float32) error {
+	if len(vector) != my.dim {
+		return ErrDimensionMismatch
+	}
+
+	vector = prepareVector(vector, my.similarity)
+
+	my.lock.Lock()
+	defer my.lock.Unlock()
+
+	// an existing id keeps its node, which is linked again by the new vector
+	if current, ok := my.ids[id]; ok {
+		my.nodes[current].vector = vector
+		my.link(current, len(my.nodes[current].neighbors)-1)
+		return nil
+	}
+
+	my.insert(id, vector)
+	return nil
+}
+
+// insert adds a node of id at a random level, vector is prepared already
+func (my *HNSWIndex) insert(id string, vector []float32) {
+	var level = int(-math.Log(1-my.random.Float64()) * my.levelScale)
+	var current = int32(len(my.nodes))
+	my.nodes = append(my.nodes, hnswNode{id: id, vector: vector, neighbors: make([][]int32, level+1)})
+	my.ids[id] = current
+	my.count++
+
+	if my.entry < 0 {
+		my.entry = current
+		my.maxLevel = level
+		return
+	}
+
+	my.link(current, level)
+	if level > my.maxLevel {
+		my.entry = current
+		my.maxLevel = level
+	}
+}
+
+// link connects current to its closest nodes on the levels up to level, which are found from the entry point
+func (my *HNSWIndex) link(current int32, level int) {
+	var vector = my.nodes[current].vector
+	var entry = my.entry
+	for l := my.maxLevel; l > level; l-- {
+		entry = my.searchLayer(vector, entry, 1, l)[0].node
+	}
+
+	for l := min(level, my.maxLevel); l >= 0; l-- {
+		var candidates = my.searchLayer(vector, entry, my.options.EfConstruction, l)
+		var neighbors = make([]int32, 0, my.options.M)
+		for _, candidate := range candidates {
+			if candidate.node != current && len(neighbors) < my.options.M {
+				neighbors = append(neighbors, candidate.node)
+			}
+		}
+
+		my.nodes[current].neighbors[l] = neighbors
+		for _, neighbor := range neighbors {
+			my.connect(neighbor, current, l)
+		}
+
+		entry = candidates[0].node
+	}
+}
+
+// connect adds to to the neighbors of from on level, and keeps only the closest ones when there are too many
+func (my *HNSWIndex) connect(from int32, to int32, level int) {
+	var node = &my.nodes[from]
+	if slices.Contains(node.neighbors[level], to) {
+		return
+	}
+
+	var neighbors = append(node.neighbors[level], to)
+
+	var limit = my.options.M
+	if level == 0 {
+		limit *= 2
+	}
+
+	if len(neighbors) > limit {
+		var scored = make([]hnswCandidate, len(neighbors))
+		for i, neighbor := range neighbors {
+			scored[i] = hnswCandidate{node: neighbor, score: dotProduct(node.vector, my.nodes[neighbor].vector)}
+		}
+
+		sortCandidates(scored)
+		neighbors = neighbors[:0]
+		for _, candidate := range scored[:limit] {
+			neighbors = append(neighbors, candidate.node)
+		}
+	}
+
+	node.neighbors[level] = neighbors
+}
+
+func (my *HNSWIndex) Delete(id string) bool {
+	my.lock.Lock()
+	defer my.lock.Unlock()
+	return my.deleteLocked(id)
+}
+
+func (my *HNSWIndex) deleteLocked(id string) bool {
+	var node, ok = my.ids[id]
+	if !ok {
+		return false
+	}
+
+	my.nodes[node].deleted = true
+	delete(my.ids, id)
+	my.count--
+
+	if len(my.nodes)-my.count > my.count {
+		my.rebuild()
+	}
+
+	return true
+}
+
+// rebuild drops the deleted nodes by inserting the live ones again into an empty graph
+func (my *HNSWIndex) rebuild() {
+	var nodes = my.nodes
+	my.nodes = make([]hnswNode, 0, my.count)
+	my.ids = make(map[string]int32, my.count)
+	my.entry = -1
+	my.maxLevel = 0
+	my.count = 0
+
+	for _, node := range nodes {
+		if !node.deleted {
+			my.insert(node.id, node.vector)
+		}
+	}
+}
+
+func (my *HNSWIndex) Search(query []float32, k int) ([]SearchResult, error) {
+	if len(query) != my.dim {
+		return nil, ErrDimensionMismatch
+	}
+
+	query = prepareVector(query, my.similarity)
+
+	my.lock.RLock()
+	defer my.lock.RUnlock()
+
+	if my.entry < 0 || k <= 0 {
+		return nil, nil
+	}
+
+	var entry = my.entry
+	for l := my.maxLevel; l > 0; l-- {
+		entry = my.searchLayer(query, entry, 1, l)[0].node
+	}
+
+	// the deleted nodes take places in the candidates, which are widened by their share, at most twice since rebuild
+	// keeps them fewer than the live ones
+	var ef = max(my.options.EfSearch, k) * len(my.nodes) / max(my.count, 1)
+	var results = make([]SearchResult, 0, k)
+	for _, candidate := range my.searchLayer(query, entry, ef, 0) {
+		if node := &my.nodes[candidate.node]; !node.deleted {
+			results = append(results, SearchResult{ID: node.id, Score: candidate.score})
+			if len(results) == k {
+				break
+			}
+		}
+	}
+
+	return results, nil
+}
+
+func (my *HNSWIndex) Len() int {
+	my.lock.RLock()
+	defer my.lock.RUnlock()
+	return my.count
+}
+
+func (my *HNSWIndex) Save(writer io.Writer) error {
+	my.lock.RLock()
+	defer my.lock.RUnlock()
+
+	var count = len(my.nodes)
+	var snapshot = vectorIndexSnapshot{
+		Kind:       hnswIndexKind,
+		Dim:        my.dim,
+		Similarity: my.similarity,
+		IDs:        make([]string, count),
+		Vectors:    make([][]float32, count),
+		Options:    my.options,
+		Neighbors:  make([][][]int32, count),
+		Deleted:    make([]bool, count),
+		Entry:      int(my.entry),
+		MaxLevel:   my.maxLevel,
+	}
+
+	for i, node := range my.nodes {
+		snapshot.IDs[i] = node.id
+		snapshot.Vectors[i] = node.vector
+		snapshot.Neighbors[i] = node.neighbors
+		snapshot.Deleted[i] = node.deleted
+	}
+
+	return gob.NewEncoder(writer).Encode(&snapshot)
+}
+
+type hnswCandidate struct {
+	node  int32
+	score float32
+}
+
+// searchLayer returns the ef nodes closest to query on level, reached from entry, the closest first
+func (my *HNSWIndex) searchLayer(query []float32, entry int32, ef int, level int) []hnswCandidate {
+	var visited = map[int32]struct{}{entry: {}}
+	var first = hnswCandidate{node: entry, score: dotProduct(query, my.nodes[entry].vector)}
+
+	var candidates = &candidateHeap{items: []hnswCandidate{first}, closestFirst: true}
+	var results = &candidateHeap{items: []hnswCandidate{first}}
+
+	for candidates.Len() > 0 {
+		var current = heap.Pop(candidates).(hnswCandidate)
+		if results.Len() >= ef && current.score < results.items[0].score {
+			break
+		}
+
+		var neighbors = my.nodes[current.node].neighbors
+		if level >= len(neighbors) {
+			continue
+		}
+
+		for _, neighbor := range neighbors[level] {
+			if _, ok := visited[neighbor]; ok {
+				continue
+			}
+			visited[neighbor] = struct{}{}
+
+			var score = dotProduct(query, my.nodes[neighbor].vector)
+			if results.Len() < ef || score > results.items[0].score {
+				var candidate = hnswCandidate{node: neighbor, score: score}
+				heap.Push(candidates, candidate)
+				heap.Push(results, candidate)
+				if results.Len() > ef {
+					heap.Pop(results)
+				}
+			}
+		}
+	}
+
+	sortCandidates(results.items)
+	return results.items
+}
+
+func sortCandidates(candidates []hnswCandidate) {
+	slices.SortFunc(candidates, func(a, b hnswCandidate) int {
+		if a.score > b.score {
+			return -1
+		} else if a.score < b.score {
+			return 1
+		}
+
+		return int(a.node) - int(b.node)
+	})
+}
+
+// candidateHeap pops the closest candidate first when closestFirst, otherwise the farthest first
+type candidateHeap struct {
+	items        []hnswCandidate
+	closestFirst bool
+}
+
+func (my *candidateHeap) Len() int { return len(my.items) }
+
+func (my *candidateHeap) Less(i, j int) bool {
+	if my.closestFirst {
+		return my.items[i].score > my.items[j].score
+	}
+
+	return my.items[i].score < my.items[j].score
+}
+
+func (my *candidateHeap) Swap(i, j int) { my.items[i], my.items[j] = my.items[j], my.items[i] }
+
+func (my *candidateHeap) Push(x any) { my.items = append(my.items, x.(hnswCandidate)) }
+
+func (my *candidateHeap) Pop() any {
+	var last = len(my.items) - 1
+	var item = my.items[last]
+	my.items = my.items[:last]
+	return item
+}
@@ -0,0 +1,247 @@
+package rwkv
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/********************************************************************
+created:    2026-10-18
+author:     lixianmin
+
+Copyright (C) - All Rights Reserved
+*********************************************************************/
+
+// LoraOptions tells LoadLoraAdapter how to read an adapter
+type LoraOptions struct {
+	// Alpha scales the adapter by Alpha/rank, 0 reads lora_alpha from the metadata of the file
+	Alpha float64
+}
+
+// LoraAdapter holds the low rank matrices of a LoRA adapter by the names of the weights they adapt, and a weight W
+// becomes W + B·A·alpha/rank
+type LoraAdapter struct {
+	layers  map[string]*loraLayer
+	ignored []string
+}
+
+// loraLayer is the pair of a weight of out rows and in columns, A is rank×in and B is out×rank
+type loraLayer struct {
+	a     []float32
+	b     []float32
+	rank  int
+	in    int
+	out   int
+	scale float32
+}
+
+var ErrInvalidLora = errors.New("the LoRA adapter is invalid")
+
+// LoadLoraAdapter reads the lora_A and lora_B matrices of a safetensors file, named like blocks.0.att.key.lora_A by
+// RWKV-LM-LoRA, or base_model.model.blocks.0.att.key.lora_A.weight by PEFT. The other tensors are listed by Ignored.
+func LoadLoraAdapter(path string, options LoraOptions) (*LoraAdapter, error) {
+	var file, err = readSafetensorsFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var alpha = options.Alpha
+	if alpha == 0 {
+		var text, ok = file.Metadata["lora_alpha"]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s has no lora_alpha in its metadata, set LoraOptions.Alpha", ErrInvalidLora, path)
+		}
+
+		if alpha, err = strconv.ParseFloat(text, 64); err != nil {
+			return nil, fmt.Errorf("%w: the lora_alpha %q of %s is not a number", ErrInvalidLora, text, path)
+		}
+	}
+
+	var adapter = &LoraAdapter{layers: map[string]*loraLayer{}}
+	var pairs = map[string]*[2]*safetensor{}
+	for name, tensor := range file.Tensors {
+		var target, part, ok = loraTarget(name)
+		if !ok {
+			adapter.ignored = append(adapter.ignored, name)
+			continue
+		}
+
+		if pairs[target] == nil {
+			pairs[target] = &[2]*safetensor{}
+		}
+		pairs[target][part] = tensor
+	}
+	sort.Strings(adapter.ignored)
+
+	for target, pair := range pairs {
+		var a, b = pair[0], pair[1]
+		if a == nil || b == nil {
+			return nil, fmt.Errorf("%w: %s has only one of lora_A and lora_B", ErrInvalidLora, target)
+		}
+
+		if len(a.Shape) != 2 || len(b.Shape) != 2 || a.Shape[0] != b.Shape[1] {
+			return nil, fmt.Errorf("%w: lora_A%v and lora_B%v of %s do not match", ErrInvalidLora, a.Shape, b.Shape, target)
+		}
+
+		var rank = a.Shape[0]
+		if rank <= 0 {
+			return nil, fmt.Errorf("%w: the rank of %s is %d", ErrInvalidLora, target, rank)
+		}
+
+		adapter.layers[target] = &loraLayer{a: a.float32s(), b: b.float32s(), rank: rank, in: a.Shape[1], out: b.Shape[0],
+			scale: float32(alpha / float64(rank))}
+	}
+
+	if len(adapter.layers) == 0 {
+		return nil, fmt.Errorf("%w: %s has no lora_A or lora_B", ErrInvalidLora, path)
+	}
+
+	return adapter, nil
+}
+
+// loraTarget returns the name of the weight a LoRA matrix adapts, and 0 for lora_A or 1 for lora_B
+func loraTarget(name string) (string, int, bool) {
+	name = strings.TrimPrefix(name, "base_model.model.")
+	name = strings.TrimSuffix(strings.TrimSuffix(name, ".weight"), ".default")
+
+	var index = strings.LastIndex(name, ".lora_")
+	if index < 0 || len(name) != index+len(".lora_A") {
+		return "", 0, false
+	}
+
+	switch name[len(name)-1] {
+	case 'A':
+		return name[:index] + ".weight", 0, true
+	case 'B':
+		return name[:index] + ".weight", 1, true
+	default:
+		return "", 0, false
+	}
+}
+
+// Targets returns the sorted names of the weights the adapter changes
+func (my *LoraAdapter) Targets() []string {
+	var names = make([]string, 0, len(my.layers))
+	for name := range my.layers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Ignored returns the sorted names of the tensors which are not LoRA matrices, such as the fully trained layer norms
+func (my *LoraAdapter) Ignored() []string {
+	return append([]string(nil), my.ignored...)
+}
+
+// MergeLoraModelFile adds adapter to the FP32 or FP16 weights of the model file in, and writes the result to out in
+// the same data types. Merge before quantizing, since the quantized weights can not take the small deltas.
+func MergeLoraModelFile(in string, out string, adapter *LoraAdapter) error {
+	var source, err = os.Open(in)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	var reader = bufio.NewReaderSize(source, 1<<20)
+	header, err := readRwkvFileHeader(reader)
+	if err != nil {
+		return unexpectedEOF(err)
+	}
+
+	return writeFileAtomically(out, func(writer io.Writer) error {
+		if err := writeRwkvFileHeader(writer, header); err != nil {
+			return err
+		}
+
+		var merged = 0
+		for {
+			var tensor, err = readRwkvTensor(reader, header.Version)
+			if err == io.EOF {
+				break
+			}
+
+			if err != nil {
+				return err
+			}
+
+			if layer, ok := adapter.layers[tensor.Name]; ok {
+				if err = layer.mergeInto(&tensor); err != nil {
+					return err
+				}
+				merged++
+			}
+
+			if err = writeRwkvTensor(writer, &tensor); err != nil {
+				return err
+			}
+		}
+
+		if merged != len(adapter.layers) {
+			return fmt.Errorf("%w: only %d of the %d weights of the adapter are in %s", ErrInvalidLora, merged,
+				len(adapter.layers), in)
+		}
+
+		return nil
+	})
+}
+
+func (my *loraLayer) mergeInto(tensor *rwkvTensor) error {
+	if tensor.DataType.isQuantized() {
+		return fmt.Errorf("%w: %s is %v, but FP32 or FP16 is expected", ErrInvalidModelFile, tensor.Name, tensor.DataType)
+	}
+
+	if tensor.DimCount != 2 || tensor.Width != my.in || tensor.Height != my.out {
+		return fmt.Errorf("%w: %s is %dx%d, but the adapter is %dx%d", ErrInvalidLora, tensor.Name, tensor.Height,
+			tensor.Width, my.out, my.in)
+	}
+
+	var values, err = tensor.float32s()
+	if err != nil {
+		return err
+	}
+
+	my.addTo(values)
+	tensor.Data = encodeTensor(tensor.DataType, values)
+	return nil
+}
+
+// addTo adds B·A·scale to the weight w, the rows are split among the CPUs
+func (my *loraLayer) addTo(w []float32) {
+	var threads = runtime.NumCPU()
+	var step = (my.out + threads - 1) / threads
+
+	var wg sync.WaitGroup
+	for start := 0; start < my.out; start += step {
+		wg.Add(1)
+		go func(start int, end int) {
+			defer wg.Done()
+			var delta = make([]float32, my.in)
+			for o := start; o < end; o++ {
+				for i := range delta {
+					delta[i] = 0
+				}
+
+				for k, bk := range my.b[o*my.rank : (o+1)*my.rank] {
+					for i, a := range my.a[k*my.in : (k+1)*my.in] {
+						delta[i] += bk * a
+					}
+				}
+
+				var row = w[o*my.in : (o+1)*my.in]
+				for i, d := range delta {
+					row[i] += d * my.scale
+				}
+			}
+		}(start, min(start+step, my.out))
+	}
+	wg.Wait()
+}
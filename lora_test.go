@@ -0,0 +1,194 @@
+package rwkv
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+/********************************************************************
+created:    2026-10-18
+author:     lixianmin
+
+Copyright (C) - All Rights Reserved
+*********************************************************************/
+
+// writeFixtureSafetensors writes tensors in dtype, F32, F16 or BF16, like safetensors.torch.save_file
+func writeFixtureSafetensors(path string, metadata map[string]string, dtype string, tensors []fixtureTensor) error {
+	var header = map[string]any{}
+	if metadata != nil {
+		header["__metadata__"] = metadata
+	}
+
+	var data []byte
+	for _, tensor := range tensors {
+		var start = len(data)
+		for _, v := range tensor.data {
+			switch dtype {
+			case "F32":
+				data = binary.LittleEndian.AppendUint32(data, math.Float32bits(v))
+			case "F16":
+				data = binary.LittleEndian.AppendUint16(data, float32ToHalf(v))
+			case "BF16":
+				data = binary.LittleEndian.AppendUint16(data, uint16(math.Float32bits(v)>>16))
+			}
+		}
+		header[tensor.name] = map[string]any{"dtype": dtype, "shape": tensor.shape, "data_offsets": []int{start, len(data)}}
+	}
+
+	var text, err = json.Marshal(header)
+	if err != nil {
+		return err
+	}
+
+	var content = binary.LittleEndian.AppendUint64(nil, uint64(len(text)))
+	content = append(append(content, text...), data...)
+	return os.WriteFile(path, content, 0644)
+}
+
+// newFixtureLoraPair creates lora_A and lora_B of name for a weight of out rows and in columns
+func newFixtureLoraPair(random *rand.Rand, name string, suffix string, rank, out, in int) []fixtureTensor {
+	var uniform = func(n int) []float32 {
+		var data = make([]float32, n)
+		for i := range data {
+			data[i] = 0.2*random.Float32() - 0.1
+		}
+		return data
+	}
+
+	return []fixtureTensor{
+		{name: name + ".lora_A" + suffix, shape: []int{rank, in}, data: uniform(rank * in)},
+		{name: name + ".lora_B" + suffix, shape: []int{out, rank}, data: uniform(out * rank)},
+	}
+}
+
+func TestMergeLoraModelFile(t *testing.T) {
+	const nVocab, nEmbed, nLayer, rank = 256, 64, 2, 4
+	var random = rand.New(rand.NewSource(3))
+	var dir = t.TempDir()
+
+	// RWKV-LM-LoRA names with a fully trained layer norm, and PEFT names
+	var tensors = newFixtureLoraPair(random, "blocks.0.att.key", "", rank, nEmbed, nEmbed)
+	tensors = append(tensors, newFixtureLoraPair(random, "blocks.1.ffn.key", "", rank, 4*nEmbed, nEmbed)...)
+	tensors = append(tensors, newFixtureLoraPair(random, "base_model.model.head", ".weight", rank, nVocab, nEmbed)...)
+	tensors = append(tensors, fixtureTensor{name: "blocks.0.ln1.weight", shape: []int{nEmbed}, data: make([]float32, nEmbed)})
+
+	var adapterPath = filepath.Join(dir, "adapter.safetensors")
+	var err = writeFixtureSafetensors(adapterPath, map[string]string{"lora_alpha": "8"}, "BF16", tensors)
+	assert(t, err == nil)
+
+	adapter, err := LoadLoraAdapter(adapterPath, LoraOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var targets = adapter.Targets()
+	assert(t, len(targets) == 3 && targets[0] == "blocks.0.att.key.weight" && targets[2] == "head.weight")
+	assert(t, len(adapter.Ignored()) == 1 && adapter.Ignored()[0] == "blocks.0.ln1.weight")
+
+	for _, dataType := range []rwkvType{rwkvTypeFP32, rwkvTypeFP16} {
+		var base, merged = filepath.Join(dir, "base.bin"), filepath.Join(dir, "merged.bin")
+		var model = newTinyModelTensors(4, nVocab, nEmbed, nLayer)
+		assert(t, writeFixtureModelType(base, dataType, nVocab, nEmbed, nLayer, model) == nil)
+		if err = MergeLoraModelFile(base, merged, adapter); err != nil {
+			t.Fatal(err)
+		}
+
+		var _, baseTensors, _ = readRwkvModelFile(base)
+		var _, mergedTensors, _ = readRwkvModelFile(merged)
+		assert(t, len(baseTensors) == len(mergedTensors))
+		for i, tensor := range mergedTensors {
+			var layer, ok = adapter.layers[tensor.Name]
+			assert(t, tensor.DataType == baseTensors[i].DataType, "the data types should be kept")
+			if !ok {
+				assert(t, string(tensor.Data) == string(baseTensors[i].Data), tensor.Name+" should be kept")
+				continue
+			}
+
+			// W + B·A·alpha/rank in float64, with the adapter rounded to BF16
+			var before, _ = baseTensors[i].float32s()
+			var after, _ = tensor.float32s()
+			var maxDiff, maxDelta float64
+			for o := 0; o < layer.out; o++ {
+				for c := 0; c < layer.in; c++ {
+					var delta float64
+					for k := 0; k < rank; k++ {
+						delta += float64(layer.b[o*rank+k]) * float64(layer.a[k*layer.in+c])
+					}
+					delta *= 8.0 / rank
+
+					var want = float64(before[o*layer.in+c]) + delta
+					maxDiff = math.Max(maxDiff, math.Abs(float64(after[o*layer.in+c])-want))
+					maxDelta = math.Max(maxDelta, math.Abs(delta))
+				}
+			}
+
+			var tolerance = 1e-6
+			if dataType == rwkvTypeFP16 {
+				tolerance = 1e-3
+			}
+			assert(t, maxDelta > 1e-3 && maxDiff < tolerance, tensor.Name+" should be W + B·A·alpha/rank")
+		}
+
+		var backend = NewGoRwkv()
+		var ctx = backend.RwkvInitFromFile(merged, 1)
+		assert(t, hasCtx(ctx) == nil, "the merged model should load")
+		_ = backend.RwkvFree(ctx)
+	}
+
+	// the explicit alpha wins over the metadata
+	scaled, err := LoadLoraAdapter(adapterPath, LoraOptions{Alpha: 2})
+	assert(t, err == nil && scaled.layers["head.weight"].scale == 0.5)
+}
+
+func TestMergeLoraModelFileErrors(t *testing.T) {
+	const nVocab, nEmbed, nLayer = 256, 64, 1
+	var random = rand.New(rand.NewSource(5))
+	var dir = t.TempDir()
+	var base = filepath.Join(dir, "base.bin")
+	assert(t, writeFixtureModel(base, nVocab, nEmbed, nLayer, newTinyModelTensors(6, nVocab, nEmbed, nLayer)) == nil)
+
+	var load = func(metadata map[string]string, tensors []fixtureTensor) (*LoraAdapter, error) {
+		var path = filepath.Join(dir, "adapter.safetensors")
+		if err := writeFixtureSafetensors(path, metadata, "F32", tensors); err != nil {
+			t.Fatal(err)
+		}
+		return LoadLoraAdapter(path, LoraOptions{})
+	}
+
+	var alpha = map[string]string{"lora_alpha": "16"}
+	var _, err = load(nil, newFixtureLoraPair(random, "blocks.0.att.key", "", 2, nEmbed, nEmbed))
+	assert(t, errors.Is(err, ErrInvalidLora), "an adapter without alpha should be rejected")
+
+	_, err = load(alpha, newFixtureLoraPair(random, "blocks.0.att.key", "", 2, nEmbed, nEmbed)[:1])
+	assert(t, errors.Is(err, ErrInvalidLora), "lora_A without lora_B should be rejected")
+
+	var out = filepath.Join(dir, "merged.bin")
+	adapter, err := load(alpha, newFixtureLoraPair(random, "blocks.0.att.key", "", 2, nEmbed, 32))
+	assert(t, err == nil)
+	assert(t, errors.Is(MergeLoraModelFile(base, out, adapter), ErrInvalidLora), "the shapes should match the weights")
+
+	adapter, err = load(alpha, newFixtureLoraPair(random, "blocks.5.att.key", "", 2, nEmbed, nEmbed))
+	assert(t, err == nil)
+	assert(t, errors.Is(MergeLoraModelFile(base, out, adapter), ErrInvalidLora), "every weight should be in the model")
+
+	var _, statErr = os.Stat(out)
+	assert(t, os.IsNotExist(statErr), "a failed merge should not leave a file")
+
+	_, err = load(alpha, newFixtureLoraPair(random, "blocks.0.att.key", "", 0, nEmbed, nEmbed))
+	assert(t, errors.Is(err, ErrInvalidLora), "a rank of 0 should be rejected")
+
+	var negative = fixtureTensor{name: "blocks.0.att.key.lora_A", shape: []int{-2, -2}, data: make([]float32, 4)}
+	_, err = load(alpha, []fixtureTensor{negative})
+	assert(t, errors.Is(err, ErrInvalidSafetensors), "a negative dimension should be rejected")
+
+	var truncated = filepath.Join(dir, "truncated.safetensors")
+	assert(t, os.WriteFile(truncated, []byte{200, 0, 0, 0, 0, 0, 0, 0, '{'}, 0644) == nil)
+	_, err = LoadLoraAdapter(truncated, LoraOptions{Alpha: 1})
+	assert(t, errors.Is(err, ErrInvalidSafetensors), "a truncated file should be rejected")
+}
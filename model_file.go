@@ -0,0 +1,355 @@
+package rwkv
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+/********************************************************************
+created:    2026-10-18
+author:     lixianmin
+
+Copyright (C) - All Rights Reserved
+*********************************************************************/
+
+// the rwkv.cpp model file is a header followed by tensors, every tensor is a header, its name and its data
+const (
+	rwkvFileMagic    = 0x67676d66
+	rwkvFileVersion0 = 100
+	rwkvFileVersion1 = 101 // the quantized formats changed in this version, the older ones can no longer be loaded
+
+	maxTensorNameLength = 1 << 10
+)
+
+// rwkvType is the data type of a tensor, the values are the ones of the rwkv.cpp file format
+type rwkvType int32
+
+const (
+	rwkvTypeFP32 rwkvType = 0
+	rwkvTypeFP16 rwkvType = 1
+	rwkvTypeQ4_0 rwkvType = 2
+	rwkvTypeQ4_1 rwkvType = 3
+	rwkvTypeQ5_0 rwkvType = 7 // 4, 5 and 6 are removed formats
+	rwkvTypeQ5_1 rwkvType = 8
+	rwkvTypeQ8_0 rwkvType = 9
+)
+
+var rwkvTypeNames = map[rwkvType]string{
+	rwkvTypeFP32: "FP32",
+	rwkvTypeFP16: "FP16",
+	rwkvTypeQ4_0: "Q4_0",
+	rwkvTypeQ4_1: "Q4_1",
+	rwkvTypeQ5_0: "Q5_0",
+	rwkvTypeQ5_1: "Q5_1",
+	rwkvTypeQ8_0: "Q8_0",
+}
+
+func (t rwkvType) String() string {
+	if name, ok := rwkvTypeNames[t]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("rwkvType(%d)", int32(t))
+}
+
+func (t rwkvType) isQuantized() bool {
+	return t != rwkvTypeFP32 && t != rwkvTypeFP16
+}
+
+// dataSize returns the byte count of elements of type t, quantized types pack 32 elements into a block
+func (t rwkvType) dataSize(elements int) (int, error) {
+	var blockBytes int
+	switch t {
+	case rwkvTypeFP32:
+		return elements * 4, nil
+	case rwkvTypeFP16:
+		return elements * 2, nil
+	case rwkvTypeQ4_0:
+		blockBytes = 18
+	case rwkvTypeQ4_1:
+		blockBytes = 20
+	case rwkvTypeQ5_0:
+		blockBytes = 22
+	case rwkvTypeQ5_1:
+		blockBytes = 24
+	case rwkvTypeQ8_0:
+		blockBytes = 34
+	default:
+		return 0, fmt.Errorf("%w: the data type %v is not supported", ErrInvalidModelFile, t)
+	}
+
+	if elements%quantBlockSize != 0 {
+		return 0, fmt.Errorf("%w: %d elements can not be split into blocks of %d", ErrInvalidModelFile, elements, quantBlockSize)
+	}
+
+	return elements / quantBlockSize * blockBytes, nil
+}
+
+const quantBlockSize = 32
+
+var ErrInvalidModelFile = errors.New("the model file is invalid")
+
+type rwkvFileHeader struct {
+	Magic    uint32
+	Version  uint32
+	NVocab   uint32
+	NEmbed   uint32
+	NLayer   uint32
+	DataType rwkvType
+}
+
+// rwkvTensor is a tensor of 1 to 3 dimensions, Width is the length of a row, Height is the count of rows, and Depth
+// is the count of matrices, which only RWKV v6 has
+type rwkvTensor struct {
+	Name     string
+	DataType rwkvType
+	DimCount int
+	Width    int
+	Height   int
+	Depth    int
+	Data     []byte
+}
+
+func (my *rwkvTensor) elements() int {
+	return my.Width * my.Height * my.Depth
+}
+
+func readRwkvFileHeader(reader io.Reader) (rwkvFileHeader, error) {
+	var header rwkvFileHeader
+	if err := binary.Read(reader, binary.LittleEndian, &header); err != nil {
+		return header, err
+	}
+
+	if header.Magic != rwkvFileMagic {
+		return header, fmt.Errorf("%w: the magic number is %#x", ErrInvalidModelFile, header.Magic)
+	}
+
+	if header.Version != rwkvFileVersion0 && header.Version != rwkvFileVersion1 {
+		return header, fmt.Errorf("%w: the version %d is not supported", ErrInvalidModelFile, header.Version)
+	}
+
+	return header, nil
+}
+
+func writeRwkvFileHeader(writer io.Writer, header rwkvFileHeader) error {
+	return binary.Write(writer, binary.LittleEndian, &header)
+}
+
+// readRwkvTensor reads the next tensor, and returns io.EOF when there is no more
+func readRwkvTensor(reader io.Reader, version uint32) (rwkvTensor, error) {
+	var tensor rwkvTensor
+	var head [3]int32
+	if err := binary.Read(reader, binary.LittleEndian, &head); err != nil {
+		return tensor, err
+	}
+
+	var dimCount, keyLength = head[0], head[1]
+	tensor.DataType = rwkvType(head[2])
+	if dimCount < 1 || dimCount > 3 {
+		return tensor, fmt.Errorf("%w: a tensor has %d dimensions", ErrInvalidModelFile, dimCount)
+	}
+
+	if keyLength <= 0 || keyLength > maxTensorNameLength {
+		return tensor, fmt.Errorf("%w: a tensor name has %d bytes", ErrInvalidModelFile, keyLength)
+	}
+
+	var dims = make([]int32, dimCount)
+	if err := binary.Read(reader, binary.LittleEndian, dims); err != nil {
+		return tensor, unexpectedEOF(err)
+	}
+
+	tensor.DimCount = int(dimCount)
+	tensor.Width, tensor.Height, tensor.Depth = int(dims[0]), 1, 1
+	if dimCount >= 2 {
+		tensor.Height = int(dims[1])
+	}
+
+	if dimCount == 3 {
+		tensor.Depth = int(dims[2])
+	}
+
+	if tensor.Width <= 0 || tensor.Height <= 0 || tensor.Depth <= 0 {
+		return tensor, fmt.Errorf("%w: a tensor has the shape %dx%dx%d", ErrInvalidModelFile, tensor.Depth, tensor.Height, tensor.Width)
+	}
+
+	var name = make([]byte, keyLength)
+	if _, err := io.ReadFull(reader, name); err != nil {
+		return tensor, unexpectedEOF(err)
+	}
+	tensor.Name = string(name)
+
+	if tensor.DataType.isQuantized() && version != rwkvFileVersion1 {
+		return tensor, fmt.Errorf("%w: %s is quantized by an old version of rwkv.cpp", ErrInvalidModelFile, tensor.Name)
+	}
+
+	if tensor.DataType.isQuantized() && tensor.Width%quantBlockSize != 0 {
+		return tensor, fmt.Errorf("%w: the rows of %s can not be split into blocks", ErrInvalidModelFile, tensor.Name)
+	}
+
+	var size, err = tensor.DataType.dataSize(tensor.elements())
+	if err != nil {
+		return tensor, fmt.Errorf("%s: %w", tensor.Name, err)
+	}
+
+	tensor.Data = make([]byte, size)
+	if _, err = io.ReadFull(reader, tensor.Data); err != nil {
+		return tensor, unexpectedEOF(err)
+	}
+
+	return tensor, nil
+}
+
+func writeRwkvTensor(writer io.Writer, tensor *rwkvTensor) error {
+	var head = []int32{int32(tensor.DimCount), int32(len(tensor.Name)), int32(tensor.DataType), int32(tensor.Width)}
+	if tensor.DimCount >= 2 {
+		head = append(head, int32(tensor.Height))
+	}
+
+	if tensor.DimCount == 3 {
+		head = append(head, int32(tensor.Depth))
+	}
+
+	if err := binary.Write(writer, binary.LittleEndian, head); err != nil {
+		return err
+	}
+
+	if _, err := io.WriteString(writer, tensor.Name); err != nil {
+		return err
+	}
+
+	var _, err = writer.Write(tensor.Data)
+	return err
+}
+
+// readRwkvModelFile reads the header and all tensors of the model file of path
+func readRwkvModelFile(path string) (rwkvFileHeader, []rwkvTensor, error) {
+	var file, err = os.Open(path)
+	if err != nil {
+		return rwkvFileHeader{}, nil, err
+	}
+	defer file.Close()
+
+	var reader = bufio.NewReaderSize(file, 1<<20)
+	header, err := readRwkvFileHeader(reader)
+	if err != nil {
+		return header, nil, unexpectedEOF(err)
+	}
+
+	var tensors []rwkvTensor
+	for {
+		var tensor, err = readRwkvTensor(reader, header.Version)
+		if err == io.EOF {
+			return header, tensors, nil
+		}
+
+		if err != nil {
+			return header, nil, err
+		}
+
+		tensors = append(tensors, tensor)
+	}
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
+
+// float32s decodes the data of a tensor, a quantized one is dequantized
+func (my *rwkvTensor) float32s() ([]float32, error) {
+	var data = make([]float32, my.elements())
+	switch my.DataType {
+	case rwkvTypeFP32:
+		for i := range data {
+			data[i] = math.Float32frombits(binary.LittleEndian.Uint32(my.Data[i*4:]))
+		}
+	case rwkvTypeFP16:
+		for i := range data {
+			data[i] = halfToFloat32(binary.LittleEndian.Uint16(my.Data[i*2:]))
+		}
+	default:
+		var format, ok = quantFormats[my.DataType]
+		if !ok || my.elements()%quantBlockSize != 0 {
+			return nil, fmt.Errorf("%w: %s is %v, which can not be decoded", ErrInvalidModelFile, my.Name, my.DataType)
+		}
+		dequantizeBlocks(format, my.Data, data)
+	}
+
+	return data, nil
+}
+
+// halfToFloat32 converts an IEEE 754 half precision number
+func halfToFloat32(h uint16) float32 {
+	var sign = uint32(h>>15) << 31
+	var exponent = uint32(h>>10) & 0x1f
+	var mantissa = uint32(h) & 0x3ff
+
+	switch {
+	case exponent == 0x1f: // inf and nan
+		return math.Float32frombits(sign | 0x7f800000 | mantissa<<13)
+	case exponent != 0:
+		return math.Float32frombits(sign | (exponent+112)<<23 | mantissa<<13)
+	case mantissa == 0:
+		return math.Float32frombits(sign)
+	default: // subnormal
+		exponent = 113
+		for mantissa&0x400 == 0 {
+			mantissa <<= 1
+			exponent--
+		}
+		return math.Float32frombits(sign | exponent<<23 | (mantissa&0x3ff)<<13)
+	}
+}
+
+// float32ToHalf converts f to IEEE 754 half precision, rounding to the nearest even like the F16C instructions
+func float32ToHalf(f float32) uint16 {
+	var bits = math.Float32bits(f)
+	var sign = uint16(bits>>16) & 0x8000
+	var exponent = int32(bits>>23) & 0xff
+	var mantissa = bits & 0x7fffff
+
+	if exponent == 0xff { // inf and nan
+		if mantissa != 0 {
+			return sign | 0x7e00
+		}
+		return sign | 0x7c00
+	}
+
+	exponent -= 127 - 15
+	if exponent >= 0x1f {
+		return sign | 0x7c00
+	}
+
+	if exponent <= 0 {
+		if exponent < -10 {
+			return sign
+		}
+
+		// subnormal, the implicit leading bit becomes explicit
+		mantissa |= 0x800000
+		var shift = uint32(14 - exponent)
+		var half = mantissa >> shift
+		var rest = mantissa & (1<<shift - 1)
+		var midpoint = uint32(1) << (shift - 1)
+		if rest > midpoint || rest == midpoint && half&1 == 1 {
+			half++
+		}
+		return sign | uint16(half)
+	}
+
+	var half = uint32(exponent)<<10 | mantissa>>13
+	var rest = mantissa & 0x1fff
+	if rest > 0x1000 || rest == 0x1000 && half&1 == 1 {
+		half++ // a carry into the exponent is still right, up to inf
+	}
+
+	return sign | uint16(half)
+}
@@ -0,0 +1,186 @@
+package rwkv
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+/********************************************************************
+created:    2026-10-18
+author:     lixianmin
+
+Copyright (C) - All Rights Reserved
+*********************************************************************/
+
+type fixtureTensor struct {
+	name  string
+	shape []int // PyTorch order, rows first
+	data  []float32
+}
+
+// newTinyModelTensors creates a randomly initialized RWKV-4 model, with the same processing as convert_pytorch_to_ggml.py
+func newTinyModelTensors(seed int64, nVocab, nEmbed, nLayer int) []fixtureTensor {
+	var random = rand.New(rand.NewSource(seed))
+	var nFfn = 4 * nEmbed
+
+	var uniform = func(n int, low, high float32) []float32 {
+		var data = make([]float32, n)
+		for i := range data {
+			data[i] = low + (high-low)*random.Float32()
+		}
+		return data
+	}
+
+	var matrix = func(name string, rows, cols int) fixtureTensor {
+		var scale = float32(1 / math.Sqrt(float64(cols)))
+		return fixtureTensor{name: name, shape: []int{rows, cols}, data: uniform(rows*cols, -scale, scale)}
+	}
+
+	var vector = func(name string, low, high float32) fixtureTensor {
+		return fixtureTensor{name: name, shape: []int{nEmbed}, data: uniform(nEmbed, low, high)}
+	}
+
+	var tensors = []fixtureTensor{
+		matrix("emb.weight", nVocab, nEmbed),
+		vector("blocks.0.ln0.weight", 0.8, 1.2),
+		vector("blocks.0.ln0.bias", -0.1, 0.1),
+	}
+
+	for i := 0; i < nLayer; i++ {
+		var prefix = fmt.Sprintf("blocks.%d.", i)
+		var timeDecay = vector(prefix+"att.time_decay", -3, 1)
+		for k, v := range timeDecay.data {
+			timeDecay.data[k] = -float32(math.Exp(float64(v)))
+		}
+
+		tensors = append(tensors,
+			vector(prefix+"ln1.weight", 0.8, 1.2),
+			vector(prefix+"ln1.bias", -0.1, 0.1),
+			vector(prefix+"ln2.weight", 0.8, 1.2),
+			vector(prefix+"ln2.bias", -0.1, 0.1),
+			timeDecay,
+			vector(prefix+"att.time_first", -1, 1),
+			vector(prefix+"att.time_mix_k", 0, 1),
+			vector(prefix+"att.time_mix_v", 0, 1),
+			vector(prefix+"att.time_mix_r", 0, 1),
+			matrix(prefix+"att.key.weight", nEmbed, nEmbed),
+			matrix(prefix+"att.value.weight", nEmbed, nEmbed),
+			matrix(prefix+"att.receptance.weight", nEmbed, nEmbed),
+			matrix(prefix+"att.output.weight", nEmbed, nEmbed),
+			vector(prefix+"ffn.time_mix_k", 0, 1),
+			vector(prefix+"ffn.time_mix_r", 0, 1),
+			matrix(prefix+"ffn.key.weight", nFfn, nEmbed),
+			matrix(prefix+"ffn.receptance.weight", nEmbed, nEmbed),
+			matrix(prefix+"ffn.value.weight", nEmbed, nFfn),
+		)
+	}
+
+	tensors = append(tensors,
+		vector("ln_out.weight", 0.8, 1.2),
+		vector("ln_out.bias", -0.1, 0.1),
+		matrix("head.weight", nVocab, nEmbed),
+	)
+
+	return tensors
+}
+
+// writeFixtureModel writes tensors in the rwkv.cpp FP32 file format, see convert_pytorch_to_ggml.py
+func writeFixtureModel(path string, nVocab, nEmbed, nLayer int, tensors []fixtureTensor) error {
+	return writeFixtureModelType(path, rwkvTypeFP32, nVocab, nEmbed, nLayer, tensors)
+}
+
+// writeFixtureModelType writes the matrices in dataType, FP32 or FP16, and the vectors in FP32 like the converter
+func writeFixtureModelType(path string, dataType rwkvType, nVocab, nEmbed, nLayer int, tensors []fixtureTensor) error {
+	var file, err = os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var writer = bufio.NewWriter(file)
+	var header = rwkvFileHeader{Magic: rwkvFileMagic, Version: rwkvFileVersion1, NVocab: uint32(nVocab),
+		NEmbed: uint32(nEmbed), NLayer: uint32(nLayer), DataType: dataType}
+	if err = writeRwkvFileHeader(writer, header); err != nil {
+		return err
+	}
+
+	for _, tensor := range tensors {
+		var item = newFixtureRwkvTensor(tensor, dataType)
+		if err = writeRwkvTensor(writer, &item); err != nil {
+			return err
+		}
+	}
+
+	return writer.Flush()
+}
+
+func newFixtureRwkvTensor(tensor fixtureTensor, dataType rwkvType) rwkvTensor {
+	var dims = len(tensor.shape)
+	var item = rwkvTensor{Name: tensor.name, DataType: rwkvTypeFP32, DimCount: dims, Width: tensor.shape[dims-1], Height: 1, Depth: 1}
+	if dims >= 2 {
+		item.Height = tensor.shape[dims-2]
+	}
+
+	if dims == 3 {
+		item.Depth = tensor.shape[0]
+	}
+
+	// the converter keeps the vectors and the small matrices of time mixing in FP32
+	if dims >= 2 && !strings.Contains(tensor.name, ".time_") {
+		item.DataType = dataType
+	}
+
+	if item.DataType == rwkvTypeFP16 {
+		item.Data = make([]byte, 2*len(tensor.data))
+		for i, v := range tensor.data {
+			binary.LittleEndian.PutUint16(item.Data[2*i:], float32ToHalf(v))
+		}
+	} else {
+		item.Data = make([]byte, 4*len(tensor.data))
+		for i, v := range tensor.data {
+			binary.LittleEndian.PutUint32(item.Data[4*i:], math.Float32bits(v))
+		}
+	}
+
+	return item
+}
+
+// skipUnsupported skips a test of rwkv.cpp or of the 20B tokenizer on the platforms they do not work on
+func skipUnsupported(t testing.TB, err error) {
+	if errors.Is(err, ErrBackendUnsupported) || errors.Is(err, ErrNormalTokenizerUnsupported) {
+		t.Skip(err)
+	}
+}
+
+// newTinyChatModel loads a tiny random World-vocabulary model with the C backend
+func newTinyChatModel(t testing.TB) *ChatModel {
+	const nVocab, nEmbed, nLayer = worldVocabSize, 32, 2
+	var path = filepath.Join(t.TempDir(), "tiny-world-fp32.bin")
+	if err := writeFixtureModel(path, nVocab, nEmbed, nLayer, newTinyModelTensors(1, nVocab, nEmbed, nLayer)); err != nil {
+		t.Fatal(err)
+	}
+
+	var model, err = NewChatModel(path, RwkvOptions{
+		MaxTokens:     16,
+		StopString:    "\n\n",
+		Temperature:   1.0,
+		TopP:          0.8,
+		CpuThreads:    1,
+		TokenizerType: Auto,
+	})
+	skipUnsupported(t, err)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { _ = model.Close() })
+	return model
+}
@@ -0,0 +1,168 @@
+package rwkv
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+/********************************************************************
+created:    2026-10-18
+author:     lixianmin
+
+Copyright (C) - All Rights Reserved
+*********************************************************************/
+
+var (
+	ErrPoolClosed  = errors.New("the model pool is closed")
+	ErrPoolTimeout = errors.New("timeout waiting for an idle model context")
+)
+
+type ModelPoolOptions struct {
+	Size           int           // count of contexts cloned from the model, must be positive
+	CpuThreads     uint32        // threads of every cloned context, 0 uses RwkvOptions.CpuThreads of the model
+	AcquireTimeout time.Duration // max waiting time of Acquire, 0 waits until the context.Context is done
+}
+
+// ModelPool clones contexts from one loaded model with RwkvCloneContext, so that concurrent goroutines can eval
+// without loading the weights again. Every model handed out by Acquire must be given back by Release.
+type ModelPool struct {
+	source  *ChatModel
+	options ModelPoolOptions
+	models  []*ChatModel
+	idle    chan *ChatModel
+	done    chan struct{}
+	lock    sync.Mutex
+	closed  bool
+	inUse   map[*ChatModel]struct{}
+}
+
+func NewModelPool(model *ChatModel, options ModelPoolOptions) (*ModelPool, error) {
+	if options.Size <= 0 {
+		return nil, errors.New("the size of model pool must be positive")
+	}
+
+	var pool = &ModelPool{
+		source:  model,
+		options: options,
+		models:  make([]*ChatModel, 0, options.Size),
+		idle:    make(chan *ChatModel, options.Size),
+		done:    make(chan struct{}),
+		inUse:   make(map[*ChatModel]struct{}, options.Size),
+	}
+
+	for i := 0; i < options.Size; i++ {
+		var cloned, err = model.clone(options.CpuThreads)
+		if err != nil {
+			for _, m := range pool.models {
+				_ = m.Close()
+			}
+			return nil, err
+		}
+
+		pool.models = append(pool.models, cloned)
+		pool.idle <- cloned
+	}
+
+	return pool, nil
+}
+
+// Acquire waits for an idle model, until ctx is done, AcquireTimeout passes or the pool is closed
+func (my *ModelPool) Acquire(ctx context.Context) (*ChatModel, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	var timeout <-chan time.Time
+	if my.options.AcquireTimeout > 0 {
+		var timer = time.NewTimer(my.options.AcquireTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case model := <-my.idle:
+		my.lock.Lock()
+		defer my.lock.Unlock()
+
+		// Close may run between receiving and locking
+		if my.closed {
+			_ = model.Close()
+			return nil, ErrPoolClosed
+		}
+
+		my.inUse[model] = struct{}{}
+		return model, nil
+	case <-my.done:
+		return nil, ErrPoolClosed
+	case <-timeout:
+		return nil, ErrPoolTimeout
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Release gives back a model handed out by Acquire
+func (my *ModelPool) Release(model *ChatModel) {
+	my.lock.Lock()
+	defer my.lock.Unlock()
+
+	if _, ok := my.inUse[model]; !ok {
+		return
+	}
+
+	delete(my.inUse, model)
+	if my.closed {
+		_ = model.Close()
+		return
+	}
+
+	my.idle <- model
+}
+
+// Do runs handler with an acquired model, and releases it when handler returns
+func (my *ModelPool) Do(ctx context.Context, handler func(model *ChatModel) error) error {
+	var model, err = my.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer my.Release(model)
+
+	return handler(model)
+}
+
+// Size returns the count of contexts in the pool
+func (my *ModelPool) Size() int {
+	return len(my.models)
+}
+
+// Idle returns the count of contexts which are not handed out
+func (my *ModelPool) Idle() int {
+	return len(my.idle)
+}
+
+// Close frees the idle contexts at once, and the others when they are released. The source model is not closed.
+func (my *ModelPool) Close() error {
+	my.lock.Lock()
+	defer my.lock.Unlock()
+
+	if my.closed {
+		return nil
+	}
+
+	my.closed = true
+	close(my.done)
+
+	var err error
+	for {
+		select {
+		case model := <-my.idle:
+			if err2 := model.Close(); err2 != nil && err == nil {
+				err = err2
+			}
+		default:
+			return err
+		}
+	}
+}
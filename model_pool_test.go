@@ -0,0 +1,62 @@
+package rwkv
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+/********************************************************************
+created:    2026-10-18
+author:     lixianmin
+
+Copyright (C) - All Rights Reserved
+*********************************************************************/
+
+func TestModelPool(t *testing.T) {
+	var model = newTinyChatModel(t)
+	var pool, err = NewModelPool(model, ModelPoolOptions{Size: 2, AcquireTimeout: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	var tokens = model.Encode("hello world")
+	var _, want = model.EvalSequence(tokens, nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var err = pool.Do(context.Background(), func(m *ChatModel) error {
+				var _, logits = m.EvalSequence(tokens, nil)
+				if !slices.Equal(logits, want) {
+					return errors.New("cloned context gives different logits")
+				}
+				return nil
+			})
+
+			if err != nil && !errors.Is(err, ErrPoolTimeout) {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	var m1, _ = pool.Acquire(context.Background())
+	var m2, _ = pool.Acquire(context.Background())
+	_, err = pool.Acquire(context.Background())
+	assert(t, errors.Is(err, ErrPoolTimeout), "the third acquire should time out")
+
+	pool.Release(m1)
+	pool.Release(m2)
+	assert(t, pool.Idle() == 2)
+
+	_ = pool.Close()
+	_, err = pool.Acquire(context.Background())
+	assert(t, errors.Is(err, ErrPoolClosed))
+}
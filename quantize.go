@@ -0,0 +1,430 @@
+package rwkv
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path"
+	"strings"
+	"text/tabwriter"
+)
+
+/********************************************************************
+created:    2026-10-18
+author:     lixianmin
+
+Copyright (C) - All Rights Reserved
+*********************************************************************/
+
+// QuantizeOptions tells QuantizeModelFile how to quantize a model
+type QuantizeOptions struct {
+	// Format is the format of the matrices no rule of Plan matches, and the data type in the file header
+	Format QuantizedFormat
+
+	// QuantizeEmbeddings quantizes emb.weight and head.weight to Format too. rwkv.cpp keeps them, since they take little
+	// space in big models but hurt the perplexity a lot when quantized.
+	QuantizeEmbeddings bool
+
+	// Plan overrides Format for the matrices it matches, such as head.weight at Q8_0 or blocks.0.* at FP16
+	Plan QuantizePlan
+}
+
+// QuantizeRule maps the matrices whose names match Pattern, a glob of path.Match, to Format
+type QuantizeRule struct {
+	Pattern string
+	Format  QuantizedFormat
+}
+
+// QuantizePlan picks the formats of the matrices by their names, the first matching rule wins
+type QuantizePlan []QuantizeRule
+
+// QuantizeReport tells what QuantizeModelFile has written
+type QuantizeReport struct {
+	Tensors     []QuantizeTensorReport
+	SourceBytes int64 // the size of the source file
+	OutputBytes int64 // the size of the output file
+}
+
+// QuantizeTensorReport is the result of a tensor, RMSE is the root mean square error of its values against the source
+type QuantizeTensorReport struct {
+	Name        string
+	From        QuantizedFormat
+	To          QuantizedFormat
+	SourceBytes int
+	OutputBytes int
+	RMSE        float64
+}
+
+var (
+	ErrInvalidQuantizedFormat = errors.New("the quantized format is invalid")
+	ErrInvalidQuantizePlan    = errors.New("the quantize plan is invalid")
+)
+
+// dataType returns the rwkvType of format
+func (format QuantizedFormat) dataType() (rwkvType, error) {
+	for dataType, name := range rwkvTypeNames {
+		if name == string(format) {
+			return dataType, nil
+		}
+	}
+
+	return 0, fmt.Errorf("%w: %q", ErrInvalidQuantizedFormat, string(format))
+}
+
+// ParseQuantizePlan parses comma separated pattern=format rules, such as "head.weight=Q8_0,blocks.0.*=FP16"
+func ParseQuantizePlan(text string) (QuantizePlan, error) {
+	var plan QuantizePlan
+	for _, item := range strings.Split(text, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+
+		var pattern, format, ok = strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("%w: %q is not pattern=format", ErrInvalidQuantizePlan, item)
+		}
+
+		var upper = strings.ToUpper(strings.TrimSpace(format))
+		plan = append(plan, QuantizeRule{Pattern: strings.TrimSpace(pattern), Format: QuantizedFormat(upper)})
+	}
+
+	if err := plan.validate(); err != nil {
+		return nil, err
+	}
+
+	return plan, nil
+}
+
+func (my QuantizePlan) validate() error {
+	for _, rule := range my {
+		if _, err := path.Match(rule.Pattern, ""); err != nil || rule.Pattern == "" {
+			return fmt.Errorf("%w: the pattern %q is malformed", ErrInvalidQuantizePlan, rule.Pattern)
+		}
+
+		if _, err := rule.Format.dataType(); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidQuantizePlan, err)
+		}
+	}
+
+	return nil
+}
+
+// match returns the format of the first rule matching name
+func (my QuantizePlan) match(name string) (QuantizedFormat, bool) {
+	for _, rule := range my {
+		if ok, _ := path.Match(rule.Pattern, name); ok {
+			return rule.Format, true
+		}
+	}
+
+	return "", false
+}
+
+// QuantizeModelFile quantizes the FP32 or FP16 model file in into out in pure Go, it needs neither the dynamic library
+// nor a loaded model. The 2-D matrices are converted by options while the vectors keep their data type, and without a
+// plan the output is byte by byte the same as the one of rwkv_quantize_model_file.
+func QuantizeModelFile(in string, out string, options QuantizeOptions) (QuantizeReport, error) {
+	var report QuantizeReport
+	var dataType, err = options.Format.dataType()
+	if err != nil {
+		return report, err
+	}
+
+	if !dataType.isQuantized() {
+		return report, fmt.Errorf("%w: %q is not quantized", ErrInvalidQuantizedFormat, string(options.Format))
+	}
+
+	if err = options.Plan.validate(); err != nil {
+		return report, err
+	}
+
+	source, err := os.Open(in)
+	if err != nil {
+		return report, err
+	}
+	defer source.Close()
+
+	var reader = bufio.NewReaderSize(source, 1<<20)
+	header, err := readRwkvFileHeader(reader)
+	if err != nil {
+		return report, unexpectedEOF(err)
+	}
+
+	if header.DataType.isQuantized() {
+		return report, fmt.Errorf("%w: %s is %v, but FP32 or FP16 is expected", ErrInvalidModelFile, in, header.DataType)
+	}
+
+	var version = header.Version
+	header.Version = rwkvFileVersion1
+	header.DataType = dataType
+
+	err = writeFileAtomically(out, func(writer io.Writer) error {
+		if err := writeRwkvFileHeader(writer, header); err != nil {
+			return err
+		}
+
+		for {
+			var tensor, err = readRwkvTensor(reader, version)
+			if err == io.EOF {
+				return nil
+			}
+
+			if err != nil {
+				return err
+			}
+
+			item, err := convertTensor(&tensor, options.targetType(&tensor, dataType))
+			if err != nil {
+				return err
+			}
+
+			if err = writeRwkvTensor(writer, &tensor); err != nil {
+				return err
+			}
+			report.Tensors = append(report.Tensors, item)
+		}
+	})
+
+	if err != nil {
+		return report, err
+	}
+
+	if info, err := source.Stat(); err == nil {
+		report.SourceBytes = info.Size()
+	}
+
+	if info, err := os.Stat(out); err == nil {
+		report.OutputBytes = info.Size()
+	}
+
+	return report, nil
+}
+
+// targetType returns the data type tensor is written in. Only the FP32 and FP16 matrices are converted, and a matrix
+// is kept when its rows can not be split into blocks.
+func (options *QuantizeOptions) targetType(tensor *rwkvTensor, dataType rwkvType) rwkvType {
+	if tensor.DataType.isQuantized() || tensor.DimCount != 2 {
+		return tensor.DataType
+	}
+
+	var target = tensor.DataType
+	if format, ok := options.Plan.match(tensor.Name); ok {
+		target, _ = format.dataType()
+	} else if options.QuantizeEmbeddings || tensor.Name != "emb.weight" && tensor.Name != "head.weight" {
+		target = dataType
+	}
+
+	if target.isQuantized() && tensor.Width%quantBlockSize != 0 {
+		return tensor.DataType
+	}
+
+	return target
+}
+
+// convertTensor encodes tensor in target, and measures the error of the new values
+func convertTensor(tensor *rwkvTensor, target rwkvType) (QuantizeTensorReport, error) {
+	var report = QuantizeTensorReport{Name: tensor.Name, From: QuantizedFormat(tensor.DataType.String()),
+		To: QuantizedFormat(target.String()), SourceBytes: len(tensor.Data), OutputBytes: len(tensor.Data)}
+	if target == tensor.DataType {
+		return report, nil
+	}
+
+	var values, err = tensor.float32s()
+	if err != nil {
+		return report, err
+	}
+
+	tensor.Data = encodeTensor(target, values)
+	tensor.DataType = target
+	report.OutputBytes = len(tensor.Data)
+
+	decoded, err := tensor.float32s()
+	if err != nil {
+		return report, err
+	}
+
+	var sum float64
+	for i, v := range values {
+		var diff = float64(decoded[i]) - float64(v)
+		sum += diff * diff
+	}
+
+	report.RMSE = math.Sqrt(sum / float64(max(1, len(values))))
+	return report, nil
+}
+
+// encodeTensor encodes values in dataType
+func encodeTensor(dataType rwkvType, values []float32) []byte {
+	switch dataType {
+	case rwkvTypeFP32:
+		var data = make([]byte, 4*len(values))
+		for i, v := range values {
+			binary.LittleEndian.PutUint32(data[4*i:], math.Float32bits(v))
+		}
+		return data
+	case rwkvTypeFP16:
+		var data = make([]byte, 2*len(values))
+		for i, v := range values {
+			binary.LittleEndian.PutUint16(data[2*i:], float32ToHalf(v))
+		}
+		return data
+	default:
+		return quantizeBlocks(dataType, values)
+	}
+}
+
+// String formats the report as a table of the tensors, followed by the sizes of the files
+func (my *QuantizeReport) String() string {
+	var builder strings.Builder
+	var writer = tabwriter.NewWriter(&builder, 0, 8, 2, ' ', 0)
+	_, _ = fmt.Fprintln(writer, "tensor\tformat\tsize\tRMSE")
+	for _, item := range my.Tensors {
+		var format = string(item.To)
+		if item.From != item.To {
+			format = string(item.From) + " -> " + string(item.To)
+		}
+		var size = fmt.Sprintf("%d -> %d", item.SourceBytes, item.OutputBytes)
+		_, _ = fmt.Fprintf(writer, "%s\t%s\t%s\t%.3g\n", item.Name, format, size, item.RMSE)
+	}
+	_ = writer.Flush()
+
+	var ratio = float64(my.SourceBytes) / float64(max(1, my.OutputBytes))
+	_, _ = fmt.Fprintf(&builder, "file size: %d -> %d bytes, %.2fx smaller\n", my.SourceBytes, my.OutputBytes, ratio)
+	return builder.String()
+}
+
+// writeFileAtomically writes path by write through a temporary file, so that path is either complete or untouched
+func writeFileAtomically(path string, write func(writer io.Writer) error) error {
+	var temp = path + ".tmp"
+	var file, err = os.Create(temp)
+	if err != nil {
+		return err
+	}
+
+	var writer = bufio.NewWriterSize(file, 1<<20)
+	if err = write(writer); err == nil {
+		err = writer.Flush()
+	}
+
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		_ = os.Remove(temp)
+		return err
+	}
+
+	return os.Rename(temp, path)
+}
+
+// quantizeBlocks encodes x into the blocks of dataType, the same as ggml's quantize_row reference functions. rwkv.cpp
+// is built with FMA, which fuses x*id+offset into one rounding, so the quants do that too.
+func quantizeBlocks(dataType rwkvType, x []float32) []byte {
+	var format = quantFormats[dataType]
+	var data = make([]byte, len(x)/quantBlockSize*format.blockBytes)
+	for b := 0; b < len(x)/quantBlockSize; b++ {
+		var block = data[b*format.blockBytes : (b+1)*format.blockBytes]
+		var xs = x[b*quantBlockSize : (b+1)*quantBlockSize]
+		switch {
+		case format.bits == 8:
+			quantizeBlockQ8(block, xs)
+		case format.minOffset >= 0:
+			quantizeBlockWithMin(format, block, xs)
+		default:
+			quantizeBlockSymmetric(format, block, xs)
+		}
+	}
+
+	return data
+}
+
+// quantizeBlockSymmetric is quantize_row_q4_0_reference and quantize_row_q5_0_reference, the element of the largest
+// magnitude becomes -2^(bits-1)
+func quantizeBlockSymmetric(format *quantFormat, block []byte, xs []float32) {
+	var amax, peak float32
+	for _, v := range xs {
+		if abs := float32(math.Abs(float64(v))); amax < abs {
+			amax, peak = abs, v
+		}
+	}
+
+	var levels = 1 << format.bits
+	var d = peak / -float32(levels/2)
+	var id float32
+	if d != 0 {
+		id = 1 / d
+	}
+
+	binary.LittleEndian.PutUint16(block, float32ToHalf(d))
+	var offset = float32(levels/2) + 0.5
+	packQuants(format, block, func(v float32) int {
+		return min(levels-1, int(int8(fma32(v, id, offset))))
+	}, xs)
+}
+
+// quantizeBlockWithMin is quantize_row_q4_1_reference and quantize_row_q5_1_reference, the minimum and the maximum
+// become 0 and 2^bits-1
+func quantizeBlockWithMin(format *quantFormat, block []byte, xs []float32) {
+	var low, high = float32(math.MaxFloat32), float32(-math.MaxFloat32)
+	for _, v := range xs {
+		low, high = min(low, v), max(high, v)
+	}
+
+	var levels = 1 << format.bits
+	var d = (high - low) / float32(levels-1)
+	var id float32
+	if d != 0 {
+		id = 1 / d
+	}
+
+	binary.LittleEndian.PutUint16(block, float32ToHalf(d))
+	binary.LittleEndian.PutUint16(block[format.minOffset:], float32ToHalf(low))
+	packQuants(format, block, func(v float32) int {
+		return min(levels-1, int(int8(fma32(v-low, id, 0.5))))
+	}, xs)
+}
+
+// packQuants writes the quants of xs into block, the elements j and j+16 share the byte j, and their 5th bits go to
+// the bits j and j+16 of qh
+func packQuants(format *quantFormat, block []byte, quant func(v float32) int, xs []float32) {
+	var qs = block[format.quantOffset:]
+	var qh uint32
+	for j := 0; j < quantBlockSize/2; j++ {
+		var q0, q1 = quant(xs[j]), quant(xs[j+quantBlockSize/2])
+		qs[j] = byte(q0&0x0f | (q1&0x0f)<<4)
+		qh |= uint32(q0>>4&1)<<j | uint32(q1>>4&1)<<(j+quantBlockSize/2)
+	}
+
+	if format.highOffset >= 0 {
+		binary.LittleEndian.PutUint32(block[format.highOffset:], qh)
+	}
+}
+
+// fma32 returns x*y+z rounded once to float32
+func fma32(x, y, z float32) float32 {
+	return float32(math.FMA(float64(x), float64(y), float64(z)))
+}
+
+// quantizeBlockQ8 is quantize_row_q8_0_reference, the largest magnitude becomes 127
+func quantizeBlockQ8(block []byte, xs []float32) {
+	var amax float32
+	for _, v := range xs {
+		amax = max(amax, float32(math.Abs(float64(v))))
+	}
+
+	var d = amax / 127
+	var id float32
+	if d != 0 {
+		id = 1 / d
+	}
+
+	binary.LittleEndian.PutUint16(block, float32ToHalf(d))
+	for j, v := range xs {
+		block[2+j] = byte(int8(math.Round(float64(float32(v * id)))))
+	}
+}
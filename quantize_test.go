@@ -0,0 +1,170 @@
+package rwkv
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+/********************************************************************
+created:    2026-10-18
+author:     lixianmin
+
+Copyright (C) - All Rights Reserved
+*********************************************************************/
+
+// newQuantizeFixture writes a tiny model of dataType, with a few blocks that are all zeros, constant or have ties
+func newQuantizeFixture(t *testing.T, dataType rwkvType) string {
+	const nVocab, nEmbed, nLayer = 256, 64, 2
+	var tensors = newTinyModelTensors(9, nVocab, nEmbed, nLayer)
+	for _, tensor := range tensors {
+		if tensor.name == "blocks.0.att.key.weight" {
+			for j := 0; j < quantBlockSize; j++ {
+				tensor.data[j] = 0
+				tensor.data[quantBlockSize+j] = 0.25
+				tensor.data[2*quantBlockSize+j] = float32(j%5-2) / 8
+			}
+		}
+	}
+
+	var path = filepath.Join(t.TempDir(), "tiny-"+dataType.String()+".bin")
+	if err := writeFixtureModelType(path, dataType, nVocab, nEmbed, nLayer, tensors); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestQuantizeModelFileMatchesC(t *testing.T) {
+	var file, err = dumpRwkvLibrary(false)
+	skipUnsupported(t, err)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cBackend, err := NewCRwkv(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, dataType := range []rwkvType{rwkvTypeFP32, rwkvTypeFP16} {
+		var source = newQuantizeFixture(t, dataType)
+		for _, format := range []QuantizedFormat{Q4_0, Q4_1, Q5_0, Q5_1, Q8_0} {
+			t.Run(dataType.String()+"/"+string(format), func(t *testing.T) {
+				var dir = t.TempDir()
+				var cPath, goPath = filepath.Join(dir, "c.bin"), filepath.Join(dir, "go.bin")
+				if err := cBackend.RwkvQuantizeModelFile(&RwkvCtx{}, source, cPath, format); err != nil {
+					t.Fatal(err)
+				}
+
+				if _, err := QuantizeModelFile(source, goPath, QuantizeOptions{Format: format}); err != nil {
+					t.Fatal(err)
+				}
+
+				var cData, _ = os.ReadFile(cPath)
+				var goData, _ = os.ReadFile(goPath)
+				assert(t, len(cData) > 0 && bytes.Equal(cData, goData), "the Go quantizer should write the same bytes as C")
+			})
+		}
+	}
+}
+
+func TestQuantizeModelFile(t *testing.T) {
+	var source = newQuantizeFixture(t, rwkvTypeFP16)
+	var dir = t.TempDir()
+
+	var path = filepath.Join(dir, "tiny-Q5_1.bin")
+	var _, err = QuantizeModelFile(source, path, QuantizeOptions{Format: Q5_1, QuantizeEmbeddings: true})
+	assert(t, err == nil)
+
+	header, tensors, err := readRwkvModelFile(path)
+	assert(t, err == nil)
+	assert(t, header.Version == rwkvFileVersion1 && header.DataType == rwkvTypeQ5_1)
+	for _, tensor := range tensors {
+		var quantized = tensor.DimCount == 2 && tensor.DataType == rwkvTypeQ5_1
+		var vector = tensor.DimCount == 1 && tensor.DataType == rwkvTypeFP32
+		assert(t, quantized || vector, "the matrices and the embeddings should be quantized, the vectors kept")
+	}
+
+	var backend = NewGoRwkv()
+	var ctx = backend.RwkvInitFromFile(path, 1)
+	assert(t, hasCtx(ctx) == nil, "the Go backend should load the quantized model")
+	_ = backend.RwkvFree(ctx)
+
+	var _, goErr = os.Stat(path + ".tmp")
+	assert(t, os.IsNotExist(goErr), "the temporary file should be renamed")
+
+	_, err = QuantizeModelFile(source, filepath.Join(dir, "bad.bin"), QuantizeOptions{Format: "Q3_K"})
+	assert(t, errors.Is(err, ErrInvalidQuantizedFormat), "an unknown format should be rejected")
+
+	_, err = QuantizeModelFile(path, filepath.Join(dir, "twice.bin"), QuantizeOptions{Format: Q8_0})
+	assert(t, errors.Is(err, ErrInvalidModelFile), "a quantized model should not be quantized again")
+
+	err = backend.RwkvQuantizeModelFile(nil, source, filepath.Join(dir, "tiny-Q8_0.bin"), Q8_0)
+	assert(t, err == nil, "the Go backend should quantize without a ctx")
+}
+
+func TestQuantizePlan(t *testing.T) {
+	var plan, err = ParseQuantizePlan("head.weight=q8_0, blocks.0.att.*=Q5_0,blocks.0.*=FP16,blocks.1.*=Q8_0")
+	assert(t, err == nil && len(plan) == 4 && plan[0] == QuantizeRule{Pattern: "head.weight", Format: Q8_0})
+
+	for _, text := range []string{"head.weight", "[=Q4_0", "=Q4_0", "head.weight=Q3_K"} {
+		var _, err = ParseQuantizePlan(text)
+		assert(t, errors.Is(err, ErrInvalidQuantizePlan), "a malformed plan should be rejected: "+text)
+	}
+
+	var source = newQuantizeFixture(t, rwkvTypeFP32)
+	var dir = t.TempDir()
+	var uniform, mixed = filepath.Join(dir, "tiny-Q4_0.bin"), filepath.Join(dir, "tiny-mixed.bin")
+	uniformReport, err := QuantizeModelFile(source, uniform, QuantizeOptions{Format: Q4_0})
+	assert(t, err == nil)
+	mixedReport, err := QuantizeModelFile(source, mixed, QuantizeOptions{Format: Q4_0, Plan: plan})
+	assert(t, err == nil)
+
+	var want = map[string]QuantizedFormat{
+		"emb.weight":                     FP32,
+		"head.weight":                    Q8_0,
+		"blocks.0.att.key.weight":        Q5_0,
+		"blocks.0.ffn.key.weight":        FP16,
+		"blocks.1.ffn.value.weight":      Q8_0,
+		"blocks.1.att.time_mix_k":        FP32,
+		"blocks.1.att.receptance.weight": Q8_0,
+	}
+
+	var _, tensors, _ = readRwkvModelFile(mixed)
+	assert(t, len(tensors) == len(mixedReport.Tensors) && len(tensors) == len(uniformReport.Tensors))
+	for i, tensor := range tensors {
+		var item, base = mixedReport.Tensors[i], uniformReport.Tensors[i]
+		assert(t, item.Name == tensor.Name && string(item.To) == tensor.DataType.String())
+		assert(t, item.OutputBytes == len(tensor.Data), "the report should tell the sizes of the tensors")
+		if format, ok := want[tensor.Name]; ok {
+			assert(t, item.To == format, tensor.Name+" should be "+string(format)+", but it is "+string(item.To))
+		}
+
+		if item.From == item.To {
+			assert(t, item.RMSE == 0, "a kept tensor should have no error")
+		} else if base.To == Q4_0 && item.To != Q4_0 {
+			assert(t, item.RMSE > 0 && item.RMSE < base.RMSE, "a finer format should have less error than Q4_0")
+		}
+	}
+
+	assert(t, mixedReport.OutputBytes > uniformReport.OutputBytes && uniformReport.OutputBytes < uniformReport.SourceBytes)
+	assert(t, strings.Contains(mixedReport.String(), "head.weight"), "the report should list the tensors")
+
+	// the mixed file loads on both backends, ggml rounding the inputs of the quantized head.weight to 8 bits too
+	var cBackend, cCtx, goBackend, goCtx = newBackendPair(t, mixed)
+	var stateLength = cBackend.RwkvGetStateLength(cCtx)
+	var cState, goState = make([]float32, stateLength), make([]float32, stateLength)
+	cBackend.RwkvInitState(cCtx, cState)
+	goBackend.RwkvInitState(goCtx, goState)
+
+	var cLogits, goLogits = make([]float32, 256), make([]float32, 256)
+	for _, token := range []uint32{1, 17, 255, 0, 42} {
+		assert(t, cBackend.RwkvEval(cCtx, token, cState, cState, cLogits) == nil)
+		assert(t, goBackend.RwkvEval(goCtx, token, goState, goState, goLogits) == nil)
+		assert(t, maxRelativeDiff(cLogits, goLogits) < 2e-2, "the backends should agree on a mixed file")
+	}
+}
@@ -0,0 +1,287 @@
+package rwkv
+
+import (
+	"context"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"text/template"
+	"unicode"
+)
+
+/********************************************************************
+created:    2026-10-18
+author:     lixianmin
+
+Copyright (C) - All Rights Reserved
+*********************************************************************/
+
+const (
+	defaultRetrievalLimit = 4
+
+	// DefaultRetrievalTemplate renders the retrieved documents, the data is struct{ Query string; Documents []Document }
+	DefaultRetrievalTemplate = `Answer with the help of the following documents.
+{{range .Documents}}[{{if .Title}}{{.Title}}{{else}}{{.ID}}{{end}}] {{.Content}}
+{{end}}`
+)
+
+// Document is a piece of text which can ground the answers of the model
+type Document struct {
+	ID      string
+	Title   string
+	Content string
+	Score   float64 // how well the document matches the query, set by the retriever
+}
+
+// Retriever finds the documents relevant to a query
+type Retriever interface {
+	// Retrieve returns at most limit documents, the most relevant first
+	Retrieve(ctx context.Context, query string, limit int) ([]Document, error)
+}
+
+// RetrievalOptions tells Chatbot and ChatModel.Chat to consult Retriever before generation. The documents are
+// rendered through Template, and injected as a system message before the user's message.
+type RetrievalOptions struct {
+	Retriever Retriever
+	Limit     int    // the most documents to retrieve, 0 for 4
+	MaxTokens int    // the token budget of the rendered documents, the ones which don't fit are left out. 0 for no budget
+	Template  string // a text/template, "" for DefaultRetrievalTemplate
+}
+
+type retrievalData struct {
+	Query     string
+	Documents []Document
+}
+
+// retrieval is the parsed RetrievalOptions
+type retrieval struct {
+	options  RetrievalOptions
+	template *template.Template
+}
+
+func newRetrieval(options RetrievalOptions) (*retrieval, error) {
+	var text = options.Template
+	if text == "" {
+		text = DefaultRetrievalTemplate
+	}
+
+	var tpl, err = template.New("retrieval").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+
+	if options.Limit <= 0 {
+		options.Limit = defaultRetrievalLimit
+	}
+
+	return &retrieval{options: options, template: tpl}, nil
+}
+
+// context returns the rendered documents relevant to query, or "" when there is none
+func (my *retrieval) context(ctx context.Context, model *ChatModel, query string) (string, error) {
+	var documents, err = my.options.Retriever.Retrieve(ctx, query, my.options.Limit)
+	if err != nil || len(documents) == 0 {
+		return "", err
+	}
+
+	var text = ""
+	for count := 1; count <= len(documents); count++ {
+		var sb strings.Builder
+		if err = my.template.Execute(&sb, retrievalData{Query: query, Documents: documents[:count]}); err != nil {
+			return "", err
+		}
+
+		if my.options.MaxTokens > 0 && len(model.Encode(sb.String())) > my.options.MaxTokens {
+			break
+		}
+
+		text = sb.String()
+	}
+
+	return text, nil
+}
+
+// withContext returns a copy of messages with the documents relevant to the last user message inserted before it
+func (my *retrieval) withContext(ctx context.Context, model *ChatModel, messages []Message) ([]Message, error) {
+	var index = len(messages) - 1
+	for index >= 0 && messages[index].Role != RoleUser {
+		index--
+	}
+
+	if index < 0 {
+		return messages, nil
+	}
+
+	var text, err = my.context(ctx, model, messages[index].Content)
+	if err != nil || text == "" {
+		return messages, err
+	}
+
+	var result = make([]Message, 0, len(messages)+1)
+	result = append(result, messages[:index]...)
+	result = append(result, Message{Role: RoleSystem, Content: text})
+	return append(result, messages[index:]...), nil
+}
+
+// BM25Retriever is an in-memory Retriever ranking documents by BM25 over their titles and contents. Words are split
+// at non-letters, and every Chinese, Japanese or Korean character is a word by itself.
+type BM25Retriever struct {
+	K1 float64 // the term frequency saturation, 1.2 by default
+	B  float64 // the document length normalization, 0.75 by default
+
+	lock        sync.RWMutex
+	documents   []bm25Document
+	frequencies map[string]int // the count of documents containing every term
+	totalLength int
+}
+
+type bm25Document struct {
+	Document
+	terms  map[string]int
+	length int
+}
+
+func NewBM25Retriever() *BM25Retriever {
+	return &BM25Retriever{K1: 1.2, B: 0.75, frequencies: make(map[string]int)}
+}
+
+// Add indexes documents, replacing the ones with the same ID
+func (my *BM25Retriever) Add(documents ...Document) {
+	my.lock.Lock()
+	defer my.lock.Unlock()
+
+	for _, document := range documents {
+		my.removeLocked(document.ID)
+
+		var terms = make(map[string]int)
+		var length = 0
+		for _, term := range splitTerms(document.Title + "\n" + document.Content) {
+			terms[term]++
+			length++
+		}
+
+		for term := range terms {
+			my.frequencies[term]++
+		}
+
+		document.Score = 0
+		my.documents = append(my.documents, bm25Document{Document: document, terms: terms, length: length})
+		my.totalLength += length
+	}
+}
+
+// Remove removes the document with id, and returns false when there is none
+func (my *BM25Retriever) Remove(id string) bool {
+	my.lock.Lock()
+	defer my.lock.Unlock()
+	return my.removeLocked(id)
+}
+
+func (my *BM25Retriever) removeLocked(id string) bool {
+	var index = slices.IndexFunc(my.documents, func(document bm25Document) bool { return document.ID == id })
+	if index < 0 {
+		return false
+	}
+
+	var document = my.documents[index]
+	for term := range document.terms {
+		if my.frequencies[term]--; my.frequencies[term] <= 0 {
+			delete(my.frequencies, term)
+		}
+	}
+
+	my.totalLength -= document.length
+	my.documents = slices.Delete(my.documents, index, index+1)
+	return true
+}
+
+func (my *BM25Retriever) Len() int {
+	my.lock.RLock()
+	defer my.lock.RUnlock()
+	return len(my.documents)
+}
+
+func (my *BM25Retriever) Retrieve(ctx context.Context, query string, limit int) ([]Document, error) {
+	my.lock.RLock()
+	defer my.lock.RUnlock()
+
+	if len(my.documents) == 0 || limit <= 0 {
+		return nil, nil
+	}
+
+	var queryTerms = splitTerms(query)
+	slices.Sort(queryTerms)
+	queryTerms = slices.Compact(queryTerms)
+
+	var count = float64(len(my.documents))
+	var averageLength = max(float64(my.totalLength)/count, 1)
+	var results []Document
+
+	for i := range my.documents {
+		if i%1024 == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+
+		var document = &my.documents[i]
+		var score = 0.0
+		for _, term := range queryTerms {
+			var frequency = float64(document.terms[term])
+			if frequency == 0 {
+				continue
+			}
+
+			var df = float64(my.frequencies[term])
+			var idf = math.Log(1 + (count-df+0.5)/(df+0.5))
+			var norm = my.K1 * (1 - my.B + my.B*float64(document.length)/averageLength)
+			score += idf * frequency * (my.K1 + 1) / (frequency + norm)
+		}
+
+		if score > 0 {
+			var result = document.Document
+			result.Score = score
+			results = append(results, result)
+		}
+	}
+
+	slices.SortStableFunc(results, func(a, b Document) int {
+		if a.Score > b.Score {
+			return -1
+		} else if a.Score < b.Score {
+			return 1
+		}
+
+		return 0
+	})
+
+	return results[:min(limit, len(results))], nil
+}
+
+// splitTerms lowercases text and splits it into words
+func splitTerms(text string) []string {
+	var terms []string
+	var start = -1
+	for i, r := range text {
+		var isCJK = unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+		var isWord = !isCJK && (unicode.IsLetter(r) || unicode.IsDigit(r))
+
+		if !isWord && start >= 0 {
+			terms = append(terms, strings.ToLower(text[start:i]))
+			start = -1
+		}
+
+		if isCJK {
+			terms = append(terms, string(r))
+		} else if isWord && start < 0 {
+			start = i
+		}
+	}
+
+	if start >= 0 {
+		terms = append(terms, strings.ToLower(text[start:]))
+	}
+
+	return terms
+}
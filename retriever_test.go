@@ -0,0 +1,98 @@
+package rwkv
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+)
+
+/********************************************************************
+created:    2026-10-18
+author:     lixianmin
+
+Copyright (C) - All Rights Reserved
+*********************************************************************/
+
+func TestSplitTerms(t *testing.T) {
+	var terms = splitTerms("Hello, RWKV-4 world! 你好")
+	assert(t, slices.Equal(terms, []string{"hello", "rwkv", "4", "world", "你", "好"}))
+}
+
+func TestBM25Retriever(t *testing.T) {
+	var retriever = NewBM25Retriever()
+	retriever.Add(
+		Document{ID: "gpu", Title: "GPU", Content: "Set GpuEnable to offload the layers of the model to the GPU."},
+		Document{ID: "tokenizer", Title: "Tokenizer", Content: "The World tokenizer is chosen from the vocabulary size of the model."},
+		Document{ID: "packaging", Title: "Packaging", Content: "Build with rwkv_noembed to drop the embedded vocabularies."},
+		Document{ID: "chinese", Content: "模型的状态可以保存到磁盘"},
+	)
+
+	var ctx = context.Background()
+	var documents, err = retriever.Retrieve(ctx, "how to offload layers to the gpu", 2)
+	assert(t, err == nil && len(documents) == 2 && documents[0].ID == "gpu" && documents[0].Score > documents[1].Score)
+
+	documents, _ = retriever.Retrieve(ctx, "状态保存", 4)
+	assert(t, len(documents) == 1 && documents[0].ID == "chinese")
+
+	documents, _ = retriever.Retrieve(ctx, "quantization", 4)
+	assert(t, len(documents) == 0)
+
+	// replacing and removing keep the statistics right
+	retriever.Add(Document{ID: "gpu", Content: "unrelated"})
+	assert(t, retriever.Len() == 4)
+	documents, _ = retriever.Retrieve(ctx, "gpu offload", 4)
+	assert(t, len(documents) == 0)
+
+	assert(t, retriever.Remove("gpu") && !retriever.Remove("gpu"))
+	assert(t, retriever.Len() == 3 && retriever.frequencies["unrelated"] == 0)
+}
+
+func TestRetrieval(t *testing.T) {
+	var model = newTinyChatModel(t)
+	var retriever = NewBM25Retriever()
+	retriever.Add(
+		Document{ID: "a", Title: "Model", Content: "The model file is loaded by LoadFromFile."},
+		Document{ID: "b", Title: "Model", Content: strings.Repeat("The model is large. ", 50)},
+	)
+
+	var retrieval, err = newRetrieval(RetrievalOptions{Retriever: retriever, MaxTokens: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var messages = []Message{{Role: RoleUser, Content: "how is the model loaded?"}}
+	result, err := retrieval.withContext(context.Background(), model, messages)
+	assert(t, err == nil && len(result) == 2 && len(messages) == 1)
+	assert(t, result[0].Role == RoleSystem && strings.Contains(result[0].Content, "[Model] The model file is loaded"))
+	assert(t, !strings.Contains(result[0].Content, "large"), "the document over the budget should be left out")
+
+	var bot = NewChatbotWithTemplate(model, WorldTemplate, "")
+	bot.SetSampling(SamplingOptions{MaxTokens: 2, Temperature: 1, TopP: 1})
+	assert(t, bot.SetRetrieval(RetrievalOptions{Retriever: retriever}) == nil)
+	bot.Process("how is the model loaded?")
+	assert(t, len(bot.History()) == 2, "the documents should not be kept in the history")
+
+	// the context of the turn reaches the retriever
+	type key struct{}
+	var seen any
+	var probe = retrieverFunc(func(ctx context.Context, query string, limit int) ([]Document, error) {
+		seen = ctx.Value(key{})
+		return nil, nil
+	})
+	assert(t, bot.SetRetrieval(RetrievalOptions{Retriever: probe}) == nil)
+	_, err = bot.ProcessWithContext(context.WithValue(context.Background(), key{}, "turn"), "and saved?")
+	assert(t, err == nil && seen == "turn", "the retriever should get the context of ProcessWithContext")
+
+	_, err = model.Chat(context.Background(), messages, ChatOptions{
+		Sampling:  &SamplingOptions{MaxTokens: 2, Temperature: 1, TopP: 1},
+		Retrieval: &RetrievalOptions{Retriever: retriever, Template: "{{range .Documents}}{{.Content}}{{end}}"},
+	})
+	assert(t, err == nil)
+}
+
+type retrieverFunc func(ctx context.Context, query string, limit int) ([]Document, error)
+
+func (my retrieverFunc) Retrieve(ctx context.Context, query string, limit int) ([]Document, error) {
+	return my(ctx, query, limit)
+}
@@ -26,9 +26,11 @@ type RwkvOptions struct {
 	Temperature      float32 // It could be a good idea to increase temperature when top_p is low
 	TopP             float32 // Reduce top_p (to 0.5, 0.2, 0.1 etc.) for better Q&A accuracy (and less diversity)
 	TokenizerType    TokenizerType
+	Tokenizer        Tokenizer // custom tokenizer, such as one from NewWorldTokenizerFromFile; TokenizerType is ignored when set
 	CpuThreads       uint32
 	GpuEnable        bool
 	GpuOffLoadLayers uint32
+	Backend          Backend // BackendC by default, BackendGo runs without the dynamic library
 }
 
 func hasCtx(ctx *RwkvCtx) error {
@@ -38,41 +40,55 @@ func hasCtx(ctx *RwkvCtx) error {
 	return nil
 }
 
-func NewRwkvAutoModel(options RwkvOptions) (*RwkvModel, error) {
+// initError returns why ctx failed to init when the backend tells more than the error flags of rwkv.cpp
+func initError(cRwkv CRwkv, ctx *RwkvCtx, err error) error {
+	if _, ok := cRwkv.(*GoRwkvImpl); ok {
+		if lastError := cRwkv.RwkvGetLastError(ctx); lastError != nil {
+			return lastError
+		}
+	}
+
+	return err
+}
 
-	file, err := dumpRwkvLibrary(options.GpuEnable)
+func NewRwkvAutoModel(options RwkvOptions) (*RwkvModel, error) {
+	cRwkv, dylibPath, err := newBackend(&options)
 	if err != nil {
 		return nil, err
 	}
 
-	dylibPath := file.Name()
-
-	model, err := NewRwkvModel(dylibPath, options)
+	model, err := newRwkvModel(cRwkv, dylibPath, options)
 	if err != nil {
 		return nil, err
 	}
-	model.isAutoLoad = true
+	model.isAutoLoad = dylibPath != ""
 	return model, nil
 }
 
+// NewRwkvModel loads rwkv.cpp from dylibPath, or runs on the Go backend when options.Backend is BackendGo
 func NewRwkvModel(dylibPath string, options RwkvOptions) (*RwkvModel, error) {
+	if options.Backend == BackendGo {
+		return newRwkvModel(NewGoRwkv(), "", options)
+	}
+
 	cRwkv, err := NewCRwkv(dylibPath)
 	if err != nil {
 		return nil, err
 	}
 
-	var tk Tokenizer
+	return newRwkvModel(cRwkv, dylibPath, options)
+}
 
-	if options.TokenizerType == Normal {
-		tk, err = NewNormalTokenizer()
-	}
+func newRwkvModel(cRwkv CRwkv, dylibPath string, options RwkvOptions) (*RwkvModel, error) {
+	var err error
 
-	if options.TokenizerType == World {
-		tk, err = NewWorldTokenizer()
-	}
-
-	if err != nil {
-		return nil, err
+	// with Auto, the tokenizer is created in LoadFromFile once the vocabulary size is known
+	var tk = options.Tokenizer
+	if tk == nil && options.TokenizerType != Auto {
+		tk, err = newTokenizer(options.TokenizerType)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	if options.GpuEnable {
@@ -99,7 +115,25 @@ func (m *RwkvModel) LoadFromFile(path string) error {
 		return errors.New("the system cannot find the model file specified")
 	}
 	ctx := m.cRwkv.RwkvInitFromFile(path, m.options.CpuThreads)
+	if err = hasCtx(ctx); err != nil {
+		return initError(m.cRwkv, ctx, err)
+	}
 	m.ctx = ctx
+
+	if m.options.Tokenizer == nil {
+		tokenizerType, err := resolveTokenizerType(m.options.TokenizerType, m.cRwkv.RwkvGetNVocab(ctx))
+		if err != nil {
+			return err
+		}
+
+		if m.tokenizer == nil {
+			m.tokenizer, err = newTokenizer(tokenizerType)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
 	// offload all layers to GPU
 	gpuNLayers := uint32(m.cRwkv.RwkvGetNLayer(ctx) + 1)
 	// if user specify the layers to offload, use the user specified value
@@ -200,6 +234,7 @@ func (s *RwkvState) handelInput(input string) error {
 
 func (s *RwkvState) generateResponse(callback func(s string) bool) (string, error) {
 	responseText := ""
+	eos := eosOf(s.rwkvModel.tokenizer)
 	for i := 0; i < s.rwkvModel.options.MaxTokens; i++ {
 
 		token, err := SampleLogits(s.logits, s.rwkvModel.options.Temperature, s.rwkvModel.options.TopP, map[int]float32{})
@@ -207,6 +242,10 @@ func (s *RwkvState) generateResponse(callback func(s string) bool) (string, erro
 			return "", err
 		}
 
+		if token == eos {
+			break
+		}
+
 		err = s.rwkvModel.cRwkv.RwkvEval(s.rwkvModel.ctx, uint32(token), s.state, s.state, s.logits)
 		if err != nil {
 			return "", err
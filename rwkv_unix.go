@@ -1,7 +1,7 @@
 // Copyright (c) seasonjs. All rights reserved.
 // Licensed under the MIT License. See License.txt in the project root for license information.
 
-//go:build darwin || linux
+//go:build darwin || (linux && (amd64 || arm64))
 
 package rwkv
 
@@ -0,0 +1,115 @@
+package rwkv
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+)
+
+/********************************************************************
+created:    2026-10-18
+author:     lixianmin
+
+Copyright (C) - All Rights Reserved
+*********************************************************************/
+
+var ErrInvalidSafetensors = errors.New("the safetensors file is invalid")
+
+// safetensor is a tensor of a safetensors file, Shape is in the PyTorch order with the rows first
+type safetensor struct {
+	Name  string
+	DType string
+	Shape []int
+	Data  []byte
+}
+
+// safetensorsFile is the content of a safetensors file: a little endian uint64 of the header size, the json header of
+// {name: {dtype, shape, data_offsets}} with an optional __metadata__ of strings, then the data of the tensors
+type safetensorsFile struct {
+	Tensors  map[string]*safetensor
+	Metadata map[string]string
+}
+
+var safetensorSizes = map[string]int{"F64": 8, "F32": 4, "F16": 2, "BF16": 2}
+
+func readSafetensorsFile(path string) (*safetensorsFile, error) {
+	var content, err = os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(content) < 8 {
+		return nil, fmt.Errorf("%w: %s is too short", ErrInvalidSafetensors, path)
+	}
+
+	var headerSize = binary.LittleEndian.Uint64(content)
+	if headerSize > uint64(len(content)-8) {
+		return nil, fmt.Errorf("%w: the header of %s is out of the file", ErrInvalidSafetensors, path)
+	}
+
+	var header map[string]json.RawMessage
+	if err = json.Unmarshal(content[8:8+headerSize], &header); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSafetensors, err)
+	}
+
+	var data = content[8+headerSize:]
+	var file = &safetensorsFile{Tensors: make(map[string]*safetensor, len(header)), Metadata: map[string]string{}}
+	for name, raw := range header {
+		if name == "__metadata__" {
+			if err = json.Unmarshal(raw, &file.Metadata); err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidSafetensors, err)
+			}
+			continue
+		}
+
+		var info struct {
+			DType       string `json:"dtype"`
+			Shape       []int  `json:"shape"`
+			DataOffsets [2]int `json:"data_offsets"`
+		}
+
+		if err = json.Unmarshal(raw, &info); err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidSafetensors, name, err)
+		}
+
+		var size, elements = safetensorSizes[info.DType], 1
+		for _, dim := range info.Shape {
+			if dim < 0 || dim > 0 && elements > math.MaxInt/dim {
+				return nil, fmt.Errorf("%w: %s is of the shape %v", ErrInvalidSafetensors, name, info.Shape)
+			}
+			elements *= dim
+		}
+
+		var start, end = info.DataOffsets[0], info.DataOffsets[1]
+		if size == 0 || start < 0 || start > end || end > len(data) || end-start != elements*size {
+			return nil, fmt.Errorf("%w: %s is %s%v at %v, which does not fit the data", ErrInvalidSafetensors, name,
+				info.DType, info.Shape, info.DataOffsets)
+		}
+
+		file.Tensors[name] = &safetensor{Name: name, DType: info.DType, Shape: info.Shape, Data: data[start:end]}
+	}
+
+	return file, nil
+}
+
+// float32s decodes the data of the tensor
+func (my *safetensor) float32s() []float32 {
+	var values = make([]float32, len(my.Data)/safetensorSizes[my.DType])
+	for i := range values {
+		switch my.DType {
+		case "F64":
+			values[i] = float32(math.Float64frombits(binary.LittleEndian.Uint64(my.Data[i*8:])))
+		case "F32":
+			values[i] = math.Float32frombits(binary.LittleEndian.Uint32(my.Data[i*4:]))
+		case "F16":
+			values[i] = halfToFloat32(binary.LittleEndian.Uint16(my.Data[i*2:]))
+		case "BF16":
+			values[i] = math.Float32frombits(uint32(binary.LittleEndian.Uint16(my.Data[i*2:])) << 16)
+		}
+	}
+
+	return values
+}
@@ -10,6 +10,21 @@ import (
 	"sort"
 )
 
+// SamplingOptions holds the per request sampling parameters, which default to the ones of RwkvOptions
+type SamplingOptions struct {
+	MaxTokens   int
+	Temperature float32 // It could be a good idea to increase temperature when top_p is low
+	TopP        float32 // Reduce top_p (to 0.5, 0.2, 0.1 etc.) for better Q&A accuracy (and less diversity)
+}
+
+func newSamplingOptions(options *RwkvOptions) SamplingOptions {
+	return SamplingOptions{
+		MaxTokens:   options.MaxTokens,
+		Temperature: options.Temperature,
+		TopP:        options.TopP,
+	}
+}
+
 func SampleLogits(logits v32.V32, temperature float32, topP float32, logitBias map[int]float32) (int, error) {
 	if temperature < 0 {
 		return 0, errors.New("temperature must be non-negative")
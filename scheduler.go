@@ -0,0 +1,470 @@
+package rwkv
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+/********************************************************************
+created:    2026-10-18
+author:     lixianmin
+
+Copyright (C) - All Rights Reserved
+*********************************************************************/
+
+type Priority int
+
+const (
+	PriorityBatch       Priority = iota // such as summarization, runs when nothing more urgent is waiting
+	PriorityNormal                      //
+	PriorityInteractive                 // a user is waiting, pre-empts the lower priorities after every token
+	priorityCount
+)
+
+const defaultPrefillChunk = 16
+
+var (
+	ErrQueueFull       = errors.New("the scheduler queue is full")
+	ErrTenantQueueFull = errors.New("the scheduler queue of the tenant is full")
+	ErrSchedulerClosed = errors.New("the scheduler is closed")
+	ErrInvalidPriority = errors.New("invalid priority")
+)
+
+type SchedulerOptions struct {
+	MaxQueueDepth  int // max count of unfinished jobs, 0 for unlimited
+	MaxTenantDepth int // max count of unfinished jobs of a tenant, 0 for unlimited
+	PrefillChunk   int // max count of prompt tokens evaluated in one step, 0 for 16
+}
+
+// GenerationJob is a prompt to complete
+type GenerationJob struct {
+	Tenant      string
+	Priority    Priority
+	Prompt      string
+	Sampling    *SamplingOptions        // nil uses the sampling fields of RwkvOptions
+	StopStrings []string                // generation stops at the first stop string, which is removed from the text
+	OnToken     func(piece string) bool // streams the generated text, returning false stops the generation
+}
+
+type GenerationResult struct {
+	Text      string
+	Tokens    int           // count of generated tokens
+	QueueWait time.Duration // from Submit to the first step of the job
+	Elapsed   time.Duration // from Submit to the end of the job
+}
+
+// JobHandle tracks a submitted job
+type JobHandle struct {
+	done   chan struct{}
+	result GenerationResult
+	err    error
+	cancel context.CancelFunc
+}
+
+// Done is closed when the job is finished
+func (my *JobHandle) Done() <-chan struct{} {
+	return my.done
+}
+
+// Wait waits for the job until ctx is done
+func (my *JobHandle) Wait(ctx context.Context) (GenerationResult, error) {
+	select {
+	case <-my.done:
+		return my.result, my.err
+	case <-ctx.Done():
+		return GenerationResult{}, ctx.Err()
+	}
+}
+
+// Cancel stops the job before its next step
+func (my *JobHandle) Cancel() {
+	my.cancel()
+}
+
+type SchedulerStats struct {
+	Queued           [priorityCount]int           // waiting jobs by priority, including the ones between two steps
+	Running          int                          // jobs in a step right now
+	Finished         int                          //
+	AverageQueueWait [priorityCount]time.Duration // from Submit to the first step, by priority
+}
+
+type jobState struct {
+	job       GenerationJob
+	handle    *JobHandle
+	ctx       context.Context
+	sampling  SamplingOptions
+	submitted time.Time
+	started   bool
+	prompt    []int
+	state     []float32
+	logits    []float32
+	tokens    []int // generated tokens which are not decoded into pieces yet
+	generated int
+	text      strings.Builder
+	streamed  int // length of the text passed to OnToken
+}
+
+// cutText returns the text up to the first stop string, and whether there is one
+func (my *jobState) cutText() (string, bool) {
+	var text = my.text.String()
+	var stopped = false
+	for _, stop := range my.job.StopStrings {
+		if index := strings.Index(text, stop); stop != "" && index >= 0 {
+			text = text[:index]
+			stopped = true
+		}
+	}
+
+	return text, stopped
+}
+
+// stream passes the part of text not streamed yet to OnToken. Unless final is set, a tail of text which may be the
+// start of a stop string is kept back until the next pieces tell. It returns false when OnToken asks to stop.
+func (my *jobState) stream(text string, final bool) bool {
+	var end = len(text)
+	if !final {
+		end -= stopPrefixLen(text, my.job.StopStrings)
+	}
+
+	if my.job.OnToken == nil || end <= my.streamed {
+		return true
+	}
+
+	var piece = text[my.streamed:end]
+	my.streamed = end
+	return my.job.OnToken(piece)
+}
+
+// stopPrefixLen returns the length of the longest tail of text which is the start of a stop string
+func stopPrefixLen(text string, stops []string) int {
+	var result = 0
+	for _, stop := range stops {
+		for k := min(len(stop)-1, len(text)); k > result; k-- {
+			if strings.HasSuffix(text, stop[:k]) {
+				result = k
+				break
+			}
+		}
+	}
+
+	return result
+}
+
+// fairQueue serves the tenants round-robin, and the jobs of a tenant in order
+type fairQueue struct {
+	tenants []string
+	jobs    map[string][]*jobState
+	cursor  int
+	size    int
+}
+
+func newFairQueue() *fairQueue {
+	return &fairQueue{jobs: make(map[string][]*jobState)}
+}
+
+func (my *fairQueue) push(job *jobState) {
+	var tenant = job.job.Tenant
+	if len(my.jobs[tenant]) == 0 {
+		my.tenants = append(my.tenants, tenant)
+	}
+
+	my.jobs[tenant] = append(my.jobs[tenant], job)
+	my.size++
+}
+
+func (my *fairQueue) pop() *jobState {
+	if my.size == 0 {
+		return nil
+	}
+
+	if my.cursor >= len(my.tenants) {
+		my.cursor = 0
+	}
+
+	var tenant = my.tenants[my.cursor]
+	var jobs = my.jobs[tenant]
+	var job = jobs[0]
+	jobs[0] = nil
+	my.size--
+
+	if len(jobs) == 1 {
+		delete(my.jobs, tenant)
+		my.tenants = append(my.tenants[:my.cursor], my.tenants[my.cursor+1:]...)
+	} else {
+		my.jobs[tenant] = jobs[1:]
+		my.cursor++
+	}
+
+	return job
+}
+
+// Scheduler interleaves generation jobs token by token across the contexts of a ModelPool. Every step picks the
+// most urgent priority, and the tenants of the same priority take turns, so interactive requests pre-empt batch
+// jobs and a tenant with many jobs cannot starve the others.
+type Scheduler struct {
+	pool    *ModelPool
+	options SchedulerOptions
+	lock    sync.Mutex
+	cond    *sync.Cond
+	queues  [priorityCount]*fairQueue
+	tenants map[string]int // unfinished jobs by tenant
+	pending int            // unfinished jobs
+	running int
+	closed  bool
+	wg      sync.WaitGroup
+	stop    context.CancelFunc // stops the workers which are still waiting for a context of the pool
+
+	finished  int
+	waitSum   [priorityCount]time.Duration
+	waitCount [priorityCount]int
+}
+
+// NewScheduler starts one worker for every context of pool, each worker holds its context until Close
+func NewScheduler(pool *ModelPool, options SchedulerOptions) *Scheduler {
+	if options.PrefillChunk <= 0 {
+		options.PrefillChunk = defaultPrefillChunk
+	}
+
+	var scheduler = &Scheduler{
+		pool:    pool,
+		options: options,
+		tenants: make(map[string]int),
+	}
+
+	scheduler.cond = sync.NewCond(&scheduler.lock)
+	for i := range scheduler.queues {
+		scheduler.queues[i] = newFairQueue()
+	}
+
+	var ctx, stop = context.WithCancel(context.Background())
+	scheduler.stop = stop
+	for i := 0; i < pool.Size(); i++ {
+		scheduler.wg.Add(1)
+		go scheduler.goWork(ctx)
+	}
+
+	return scheduler
+}
+
+// Submit queues job, the job is canceled when ctx is done, a nil ctx is never done
+func (my *Scheduler) Submit(ctx context.Context, job GenerationJob) (*JobHandle, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if job.Priority < 0 || job.Priority >= priorityCount {
+		return nil, ErrInvalidPriority
+	}
+
+	my.lock.Lock()
+	defer my.lock.Unlock()
+
+	if my.closed {
+		return nil, ErrSchedulerClosed
+	}
+
+	if my.options.MaxQueueDepth > 0 && my.pending >= my.options.MaxQueueDepth {
+		return nil, ErrQueueFull
+	}
+
+	if my.options.MaxTenantDepth > 0 && my.tenants[job.Tenant] >= my.options.MaxTenantDepth {
+		return nil, ErrTenantQueueFull
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	var handle = &JobHandle{done: make(chan struct{}), cancel: cancel}
+	var sampling = newSamplingOptions(my.pool.source.options)
+	if job.Sampling != nil {
+		sampling = *job.Sampling
+	}
+
+	my.pending++
+	my.tenants[job.Tenant]++
+	my.queues[job.Priority].push(&jobState{
+		job:       job,
+		handle:    handle,
+		ctx:       ctx,
+		sampling:  sampling,
+		submitted: time.Now(),
+	})
+
+	my.cond.Signal()
+	return handle, nil
+}
+
+// Stats returns a snapshot of the queues
+func (my *Scheduler) Stats() SchedulerStats {
+	my.lock.Lock()
+	defer my.lock.Unlock()
+
+	var stats = SchedulerStats{Running: my.running, Finished: my.finished}
+	for i, queue := range my.queues {
+		stats.Queued[i] = queue.size
+		if my.waitCount[i] > 0 {
+			stats.AverageQueueWait[i] = my.waitSum[i] / time.Duration(my.waitCount[i])
+		}
+	}
+
+	return stats
+}
+
+// Close fails the unfinished jobs with ErrSchedulerClosed, and gives the contexts back to the pool
+func (my *Scheduler) Close() {
+	my.lock.Lock()
+	if my.closed {
+		my.lock.Unlock()
+		return
+	}
+
+	my.closed = true
+	for _, queue := range my.queues {
+		for job := queue.pop(); job != nil; job = queue.pop() {
+			my.finishLocked(job, ErrSchedulerClosed)
+		}
+	}
+
+	my.cond.Broadcast()
+	my.lock.Unlock()
+
+	my.stop()
+	my.wg.Wait()
+}
+
+func (my *Scheduler) goWork(ctx context.Context) {
+	defer my.wg.Done()
+
+	var model, err = my.pool.Acquire(ctx)
+	if err != nil {
+		return
+	}
+	defer my.pool.Release(model)
+
+	for {
+		var job = my.next()
+		if job == nil {
+			return
+		}
+
+		var done, err = my.step(model, job)
+
+		my.lock.Lock()
+		my.running--
+		if done || err != nil || my.closed {
+			if err == nil && my.closed && !done {
+				err = ErrSchedulerClosed
+			}
+			my.finishLocked(job, err)
+		} else {
+			my.queues[job.job.Priority].push(job)
+			my.cond.Signal()
+		}
+		my.lock.Unlock()
+	}
+}
+
+// next blocks until there is a job to step, or returns nil when the scheduler is closed
+func (my *Scheduler) next() *jobState {
+	my.lock.Lock()
+	defer my.lock.Unlock()
+
+	for {
+		if my.closed {
+			return nil
+		}
+
+		for i := len(my.queues) - 1; i >= 0; i-- {
+			if job := my.queues[i].pop(); job != nil {
+				if !job.started {
+					job.started = true
+					var wait = time.Since(job.submitted)
+					job.handle.result.QueueWait = wait
+					my.waitSum[i] += wait
+					my.waitCount[i]++
+				}
+
+				my.running++
+				return job
+			}
+		}
+
+		my.cond.Wait()
+	}
+}
+
+// step evaluates a chunk of the prompt, or generates one token
+func (my *Scheduler) step(model *ChatModel, job *jobState) (bool, error) {
+	if err := job.ctx.Err(); err != nil {
+		return true, err
+	}
+
+	if job.state == nil {
+		job.state, job.logits = model.newState()
+		var tokens, err = model.tokenizer.Encode(job.job.Prompt)
+		if err != nil {
+			return true, err
+		}
+		job.prompt = tokens
+	}
+
+	if len(job.prompt) > 0 {
+		var chunk = job.prompt[:min(len(job.prompt), my.options.PrefillChunk)]
+		job.prompt = job.prompt[len(chunk):]
+		return false, model.evalTokens(chunk, job.state, job.logits)
+	}
+
+	if job.generated >= job.sampling.MaxTokens {
+		return true, nil
+	}
+
+	var token, err = SampleLogits(job.logits, job.sampling.Temperature, job.sampling.TopP, nil)
+	if err != nil {
+		return true, err
+	}
+
+	if token == model.EOS() {
+		var text, _ = job.cutText()
+		job.stream(text, true)
+		return true, nil
+	}
+
+	job.generated++
+	if err = model.evalTokens([]int{token}, job.state, job.logits); err != nil {
+		return true, err
+	}
+
+	// wait for the rest bytes of an incomplete utf-8 character
+	job.tokens = append(job.tokens, token)
+	var piece = model.Decode(job.tokens)
+	if strings.Contains(piece, "\ufffd") && job.generated < job.sampling.MaxTokens {
+		return false, nil
+	}
+
+	job.tokens = job.tokens[:0]
+	job.text.WriteString(piece)
+
+	// the pieces streamed leave out the stop string, the same as the text of the result
+	var text, stopped = job.cutText()
+	var final = stopped || job.generated >= job.sampling.MaxTokens
+	return !job.stream(text, final) || final, nil
+}
+
+func (my *Scheduler) finishLocked(job *jobState, err error) {
+	var text, _ = job.cutText()
+	var handle = job.handle
+	handle.result.Text = text
+	handle.result.Tokens = job.generated
+	handle.result.Elapsed = time.Since(job.submitted)
+	handle.err = err
+	handle.cancel()
+	close(handle.done)
+
+	job.state, job.logits = nil, nil
+	my.pending--
+	my.finished++
+	if my.tenants[job.job.Tenant]--; my.tenants[job.job.Tenant] <= 0 {
+		delete(my.tenants, job.job.Tenant)
+	}
+}
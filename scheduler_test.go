@@ -0,0 +1,134 @@
+package rwkv
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+/********************************************************************
+created:    2026-10-18
+author:     lixianmin
+
+Copyright (C) - All Rights Reserved
+*********************************************************************/
+
+func TestFairQueue(t *testing.T) {
+	var queue = newFairQueue()
+	var push = func(tenant string, prompt string) {
+		queue.push(&jobState{job: GenerationJob{Tenant: tenant, Prompt: prompt}})
+	}
+
+	push("a", "a1")
+	push("a", "a2")
+	push("a", "a3")
+	push("b", "b1")
+	push("c", "c1")
+	push("c", "c2")
+
+	var order []string
+	for job := queue.pop(); job != nil; job = queue.pop() {
+		order = append(order, job.job.Prompt)
+	}
+
+	var want = []string{"a1", "b1", "c1", "a2", "c2", "a3"}
+	assert(t, slices.Equal(order, want), "tenants should take turns")
+}
+
+func TestScheduler(t *testing.T) {
+	var model = newTinyChatModel(t)
+	var pool, err = NewModelPool(model, ModelPoolOptions{Size: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	var scheduler = NewScheduler(pool, SchedulerOptions{MaxQueueDepth: 3, PrefillChunk: 4})
+	defer scheduler.Close()
+
+	var finished = make(chan Priority, 3)
+	var submit = func(priority Priority, maxTokens int) (*JobHandle, error) {
+		return scheduler.Submit(context.Background(), GenerationJob{
+			Tenant:   "tenant",
+			Priority: priority,
+			Prompt:   "summarize the following text",
+			Sampling: &SamplingOptions{MaxTokens: maxTokens, Temperature: 1, TopP: 1},
+			OnToken: func(piece string) bool {
+				return true
+			},
+		})
+	}
+
+	var batch1, _ = submit(PriorityBatch, 64)
+	var batch2, _ = submit(PriorityBatch, 64)
+	var interactive, _ = submit(PriorityInteractive, 2)
+
+	_, err = submit(PriorityInteractive, 2)
+	assert(t, errors.Is(err, ErrQueueFull), "the fourth job should be rejected")
+
+	for _, handle := range []*JobHandle{batch1, batch2, interactive} {
+		go func(handle *JobHandle) {
+			<-handle.Done()
+			if handle == interactive {
+				finished <- PriorityInteractive
+			} else {
+				finished <- PriorityBatch
+			}
+		}(handle)
+	}
+
+	var ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result, err := interactive.Wait(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert(t, <-finished == PriorityInteractive, "the interactive job should pre-empt the batch jobs")
+	assert(t, result.Tokens <= 2 && result.QueueWait >= 0)
+
+	batch2.Cancel()
+	_, err = batch2.Wait(ctx)
+	assert(t, errors.Is(err, context.Canceled), "a canceled job should fail with context.Canceled")
+
+	if _, err = batch1.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var stats = scheduler.Stats()
+	assert(t, stats.Finished == 3 && stats.Running == 0)
+}
+
+func TestSchedulerStopStrings(t *testing.T) {
+	var model = newTinyChatModel(t)
+	newScriptedRwkv(model, "It is sunny.\n\nUser: and tomorrow?")
+	var pool, err = NewModelPool(model, ModelPoolOptions{Size: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	var scheduler = NewScheduler(pool, SchedulerOptions{})
+	defer scheduler.Close()
+
+	var streamed string
+	var handle, _ = scheduler.Submit(nil, GenerationJob{
+		Prompt:      "How is the weather?",
+		Sampling:    &SamplingOptions{MaxTokens: 64, Temperature: 1, TopP: 1},
+		StopStrings: []string{"\n\nUser:"},
+		OnToken: func(piece string) bool {
+			streamed += piece
+			return true
+		},
+	})
+
+	var ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result, err := handle.Wait(ctx)
+	assert(t, err == nil && result.Text == "It is sunny.", result.Text)
+	assert(t, streamed == result.Text, "the stop string should not be streamed: "+streamed)
+}
@@ -0,0 +1,565 @@
+package rwkv
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+/********************************************************************
+created:    2026-10-18
+author:     lixianmin
+
+Copyright (C) - All Rights Reserved
+*********************************************************************/
+
+const sessionFileExt = ".session"
+
+var (
+	ErrSessionExists   = errors.New("the session already exists")
+	ErrSessionNotFound = errors.New("the session is not found")
+)
+
+type SessionManagerOptions struct {
+	UserName    string        // the user name of new sessions, unused when Template is set
+	BotName     string        // the bot name of new sessions, unused when Template is set
+	Template    ChatTemplate  // the chat format of new sessions, nil for `UserName: ...\n\nBotName: `
+	Prompt      string        // the prompt of new sessions, it is evaluated only once for all sessions
+	Dir         string        // idle sessions are evicted into this directory, "" keeps all sessions in memory
+	IdleTimeout time.Duration // sessions idle longer are evicted, 0 disables idle eviction
+	History     HistoryPolicy // cuts the turns of every session as it grows, nil keeps all turns
+	Encoding    StateEncoding // the state encoding of evicted sessions, the lossy ones make the files smaller
+}
+
+type session struct {
+	lock     sync.Mutex // serializes the turns of a session
+	bot      *Chatbot
+	adapter  string // the adapter of bot, "" for none
+	lastUsed time.Time
+	evicted  bool // set under lock when the session leaves memory, the holder should look it up again
+}
+
+// sessionTemplate is the template of an adapter, whose prompt is evaluated once by the first session using it
+type sessionTemplate struct {
+	model *ChatModel // the model of the adapter, which is another one after the adapter is removed and added again
+	once  sync.Once
+	bot   *Chatbot
+}
+
+// sessionSnapshot is what an evicted session keeps on disk
+type sessionSnapshot struct {
+	State    []float32 // the state in StateFP32
+	Encoded  []byte    // the state by EncodeState in the other encodings
+	History  []Message
+	Sampling SamplingOptions
+	Adapter  string
+}
+
+// SessionManager hosts many named conversations sharing one ChatModel, every session has its own state,
+// history, sampling parameters and adapter. Idle sessions are evicted to disk and loaded back transparently.
+type SessionManager struct {
+	model     *ChatModel
+	options   SessionManagerOptions
+	template  *Chatbot                    // evaluates the prompt once, sessions are forked from it
+	templates map[string]*sessionTemplate // the templates of the adapters, created on their first use
+	lock      sync.Mutex
+	sessions  map[string]*session
+	done      chan struct{}
+	wg        sync.WaitGroup
+}
+
+func NewSessionManager(model *ChatModel, options SessionManagerOptions) (*SessionManager, error) {
+	if options.Dir != "" {
+		if err := os.MkdirAll(options.Dir, 0700); err != nil {
+			return nil, err
+		}
+	}
+
+	var template = options.Template
+	if template == nil {
+		template = NewRoleTemplate(options.UserName, options.BotName)
+	}
+
+	var bot = NewChatbotWithTemplate(model, template, options.Prompt)
+	bot.SetHistoryPolicy(options.History)
+
+	var manager = &SessionManager{
+		model:     model,
+		options:   options,
+		template:  bot,
+		templates: make(map[string]*sessionTemplate),
+		sessions:  make(map[string]*session),
+		done:      make(chan struct{}),
+	}
+
+	if options.IdleTimeout > 0 {
+		manager.wg.Add(1)
+		go manager.goEvictIdle()
+	}
+
+	return manager, nil
+}
+
+// Create creates a new session named name. The disk is checked out of my.lock, while a locked placeholder of the
+// session keeps the others using name waiting.
+func (my *SessionManager) Create(name string) error {
+	my.lock.Lock()
+	if _, ok := my.sessions[name]; ok {
+		my.lock.Unlock()
+		return ErrSessionExists
+	}
+
+	var item = &session{lastUsed: time.Now()}
+	item.lock.Lock()
+	defer item.lock.Unlock()
+	my.sessions[name] = item
+	my.lock.Unlock()
+
+	if my.existsOnDisk(name) {
+		my.lock.Lock()
+		if my.sessions[name] == item {
+			delete(my.sessions, name)
+		}
+		my.lock.Unlock()
+
+		item.evicted = true
+		return ErrSessionExists
+	}
+
+	item.bot = my.template.fork()
+	return nil
+}
+
+// Process runs a turn of the session named name, the session is created if it does not exist
+func (my *SessionManager) Process(name string, message string) (string, error) {
+	var output string
+	var err = my.with(name, true, func(bot *Chatbot) error {
+		var err error
+		output, err = bot.ProcessWithContext(context.Background(), message)
+		return err
+	})
+
+	return output, err
+}
+
+// History returns the turns of the session named name
+func (my *SessionManager) History(name string) ([]Message, error) {
+	var history []Message
+	var err = my.with(name, false, func(bot *Chatbot) error {
+		history = bot.History()
+		return nil
+	})
+
+	return history, err
+}
+
+// SetSampling changes the sampling parameters of the session named name
+func (my *SessionManager) SetSampling(name string, sampling SamplingOptions) error {
+	return my.with(name, false, func(bot *Chatbot) error {
+		bot.SetSampling(sampling)
+		return nil
+	})
+}
+
+// SetAdapter switches the session named name to the adapter registered on the model by ChatModel.AddAdapter, ""
+// switches back to the model itself. The session restarts from the prompt, since its state was built by another
+// adapter, but keeps its sampling parameters. The session is created if it does not exist. An evicted session
+// fails to load with ErrAdapterNotFound while its adapter is removed, and stays on disk until the adapter is added
+// again or the session is deleted.
+func (my *SessionManager) SetAdapter(name string, adapter string) error {
+	return my.withSession(name, true, func(item *session) error {
+		if item.adapter == adapter {
+			return nil
+		}
+
+		var template, err = my.templateOf(adapter)
+		if err != nil {
+			return err
+		}
+
+		var bot = template.fork()
+		bot.sampling = item.bot.sampling
+		item.bot, item.adapter = bot, adapter
+		return nil
+	})
+}
+
+// Adapter returns the adapter of the session named name, "" for none
+func (my *SessionManager) Adapter(name string) (string, error) {
+	var adapter string
+	var err = my.withSession(name, false, func(item *session) error {
+		adapter = item.adapter
+		return nil
+	})
+
+	return adapter, err
+}
+
+// Reset restarts the session named name from the prompt
+func (my *SessionManager) Reset(name string) error {
+	return my.with(name, false, func(bot *Chatbot) error {
+		bot.Reset()
+		return nil
+	})
+}
+
+// Delete removes the session named name from memory and disk
+func (my *SessionManager) Delete(name string) error {
+	my.lock.Lock()
+	var item, inMemory = my.sessions[name]
+	delete(my.sessions, name)
+	my.lock.Unlock()
+
+	if inMemory {
+		// wait for the running turn
+		item.lock.Lock()
+		item.evicted = true
+		item.lock.Unlock()
+	}
+
+	var err = my.removeFromDisk(name)
+	if !inMemory && errors.Is(err, os.ErrNotExist) {
+		return ErrSessionNotFound
+	}
+
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+// List returns the names of all sessions, in memory and on disk, sorted
+func (my *SessionManager) List() ([]string, error) {
+	my.lock.Lock()
+	var names = make([]string, 0, len(my.sessions))
+	for name := range my.sessions {
+		names = append(names, name)
+	}
+	my.lock.Unlock()
+
+	if my.options.Dir != "" {
+		var entries, err = os.ReadDir(my.options.Dir)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			var fileName = entry.Name()
+			if !strings.HasSuffix(fileName, sessionFileExt) {
+				continue
+			}
+
+			if name, err := url.PathUnescape(strings.TrimSuffix(fileName, sessionFileExt)); err == nil {
+				names = append(names, name)
+			}
+		}
+	}
+
+	slices.Sort(names)
+	return slices.Compact(names), nil
+}
+
+// Evict moves the session named name to disk, busy sessions are skipped
+func (my *SessionManager) Evict(name string) error {
+	my.lock.Lock()
+	var item, ok = my.sessions[name]
+	my.lock.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	var _, err = my.evict(name, item, 0)
+	return err
+}
+
+// EvictIdle moves the sessions idle longer than idle to disk, and returns the count of evicted sessions
+func (my *SessionManager) EvictIdle(idle time.Duration) int {
+	var count = 0
+	for name, item := range my.inMemory() {
+		if evicted, err := my.evict(name, item, idle); evicted && err == nil {
+			count++
+		}
+	}
+
+	return count
+}
+
+// Close stops the idle eviction, and evicts all sessions to disk when Dir is set
+func (my *SessionManager) Close() error {
+	select {
+	case <-my.done:
+		return nil
+	default:
+		close(my.done)
+	}
+
+	my.wg.Wait()
+	if my.options.Dir == "" {
+		return nil
+	}
+
+	var err error
+	for name, item := range my.inMemory() {
+		if _, err2 := my.evict(name, item, 0); err2 != nil && err == nil {
+			err = err2
+		}
+	}
+
+	return err
+}
+
+func (my *SessionManager) goEvictIdle() {
+	defer my.wg.Done()
+
+	var ticker = time.NewTicker(max(my.options.IdleTimeout/2, time.Second))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			my.EvictIdle(my.options.IdleTimeout)
+		case <-my.done:
+			return
+		}
+	}
+}
+
+// with runs handler under the lock of the session named name, loading it from disk when evicted
+func (my *SessionManager) with(name string, create bool, handler func(bot *Chatbot) error) error {
+	return my.withSession(name, create, func(item *session) error {
+		return handler(item.bot)
+	})
+}
+
+func (my *SessionManager) withSession(name string, create bool, handler func(item *session) error) error {
+	for {
+		var item, err = my.acquire(name, create)
+		if err != nil {
+			return err
+		}
+
+		item.lock.Lock()
+		if item.evicted {
+			// evicted or deleted between acquire and lock, look it up again
+			item.lock.Unlock()
+			continue
+		}
+
+		err = handler(item)
+		item.lastUsed = time.Now()
+		item.lock.Unlock()
+		return err
+	}
+}
+
+// acquire returns the session named name, loading it from disk when evicted. The file is read out of my.lock, while
+// a locked placeholder of the session keeps the others using name waiting.
+func (my *SessionManager) acquire(name string, create bool) (*session, error) {
+	my.lock.Lock()
+	if item, ok := my.sessions[name]; ok {
+		my.lock.Unlock()
+		return item, nil
+	}
+
+	var item = &session{lastUsed: time.Now()}
+	item.lock.Lock()
+	defer item.lock.Unlock()
+	my.sessions[name] = item
+	my.lock.Unlock()
+
+	var err = my.load(name, item)
+	if errors.Is(err, os.ErrNotExist) && create {
+		item.bot, err = my.template.fork(), nil
+	}
+
+	if err != nil {
+		my.lock.Lock()
+		if my.sessions[name] == item {
+			delete(my.sessions, name)
+		}
+		my.lock.Unlock()
+
+		item.evicted = true
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrSessionNotFound
+		}
+		return nil, err
+	}
+
+	return item, nil
+}
+
+// inMemory returns a copy of the sessions in memory, so that they are saved out of my.lock
+func (my *SessionManager) inMemory() map[string]*session {
+	my.lock.Lock()
+	defer my.lock.Unlock()
+	return maps.Clone(my.sessions)
+}
+
+// templateOf returns the template of adapter, evaluating the prompt on the model of adapter the first time. The
+// template is dropped when the adapter is removed, and made again for the model of an adapter added again. The prompt
+// is evaluated out of my.lock, the sessions wanting the same template wait for it.
+func (my *SessionManager) templateOf(adapter string) (*Chatbot, error) {
+	if adapter == "" {
+		return my.template, nil
+	}
+
+	var model, err = my.model.WithAdapter(adapter)
+
+	my.lock.Lock()
+	var template = my.templates[adapter]
+	if err != nil || template != nil && template.model != model {
+		delete(my.templates, adapter)
+		template = nil
+	}
+
+	if err == nil && template == nil {
+		template = &sessionTemplate{model: model}
+		my.templates[adapter] = template
+	}
+	my.lock.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	template.once.Do(func() {
+		template.bot = NewChatbotWithTemplate(model, my.template.template, my.options.Prompt)
+		template.bot.SetHistoryPolicy(my.options.History)
+	})
+
+	return template.bot, nil
+}
+
+// evict saves the session when it has been idle for idle, and removes it from memory. Busy sessions and the ones
+// which have left memory already are skipped, the returned bool tells whether the session is evicted.
+func (my *SessionManager) evict(name string, item *session, idle time.Duration) (bool, error) {
+	if my.options.Dir == "" {
+		return false, nil
+	}
+
+	if !item.lock.TryLock() {
+		return false, nil
+	}
+	defer item.lock.Unlock()
+
+	if item.evicted || time.Since(item.lastUsed) < idle {
+		return false, nil
+	}
+
+	// the session stays in my.sessions while it is saved, the others using it wait on its lock and then load it back
+	if err := my.save(name, item); err != nil {
+		return false, err
+	}
+
+	item.evicted = true
+	my.lock.Lock()
+	if my.sessions[name] == item {
+		delete(my.sessions, name)
+	}
+	my.lock.Unlock()
+	return true, nil
+}
+
+func (my *SessionManager) sessionPath(name string) string {
+	return filepath.Join(my.options.Dir, url.PathEscape(name)+sessionFileExt)
+}
+
+func (my *SessionManager) existsOnDisk(name string) bool {
+	if my.options.Dir == "" {
+		return false
+	}
+
+	var _, err = os.Stat(my.sessionPath(name))
+	return err == nil
+}
+
+func (my *SessionManager) removeFromDisk(name string) error {
+	if my.options.Dir == "" {
+		return os.ErrNotExist
+	}
+
+	return os.Remove(my.sessionPath(name))
+}
+
+func (my *SessionManager) save(name string, item *session) error {
+	var bot = item.bot
+	var snapshot = sessionSnapshot{State: bot.state, History: bot.history, Sampling: bot.sampling, Adapter: item.adapter}
+	if my.options.Encoding != StateFP32 {
+		var encoded, err = bot.model.EncodeState(bot.state, my.options.Encoding)
+		if err != nil {
+			return err
+		}
+		snapshot.State, snapshot.Encoded = nil, encoded
+	}
+
+	var path = my.sessionPath(name)
+	var temp = path + ".tmp"
+	var file, err = os.Create(temp)
+	if err != nil {
+		return err
+	}
+
+	if err = gob.NewEncoder(file).Encode(&snapshot); err != nil {
+		_ = file.Close()
+		_ = os.Remove(temp)
+		return err
+	}
+
+	if err = file.Close(); err != nil {
+		_ = os.Remove(temp)
+		return err
+	}
+
+	return os.Rename(temp, path)
+}
+
+// load reads an evicted session back into item, and removes its file. os.ErrNotExist means there is no such
+// session. The caller holds the lock of item.
+func (my *SessionManager) load(name string, item *session) error {
+	if my.options.Dir == "" {
+		return os.ErrNotExist
+	}
+
+	var path = my.sessionPath(name)
+	var file, err = os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	var snapshot sessionSnapshot
+	err = gob.NewDecoder(file).Decode(&snapshot)
+	_ = file.Close()
+	if err != nil {
+		return err
+	}
+
+	template, err := my.templateOf(snapshot.Adapter)
+	if err != nil {
+		return fmt.Errorf("the session %q was saved with the adapter %q: %w", name, snapshot.Adapter, err)
+	}
+
+	var bot = template.fork()
+	bot.state = snapshot.State
+	if snapshot.Encoded != nil {
+		if bot.state, err = bot.model.DecodeState(snapshot.Encoded); err != nil {
+			return err
+		}
+	}
+	bot.history = snapshot.History
+	bot.sampling = snapshot.Sampling
+
+	item.bot, item.adapter = bot, snapshot.Adapter
+	return os.Remove(path)
+}
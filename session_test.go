@@ -0,0 +1,115 @@
+package rwkv
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+)
+
+/********************************************************************
+created:    2026-10-18
+author:     lixianmin
+
+Copyright (C) - All Rights Reserved
+*********************************************************************/
+
+func TestSessionManager(t *testing.T) {
+	var model = newTinyChatModel(t)
+	var manager, err = NewSessionManager(model, SessionManagerOptions{
+		UserName: "User",
+		BotName:  "Assistant",
+		Prompt:   "You are a helpful assistant.\n\n",
+		Dir:      t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer manager.Close()
+
+	var wg sync.WaitGroup
+	for _, name := range []string{"alice", "bob", "carol/../x"} {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			if _, err := manager.Process(name, "hello"); err != nil {
+				t.Error(err)
+			}
+		}(name)
+	}
+	wg.Wait()
+
+	assert(t, errors.Is(manager.Create("alice"), ErrSessionExists))
+	assert(t, manager.SetSampling("bob", SamplingOptions{MaxTokens: 4, Temperature: 1, TopP: 1}) == nil)
+
+	var count = manager.EvictIdle(0)
+	assert(t, count == 3, "all sessions should be evicted")
+
+	names, err := manager.List()
+	assert(t, err == nil && slices.Equal(names, []string{"alice", "bob", "carol/../x"}), "evicted sessions should be listed")
+
+	// bob is loaded back with its history and sampling
+	if _, err = manager.Process("bob", "how are you?"); err != nil {
+		t.Fatal(err)
+	}
+
+	history, _ := manager.History("bob")
+	assert(t, len(history) == 4 && history[0].Content == "hello" && history[2].Content == "how are you?")
+
+	assert(t, manager.Delete("alice") == nil)
+	assert(t, errors.Is(manager.Delete("alice"), ErrSessionNotFound))
+	_, err = manager.History("alice")
+	assert(t, errors.Is(err, ErrSessionNotFound))
+
+	assert(t, manager.Reset("bob") == nil)
+	history, _ = manager.History("bob")
+	assert(t, len(history) == 0)
+}
+
+func TestSessionManagerEvictWhileProcessing(t *testing.T) {
+	var model = newTinyChatModel(t)
+	var manager, err = NewSessionManager(model, SessionManagerOptions{UserName: "User", BotName: "Assistant",
+		Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer manager.Close()
+
+	// the sessions are saved and loaded back out of the lock of the manager while their turns go on
+	var names = []string{"alice", "bob", "carol"}
+	var done = make(chan struct{})
+	var evictions sync.WaitGroup
+	evictions.Add(1)
+	go func() {
+		defer evictions.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				manager.EvictIdle(0)
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for _, name := range names {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			for i := 0; i < 3; i++ {
+				if _, err := manager.Process(name, "hello"); err != nil {
+					t.Error(err)
+				}
+			}
+		}(name)
+	}
+	wg.Wait()
+	close(done)
+	evictions.Wait()
+
+	for _, name := range names {
+		var history, err = manager.History(name)
+		assert(t, err == nil && len(history) == 6, "no turn should be lost by the evictions")
+	}
+}
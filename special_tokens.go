@@ -0,0 +1,150 @@
+// Copyright (c) seasonjs. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package rwkv
+
+import (
+	"errors"
+	"strings"
+)
+
+// SpecialTokenMode tells how the text of special tokens, such as `<|endoftext|>`, is encoded
+type SpecialTokenMode uint8
+
+const (
+	EscapeSpecialTokens SpecialTokenMode = iota // encode the text of special tokens as plain bytes, this is what Encode does
+	ParseSpecialTokens                          // encode the text of special tokens as their single token
+)
+
+const EndOfTextToken = "<|endoftext|>"
+
+// SpecialTokenizer is a Tokenizer which knows its end of text token, generation stops when it is sampled
+type SpecialTokenizer interface {
+	Tokenizer
+	EOS() int
+}
+
+// eosOf returns the end of text token of tk, END_OF_TEXT for tokenizers which do not tell
+func eosOf(tk Tokenizer) int {
+	if special, ok := tk.(SpecialTokenizer); ok {
+		return special.EOS()
+	}
+
+	return END_OF_TEXT
+}
+
+// SpecialTokens is a registry of control tokens, such as end of text, padding and custom ones
+type SpecialTokens struct {
+	textToToken map[string]int
+	tokenToText map[int]string
+	eos         int
+	padding     int
+}
+
+func NewSpecialTokens() *SpecialTokens {
+	return &SpecialTokens{
+		textToToken: make(map[string]int),
+		tokenToText: make(map[int]string),
+		eos:         END_OF_TEXT,
+		padding:     END_OF_TEXT,
+	}
+}
+
+// Add registers text as the special token, the text of a token registered twice is replaced
+func (my *SpecialTokens) Add(text string, token int) error {
+	if text == "" {
+		return errors.New("the text of a special token should not be empty")
+	}
+
+	if token < 0 {
+		return errors.New("the token of a special token should not be negative")
+	}
+
+	if old, ok := my.tokenToText[token]; ok {
+		delete(my.textToToken, old)
+	}
+
+	if old, ok := my.textToToken[text]; ok {
+		delete(my.tokenToText, old)
+	}
+
+	my.textToToken[text] = token
+	my.tokenToText[token] = text
+	return nil
+}
+
+// Remove unregisters the special token of text
+func (my *SpecialTokens) Remove(text string) {
+	if token, ok := my.textToToken[text]; ok {
+		delete(my.textToToken, text)
+		delete(my.tokenToText, token)
+	}
+}
+
+// Token returns the special token of text
+func (my *SpecialTokens) Token(text string) (int, bool) {
+	var token, ok = my.textToToken[text]
+	return token, ok
+}
+
+// Text returns the text of a special token
+func (my *SpecialTokens) Text(token int) (string, bool) {
+	var text, ok = my.tokenToText[token]
+	return text, ok
+}
+
+func (my *SpecialTokens) Len() int {
+	return len(my.textToToken)
+}
+
+func (my *SpecialTokens) EOS() int {
+	return my.eos
+}
+
+func (my *SpecialTokens) SetEOS(token int) {
+	my.eos = token
+}
+
+func (my *SpecialTokens) Padding() int {
+	return my.padding
+}
+
+func (my *SpecialTokens) SetPadding(token int) {
+	my.padding = token
+}
+
+// split cuts text into plain pieces and special tokens, visit gets token=-1 for a plain piece.
+// The earliest special text wins, and the longest one when several start at the same place.
+func (my *SpecialTokens) split(text string, visit func(piece string, token int) error) error {
+	for len(text) > 0 {
+		var bestIndex, bestText = -1, ""
+		for special := range my.textToToken {
+			var index = strings.Index(text, special)
+			if index < 0 {
+				continue
+			}
+
+			if bestIndex < 0 || index < bestIndex || (index == bestIndex && len(special) > len(bestText)) {
+				bestIndex, bestText = index, special
+			}
+		}
+
+		if bestIndex < 0 {
+			return visit(text, -1)
+		}
+
+		if bestIndex > 0 {
+			if err := visit(text[:bestIndex], -1); err != nil {
+				return err
+			}
+		}
+
+		if err := visit(bestText, my.textToToken[bestText]); err != nil {
+			return err
+		}
+
+		text = text[bestIndex+len(bestText):]
+	}
+
+	return nil
+}
@@ -0,0 +1,378 @@
+package rwkv
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+)
+
+/********************************************************************
+created:    2026-10-18
+author:     lixianmin
+
+Copyright (C) - All Rights Reserved
+*********************************************************************/
+
+// StateEncoding tells how EncodeState stores the fields of a state
+type StateEncoding byte
+
+const (
+	StateFP32 StateEncoding = iota // lossless, 4 bytes per element
+	StateFP16                      // lossy, 2 bytes per element
+	StateInt8                      // lossy, 1 byte per element and a scale per field of every layer
+)
+
+var stateEncodingNames = [...]string{"FP32", "FP16", "INT8"}
+
+func (encoding StateEncoding) String() string {
+	if int(encoding) < len(stateEncodingNames) {
+		return stateEncodingNames[encoding]
+	}
+
+	return fmt.Sprintf("StateEncoding(%d)", int(encoding))
+}
+
+var (
+	ErrInvalidStateEncoding = errors.New("the state encoding is unknown")
+	ErrInvalidStateData     = errors.New("the data is not an encoded state")
+)
+
+const (
+	stateMagic      = "RWST"
+	stateVersion    = 1
+	stateHeaderSize = 4 + 4*4 // the magic, the version, n_layer, n_embed and the layer size
+	maxHalf         = 65504
+)
+
+// StateEncodingReport tells what an encoding costs, see ChatModel.MeasureStateEncoding
+type StateEncodingReport struct {
+	Encoding     StateEncoding
+	StateBytes   int     // the size of the state in float32s
+	EncodedBytes int     // the size of the encoded state
+	Steps        int     // the tokens evaluated after resuming
+	MaxLogitDiff float64 // the largest difference between the logits of the original and the resumed state
+	MeanKL       float64 // the mean KL divergence of the next token distributions, from the original to the resumed
+	TopMatch     float64 // the share of the steps whose most likely tokens are the same
+}
+
+// EncodeState stores state in encoding, together with the layout. The lossy encodings keep att_pp of RWKV v4 in
+// float32, since it holds exponents as large as 1e30, and so does StateFP16 for a field out of the half range.
+func (my StateLayout) EncodeState(state []float32, encoding StateEncoding) ([]byte, error) {
+	if encoding > StateInt8 {
+		return nil, ErrInvalidStateEncoding
+	}
+
+	if err := my.checkLength(state); err != nil {
+		return nil, err
+	}
+
+	var data = make([]byte, stateHeaderSize, stateHeaderSize+my.NLayer*(len(my.Fields())*5+my.LayerSize*4))
+	copy(data, stateMagic)
+	binary.LittleEndian.PutUint32(data[4:], stateVersion)
+	binary.LittleEndian.PutUint32(data[8:], uint32(my.NLayer))
+	binary.LittleEndian.PutUint32(data[12:], uint32(my.NEmbed))
+	binary.LittleEndian.PutUint32(data[16:], uint32(my.LayerSize))
+
+	for layer := 0; layer < my.NLayer; layer++ {
+		for _, field := range my.Fields() {
+			var values, _ = my.Field(state, layer, field)
+			var fieldEncoding = encoding
+			if field == StateAttP || encoding == StateFP16 && maxAbs(values) > maxHalf {
+				fieldEncoding = StateFP32
+			}
+
+			data = append(data, byte(fieldEncoding))
+			data = encodeField(data, values, fieldEncoding)
+		}
+	}
+
+	return data, nil
+}
+
+func encodeField(data []byte, values []float32, encoding StateEncoding) []byte {
+	switch encoding {
+	case StateFP16:
+		for _, v := range values {
+			data = binary.LittleEndian.AppendUint16(data, float32ToHalf(v))
+		}
+	case StateInt8:
+		var scale = maxAbs(values) / 127
+		data = binary.LittleEndian.AppendUint32(data, math.Float32bits(scale))
+		for _, v := range values {
+			var q int8
+			if scale != 0 {
+				q = int8(max(-127, min(127, math.Round(float64(v/scale)))))
+			}
+			data = append(data, byte(q))
+		}
+	default:
+		for _, v := range values {
+			data = binary.LittleEndian.AppendUint32(data, math.Float32bits(v))
+		}
+	}
+
+	return data
+}
+
+// DecodeState reads a state written by EncodeState, and the layout stored with it
+func DecodeState(data []byte) ([]float32, StateLayout, error) {
+	if len(data) < stateHeaderSize || string(data[:4]) != stateMagic {
+		return nil, StateLayout{}, ErrInvalidStateData
+	}
+
+	if version := binary.LittleEndian.Uint32(data[4:]); version != stateVersion {
+		return nil, StateLayout{}, fmt.Errorf("%w: version %d is not supported", ErrInvalidStateData, version)
+	}
+
+	var nLayer = int(binary.LittleEndian.Uint32(data[8:]))
+	var nEmbed = int(binary.LittleEndian.Uint32(data[12:]))
+	var layerSize = int(binary.LittleEndian.Uint32(data[16:]))
+	if nLayer*layerSize > len(data) { // every element takes a byte at least
+		return nil, StateLayout{}, fmt.Errorf("%w: the data is truncated", ErrInvalidStateData)
+	}
+
+	var layout, err = NewStateLayout(nLayer, nEmbed, nLayer*layerSize)
+	if err != nil {
+		return nil, StateLayout{}, fmt.Errorf("%w: %w", ErrInvalidStateData, err)
+	}
+
+	var state = make([]float32, layout.StateLength())
+	var offset = stateHeaderSize
+	for layer := 0; layer < nLayer; layer++ {
+		for _, field := range layout.Fields() {
+			var values, _ = layout.Field(state, layer, field)
+			if offset, err = decodeField(data, offset, values); err != nil {
+				return nil, StateLayout{}, err
+			}
+		}
+	}
+
+	if offset != len(data) {
+		return nil, StateLayout{}, fmt.Errorf("%w: %d bytes are left", ErrInvalidStateData, len(data)-offset)
+	}
+
+	return state, layout, nil
+}
+
+// decodeField fills values from the field at offset of data, and returns the offset of the next field
+func decodeField(data []byte, offset int, values []float32) (int, error) {
+	if offset >= len(data) {
+		return 0, fmt.Errorf("%w: the data is truncated", ErrInvalidStateData)
+	}
+
+	var encoding = StateEncoding(data[offset])
+	offset++
+
+	var size int
+	switch encoding {
+	case StateFP32:
+		size = 4 * len(values)
+	case StateFP16:
+		size = 2 * len(values)
+	case StateInt8:
+		size = 4 + len(values)
+	default:
+		return 0, fmt.Errorf("%w: %v", ErrInvalidStateData, encoding)
+	}
+
+	if offset+size > len(data) {
+		return 0, fmt.Errorf("%w: the data is truncated", ErrInvalidStateData)
+	}
+
+	var field = data[offset : offset+size]
+	switch encoding {
+	case StateFP32:
+		for i := range values {
+			values[i] = math.Float32frombits(binary.LittleEndian.Uint32(field[i*4:]))
+		}
+	case StateFP16:
+		for i := range values {
+			values[i] = halfToFloat32(binary.LittleEndian.Uint16(field[i*2:]))
+		}
+	case StateInt8:
+		var scale = math.Float32frombits(binary.LittleEndian.Uint32(field))
+		for i := range values {
+			values[i] = float32(int8(field[4+i])) * scale
+		}
+	}
+
+	return offset + size, nil
+}
+
+func layoutMismatchError(decoded StateLayout, want StateLayout) error {
+	return fmt.Errorf("%w: the state is of %d layers and %d embeddings, but the model is of %d and %d",
+		ErrInvalidStateLayout, decoded.NLayer, decoded.NEmbed, want.NLayer, want.NEmbed)
+}
+
+func maxAbs(values []float32) float32 {
+	var result float32
+	for _, v := range values {
+		result = max(result, float32(math.Abs(float64(v))))
+	}
+
+	return result
+}
+
+// EncodeState stores state of my in encoding, see StateLayout.EncodeState
+func (my *ChatModel) EncodeState(state []float32, encoding StateEncoding) ([]byte, error) {
+	var layout, err = my.StateLayout()
+	if err != nil {
+		return nil, err
+	}
+
+	return layout.EncodeState(state, encoding)
+}
+
+// DecodeState reads a state of my written by EncodeState, whose layout should be the one of my
+func (my *ChatModel) DecodeState(data []byte) ([]float32, error) {
+	var layout, err = my.StateLayout()
+	if err != nil {
+		return nil, err
+	}
+
+	state, decoded, err := DecodeState(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if decoded != layout {
+		return nil, layoutMismatchError(decoded, layout)
+	}
+
+	return state, nil
+}
+
+// MeasureStateEncoding encodes state, resumes from the decoded state and from state itself, and compares the logits
+// of the two while evaluating text. It tells whether a lossy encoding is good enough for a model before saving the
+// sessions with it.
+func (my *ChatModel) MeasureStateEncoding(state []float32, encoding StateEncoding,
+	text string) (StateEncodingReport, error) {
+	var data, err = my.EncodeState(state, encoding)
+	if err != nil {
+		return StateEncodingReport{}, err
+	}
+
+	resumed, err := my.DecodeState(data)
+	if err != nil {
+		return StateEncodingReport{}, err
+	}
+
+	tokens, err := my.tokenizer.Encode(text)
+	if err != nil {
+		return StateEncodingReport{}, err
+	}
+
+	if len(tokens) == 0 {
+		return StateEncodingReport{}, ErrEmptyText
+	}
+
+	var report = StateEncodingReport{Encoding: encoding, StateBytes: 4 * len(state), EncodedBytes: len(data),
+		Steps: len(tokens)}
+	var original = slices.Clone(state)
+	var _, logits = my.newState()
+	var resumedLogits = make([]float32, len(logits))
+	var matches int
+	for _, token := range tokens {
+		if err = my.evalTokens([]int{token}, original, logits); err != nil {
+			return StateEncodingReport{}, err
+		}
+
+		if err = my.evalTokens([]int{token}, resumed, resumedLogits); err != nil {
+			return StateEncodingReport{}, err
+		}
+
+		for i := range logits {
+			report.MaxLogitDiff = math.Max(report.MaxLogitDiff, math.Abs(float64(logits[i]-resumedLogits[i])))
+		}
+
+		report.MeanKL += klDivergence(logits, resumedLogits)
+		if argmax(logits) == argmax(resumedLogits) {
+			matches++
+		}
+	}
+
+	report.MeanKL /= float64(len(tokens))
+	report.TopMatch = float64(matches) / float64(len(tokens))
+	return report, nil
+}
+
+// klDivergence returns KL(p||q) of the softmax distributions p and q of two logits
+func klDivergence(p []float32, q []float32) float64 {
+	var logP, logQ = logSoftmax(p), logSoftmax(q)
+	var sum float64
+	for i := range logP {
+		sum += math.Exp(logP[i]) * (logP[i] - logQ[i])
+	}
+
+	return sum
+}
+
+func logSoftmax(logits []float32) []float64 {
+	var maxLogit = float64(slices.Max(logits))
+	var sum float64
+	for _, v := range logits {
+		sum += math.Exp(float64(v) - maxLogit)
+	}
+
+	var result = make([]float64, len(logits))
+	var logSum = maxLogit + math.Log(sum)
+	for i, v := range logits {
+		result[i] = float64(v) - logSum
+	}
+
+	return result
+}
+
+func argmax(values []float32) int {
+	var result = 0
+	for i, v := range values {
+		if v > values[result] {
+			result = i
+		}
+	}
+
+	return result
+}
+
+// Encode stores the state of the chat context in encoding, so that it resumes later by Decode. The logits are not
+// kept, since the next Predict evaluates its input first.
+func (s *RwkvState) Encode(encoding StateEncoding) ([]byte, error) {
+	var layout, err = s.rwkvModel.stateLayout()
+	if err != nil {
+		return nil, err
+	}
+
+	return layout.EncodeState(s.state, encoding)
+}
+
+// Decode replaces the state of the chat context with the one written by Encode
+func (s *RwkvState) Decode(data []byte) error {
+	var layout, err = s.rwkvModel.stateLayout()
+	if err != nil {
+		return err
+	}
+
+	state, decoded, err := DecodeState(data)
+	if err != nil {
+		return err
+	}
+
+	if decoded != layout {
+		return layoutMismatchError(decoded, layout)
+	}
+
+	s.state = state
+	return nil
+}
+
+func (m *RwkvModel) stateLayout() (StateLayout, error) {
+	if err := hasCtx(m.ctx); err != nil {
+		return StateLayout{}, err
+	}
+
+	var nLayer = int(m.cRwkv.RwkvGetNLayer(m.ctx))
+	var nEmbed = int(m.cRwkv.RwkvGetNEmbedding(m.ctx))
+	return NewStateLayout(nLayer, nEmbed, int(m.cRwkv.RwkvGetStateLength(m.ctx)))
+}
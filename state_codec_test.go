@@ -0,0 +1,135 @@
+package rwkv
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+/********************************************************************
+created:    2026-10-18
+author:     lixianmin
+
+Copyright (C) - All Rights Reserved
+*********************************************************************/
+
+func TestStateEncoding(t *testing.T) {
+	var model = newTinyChatModel(t)
+	var layout, _ = model.StateLayout()
+	var state, _ = model.EvalSequence(model.Encode("The quick brown fox jumps over the lazy dog."), nil)
+	var initial, _ = model.newState()
+
+	var sizes []int
+	for _, encoding := range []StateEncoding{StateFP32, StateFP16, StateInt8} {
+		var data, err = model.EncodeState(state, encoding)
+		assert(t, err == nil)
+		sizes = append(sizes, len(data))
+
+		decoded, err := model.DecodeState(data)
+		assert(t, err == nil && len(decoded) == len(state))
+
+		// att_pp is kept exact, the other fields are close
+		var pp, _ = layout.Field(decoded, 1, StateAttP)
+		var wantP, _ = layout.Field(state, 1, StateAttP)
+		assert(t, slices.Equal(pp, wantP), encoding.String())
+		var similarity, _ = layout.Similarity(decoded, state, StateSelection{})
+		assert(t, similarity > 0.999, encoding.String())
+		if encoding == StateFP32 {
+			assert(t, slices.Equal(decoded, state), "StateFP32 should be lossless")
+		}
+
+		// the exponents of -1e30 in a fresh state survive the lossy encodings
+		data, _ = model.EncodeState(initial, encoding)
+		decoded, _ = model.DecodeState(data)
+		assert(t, slices.Equal(decoded, initial), encoding.String())
+	}
+	// att_pp is a fifth of a RWKV v4 state
+	assert(t, sizes[1] < sizes[0]*13/20 && sizes[2] < sizes[0]*9/20, "the lossy encodings should be smaller",
+		fmt.Sprint(sizes))
+
+	var fp16, _ = model.MeasureStateEncoding(state, StateFP16, " It was a sunny day.")
+	var quantized, _ = model.MeasureStateEncoding(state, StateInt8, " It was a sunny day.")
+	var fp32, _ = model.MeasureStateEncoding(state, StateFP32, " It was a sunny day.")
+	assert(t, fp32.MaxLogitDiff == 0 && fp32.MeanKL == 0 && fp32.TopMatch == 1, "StateFP32 should not diverge")
+	assert(t, fp16.Steps > 0 && fp16.EncodedBytes < fp16.StateBytes && fp16.TopMatch == 1)
+	assert(t, fp16.MaxLogitDiff <= quantized.MaxLogitDiff && quantized.MeanKL < 1e-3, "INT8 should diverge a little more")
+
+	var data, _ = model.EncodeState(state, StateInt8)
+	_, err := model.DecodeState(data[:len(data)-1])
+	assert(t, errors.Is(err, ErrInvalidStateData))
+	_, err = model.DecodeState(append(slices.Clone(data), 0))
+	assert(t, errors.Is(err, ErrInvalidStateData))
+	_, err = model.EncodeState(state, StateEncoding(9))
+	assert(t, errors.Is(err, ErrInvalidStateEncoding))
+
+	other, _ := NewStateLayout(3, 32, 3*5*32)
+	data, _ = other.EncodeState(make([]float32, other.StateLength()), StateFP16)
+	_, err = model.DecodeState(data)
+	assert(t, errors.Is(err, ErrInvalidStateLayout))
+
+	// the heads of RWKV v5 and later are a field of their own
+	v5, _ := NewStateLayout(2, 4, 2*(2*4+4*2))
+	var heads = make([]float32, v5.StateLength())
+	for i := range heads {
+		heads[i] = float32(i) - 10
+	}
+	data, _ = v5.EncodeState(heads, StateInt8)
+	decoded, decodedLayout, err := DecodeState(data)
+	assert(t, err == nil && decodedLayout == v5)
+	for i := range heads {
+		assert(t, math.Abs(float64(decoded[i]-heads[i])) < 0.1)
+	}
+}
+
+func TestRwkvStateEncode(t *testing.T) {
+	var path = filepath.Join(t.TempDir(), "tiny.bin")
+	assert(t, writeFixtureModel(path, worldVocabSize, 32, 2, newTinyModelTensors(1, worldVocabSize, 32, 2)) == nil)
+	var model, err = NewRwkvAutoModel(RwkvOptions{MaxTokens: 8, Temperature: 1, TopP: 1, Backend: BackendGo,
+		TokenizerType: Auto})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer model.Close()
+	assert(t, model.LoadFromFile(path) == nil)
+
+	var ctx, _ = model.InitState()
+	assert(t, ctx.handelInput("hello world") == nil)
+	data, err := ctx.Encode(StateFP16)
+	assert(t, err == nil)
+
+	resumed, _ := model.InitState()
+	assert(t, resumed.Decode(data) == nil)
+	var layout, _ = model.stateLayout()
+	var similarity, _ = layout.Similarity(resumed.state, ctx.state, StateSelection{})
+	assert(t, similarity > 0.999 && !slices.Equal(resumed.state, ctx.state))
+	assert(t, errors.Is(resumed.Decode(data[:10]), ErrInvalidStateData))
+}
+
+func TestSessionStateEncoding(t *testing.T) {
+	var model = newTinyChatModel(t)
+	var dir = t.TempDir()
+	var manager, err = NewSessionManager(model, SessionManagerOptions{UserName: "User", BotName: "Assistant",
+		Prompt: "You are a helpful assistant.\n\n", Dir: dir, Encoding: StateInt8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer manager.Close()
+
+	_, err = manager.Process("alice", "hello")
+	assert(t, err == nil)
+	var state = slices.Clone(manager.sessions["alice"].bot.state)
+	assert(t, manager.EvictIdle(0) == 1)
+
+	var info, _ = os.Stat(manager.sessionPath("alice"))
+	assert(t, info != nil && info.Size() < int64(4*len(state)), "the state should be stored in INT8")
+
+	history, err := manager.History("alice")
+	assert(t, err == nil && len(history) == 2)
+	var layout, _ = model.StateLayout()
+	var similarity, _ = layout.Similarity(manager.sessions["alice"].bot.state, state, StateSelection{})
+	assert(t, similarity > 0.999, "the state should be loaded back")
+}
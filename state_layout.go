@@ -0,0 +1,107 @@
+package rwkv
+
+import (
+	"errors"
+	"fmt"
+)
+
+/********************************************************************
+created:    2026-10-18
+author:     lixianmin
+
+Copyright (C) - All Rights Reserved
+*********************************************************************/
+
+// StateField is a vector in the state of every layer
+type StateField int
+
+const (
+	StateFfnX     StateField = iota // the last input of the channel mixing
+	StateAttX                       // the last input of the time mixing
+	StateAttA                       // the numerator of the wkv average, RWKV v4 only
+	StateAttB                       // the denominator of the wkv average, RWKV v4 only
+	StateAttP                       // the exponent shared by StateAttA and StateAttB, RWKV v4 only
+	StateAttHeads                   // the n_embed/head_size matrices of the wkv heads, RWKV v5 and later only
+)
+
+var stateFieldNames = [...]string{"ffn_xx", "att_xx", "att_aa", "att_bb", "att_pp", "att_heads"}
+
+func (field StateField) String() string {
+	if field >= 0 && int(field) < len(stateFieldNames) {
+		return stateFieldNames[field]
+	}
+
+	return fmt.Sprintf("StateField(%d)", int(field))
+}
+
+var (
+	ErrInvalidStateLayout = errors.New("the state length does not match a known layout")
+	ErrInvalidStateField  = errors.New("the state has no such field")
+)
+
+// StateLayout tells where the fields of every layer are in a state of rwkv.cpp. Every layer takes LayerSize
+// elements: ffn_xx and att_xx of NEmbed elements come first, followed by att_aa, att_bb and att_pp for RWKV v4, or
+// by the wkv heads for RWKV v5 and later.
+type StateLayout struct {
+	NLayer    int
+	NEmbed    int
+	LayerSize int
+}
+
+// NewStateLayout infers the layout from the size of a model and the length of its state
+func NewStateLayout(nLayer int, nEmbed int, stateLength int) (StateLayout, error) {
+	if nLayer <= 0 || nEmbed <= 0 || stateLength%nLayer != 0 {
+		return StateLayout{}, ErrInvalidStateLayout
+	}
+
+	var layout = StateLayout{NLayer: nLayer, NEmbed: nEmbed, LayerSize: stateLength / nLayer}
+	if layout.LayerSize != 5*nEmbed && (layout.LayerSize <= 2*nEmbed || layout.LayerSize%nEmbed != 0) {
+		return StateLayout{}, ErrInvalidStateLayout
+	}
+
+	return layout, nil
+}
+
+// IsV4 returns true for the layout of RWKV v4, which has att_aa, att_bb and att_pp instead of wkv heads
+func (my StateLayout) IsV4() bool {
+	return my.LayerSize == 5*my.NEmbed
+}
+
+// StateLength returns the element count of a state
+func (my StateLayout) StateLength() int {
+	return my.NLayer * my.LayerSize
+}
+
+// Layer returns the elements of layer in state, a negative layer counts from the last one
+func (my StateLayout) Layer(state []float32, layer int) ([]float32, error) {
+	if layer < 0 {
+		layer += my.NLayer
+	}
+
+	if layer < 0 || layer >= my.NLayer || len(state) != my.StateLength() {
+		return nil, ErrInvalidStateField
+	}
+
+	return state[layer*my.LayerSize : (layer+1)*my.LayerSize], nil
+}
+
+// Field returns field of layer in state, sharing the memory of state. A negative layer counts from the last one.
+func (my StateLayout) Field(state []float32, layer int, field StateField) ([]float32, error) {
+	var elements, err = my.Layer(state, layer)
+	if err != nil {
+		return nil, err
+	}
+
+	var n = my.NEmbed
+	switch {
+	case field == StateFfnX || field == StateAttX:
+	case field >= StateAttA && field <= StateAttP && my.IsV4():
+	case field == StateAttHeads && !my.IsV4():
+		return elements[2*n:], nil
+	default:
+		return nil, ErrInvalidStateField
+	}
+
+	var start = int(field) * n
+	return elements[start : start+n], nil
+}
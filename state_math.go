@@ -0,0 +1,351 @@
+package rwkv
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"text/tabwriter"
+)
+
+/********************************************************************
+created:    2026-10-18
+author:     lixianmin
+
+Copyright (C) - All Rights Reserved
+*********************************************************************/
+
+var ErrInvalidStateWeights = errors.New("the weights do not match the states")
+
+// StateSelection picks the layers and the fields an operation works on, the zero value picks all of them
+type StateSelection struct {
+	Layers []int        // nil for all layers, a negative one counts from the last layer
+	Fields []StateField // nil for all fields of the layout, mixing any of att_aa, att_bb and att_pp mixes all three
+}
+
+// StateFieldDiff compares a field of a layer in two states
+type StateFieldDiff struct {
+	Layer      int
+	Field      StateField
+	NormA      float64
+	NormB      float64
+	Distance   float64 // the L2 norm of a-b
+	Similarity float64 // the cosine similarity of a and b, 1 for two zero vectors
+}
+
+// StateDiff is the result of StateLayout.Diff, by layer and then by field
+type StateDiff []StateFieldDiff
+
+// StateFieldNorm is the L2 norm of a field of a layer
+type StateFieldNorm struct {
+	Layer int
+	Field StateField
+	Norm  float64
+}
+
+// StateNorms is the result of StateLayout.Norms, by layer and then by field
+type StateNorms []StateFieldNorm
+
+// Fields returns the fields of every layer, in the order of the state
+func (my StateLayout) Fields() []StateField {
+	if my.IsV4() {
+		return []StateField{StateFfnX, StateAttX, StateAttA, StateAttB, StateAttP}
+	}
+
+	return []StateField{StateFfnX, StateAttX, StateAttHeads}
+}
+
+// Interpolate returns (1-t)·a + t·b over the selected parts, and a elsewhere. A t out of [0, 1] extrapolates, such
+// as moving a further away from b.
+func (my StateLayout) Interpolate(a []float32, b []float32, t float32, selection StateSelection) ([]float32, error) {
+	return my.mix([][]float32{a, b}, []float32{1 - t, t}, selection)
+}
+
+// Average returns the weighted average of states over the selected parts, and the first state elsewhere. nil weights
+// weigh the states equally, otherwise they are normalized to sum to 1.
+func (my StateLayout) Average(states [][]float32, weights []float32, selection StateSelection) ([]float32, error) {
+	if weights == nil {
+		weights = make([]float32, len(states))
+		for i := range weights {
+			weights[i] = 1
+		}
+	}
+
+	var sum float32
+	for _, w := range weights {
+		sum += w
+	}
+
+	if len(states) == 0 || len(weights) != len(states) || sum == 0 {
+		return nil, ErrInvalidStateWeights
+	}
+
+	var normalized = make([]float32, len(weights))
+	for i, w := range weights {
+		normalized[i] = w / sum
+	}
+
+	return my.mix(states, normalized, selection)
+}
+
+// mix returns the weighted sum of states over the selected parts. att_aa and att_bb of RWKV v4 are scaled by
+// exp(att_pp), so the three are summed together as exp(att_pp)·att_aa and exp(att_pp)·att_bb.
+func (my StateLayout) mix(states [][]float32, weights []float32, selection StateSelection) ([]float32, error) {
+	for _, state := range states {
+		if err := my.checkLength(state); err != nil {
+			return nil, err
+		}
+	}
+
+	var layers, fields, err = my.selected(selection)
+	if err != nil {
+		return nil, err
+	}
+
+	var result = slices.Clone(states[0])
+	var sources = make([][]float32, len(states))
+	for _, layer := range layers {
+		var wkv = false
+		for _, field := range fields {
+			if field >= StateAttA && field <= StateAttP {
+				wkv = true
+				continue
+			}
+
+			var target, _ = my.Field(result, layer, field)
+			for i, state := range states {
+				sources[i], _ = my.Field(state, layer, field)
+			}
+			mixLinear(target, sources, weights)
+		}
+
+		if wkv {
+			my.mixWkv(result, states, weights, layer)
+		}
+	}
+
+	return result, nil
+}
+
+func mixLinear(target []float32, sources [][]float32, weights []float32) {
+	for j := range target {
+		var sum float64
+		for i, source := range sources {
+			sum += float64(weights[i]) * float64(source[j])
+		}
+		target[j] = float32(sum)
+	}
+}
+
+// mixWkv sums att_aa and att_bb of layer with their exponents, taking the largest exponent of the weighted states
+func (my StateLayout) mixWkv(result []float32, states [][]float32, weights []float32, layer int) {
+	var aa, _ = my.Field(result, layer, StateAttA)
+	var bb, _ = my.Field(result, layer, StateAttB)
+	var pp, _ = my.Field(result, layer, StateAttP)
+
+	var fields = make([][3][]float32, len(states))
+	for i, state := range states {
+		fields[i][0], _ = my.Field(state, layer, StateAttA)
+		fields[i][1], _ = my.Field(state, layer, StateAttB)
+		fields[i][2], _ = my.Field(state, layer, StateAttP)
+	}
+
+	for j := range pp {
+		var maxP = math.Inf(-1)
+		for i := range states {
+			if weights[i] != 0 {
+				maxP = math.Max(maxP, float64(fields[i][2][j]))
+			}
+		}
+
+		var sumA, sumB float64
+		for i := range states {
+			if weights[i] != 0 {
+				var scale = float64(weights[i]) * math.Exp(float64(fields[i][2][j])-maxP)
+				sumA += scale * float64(fields[i][0][j])
+				sumB += scale * float64(fields[i][1][j])
+			}
+		}
+
+		aa[j], bb[j], pp[j] = float32(sumA), float32(sumB), float32(maxP)
+	}
+}
+
+// Diff compares a and b field by field, which tells the layers and the fields a conversation has drifted in. att_pp
+// of RWKV v4 is an exponent, whose distance is in the log scale.
+func (my StateLayout) Diff(a []float32, b []float32) (StateDiff, error) {
+	if err := my.checkLength(a); err != nil {
+		return nil, err
+	}
+
+	if err := my.checkLength(b); err != nil {
+		return nil, err
+	}
+
+	var diff = make(StateDiff, 0, my.NLayer*len(my.Fields()))
+	for layer := 0; layer < my.NLayer; layer++ {
+		for _, field := range my.Fields() {
+			var x, _ = my.Field(a, layer, field)
+			var y, _ = my.Field(b, layer, field)
+			var dot, normA, normB, distance = compareVectors(x, y)
+			diff = append(diff, StateFieldDiff{Layer: layer, Field: field, NormA: normA, NormB: normB,
+				Distance: distance, Similarity: cosine(dot, normA, normB)})
+		}
+	}
+
+	return diff, nil
+}
+
+// Similarity returns the cosine similarity of a and b over the selected parts. The exponents of att_pp in RWKV v4
+// outweigh the other fields, select StateFfnX and StateAttX to compare what the layers hold.
+func (my StateLayout) Similarity(a []float32, b []float32, selection StateSelection) (float64, error) {
+	if err := my.checkLength(a); err != nil {
+		return 0, err
+	}
+
+	if err := my.checkLength(b); err != nil {
+		return 0, err
+	}
+
+	var layers, fields, err = my.selected(selection)
+	if err != nil {
+		return 0, err
+	}
+
+	var dot, squareA, squareB float64
+	for _, layer := range layers {
+		for _, field := range fields {
+			var x, _ = my.Field(a, layer, field)
+			var y, _ = my.Field(b, layer, field)
+			var d, normA, normB, _ = compareVectors(x, y)
+			dot += d
+			squareA += normA * normA
+			squareB += normB * normB
+		}
+	}
+
+	return cosine(dot, math.Sqrt(squareA), math.Sqrt(squareB)), nil
+}
+
+// Norms returns the L2 norm of every field of every layer in state
+func (my StateLayout) Norms(state []float32) (StateNorms, error) {
+	if err := my.checkLength(state); err != nil {
+		return nil, err
+	}
+
+	var norms = make(StateNorms, 0, my.NLayer*len(my.Fields()))
+	for layer := 0; layer < my.NLayer; layer++ {
+		for _, field := range my.Fields() {
+			var x, _ = my.Field(state, layer, field)
+			var _, norm, _, _ = compareVectors(x, x)
+			norms = append(norms, StateFieldNorm{Layer: layer, Field: field, Norm: norm})
+		}
+	}
+
+	return norms, nil
+}
+
+func (my StateLayout) checkLength(state []float32) error {
+	if len(state) != my.StateLength() {
+		return stateLengthError(len(state), my.StateLength())
+	}
+
+	return nil
+}
+
+// selected returns the layers and the fields of selection, checked against the layout
+func (my StateLayout) selected(selection StateSelection) ([]int, []StateField, error) {
+	var layers = make([]int, 0, my.NLayer)
+	if selection.Layers == nil {
+		for layer := 0; layer < my.NLayer; layer++ {
+			layers = append(layers, layer)
+		}
+	}
+
+	for _, layer := range selection.Layers {
+		if layer < 0 {
+			layer += my.NLayer
+		}
+
+		if layer < 0 || layer >= my.NLayer {
+			return nil, nil, fmt.Errorf("%w: layer %d", ErrInvalidStateField, layer)
+		}
+		layers = append(layers, layer)
+	}
+
+	var fields = selection.Fields
+	if fields == nil {
+		fields = my.Fields()
+	}
+
+	for _, field := range fields {
+		if !slices.Contains(my.Fields(), field) {
+			return nil, nil, fmt.Errorf("%w: %s", ErrInvalidStateField, field)
+		}
+	}
+
+	return layers, fields, nil
+}
+
+// compareVectors returns the dot product and the norms of x and y, and the norm of x-y
+func compareVectors(x []float32, y []float32) (float64, float64, float64, float64) {
+	var dot, squareX, squareY, squareD float64
+	for i := range x {
+		var a, b = float64(x[i]), float64(y[i])
+		dot += a * b
+		squareX += a * a
+		squareY += b * b
+		squareD += (a - b) * (a - b)
+	}
+
+	return dot, math.Sqrt(squareX), math.Sqrt(squareY), math.Sqrt(squareD)
+}
+
+func cosine(dot float64, normA float64, normB float64) float64 {
+	if normA == 0 && normB == 0 {
+		return 1
+	}
+
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+
+	return dot / (normA * normB)
+}
+
+// String returns a table of the fields, a row per field of every layer
+func (my StateDiff) String() string {
+	var builder strings.Builder
+	var writer = tabwriter.NewWriter(&builder, 0, 8, 2, ' ', 0)
+	_, _ = fmt.Fprintln(writer, "layer\tfield\t|a|\t|b|\t|a-b|\tcosine")
+	for _, item := range my {
+		_, _ = fmt.Fprintf(writer, "%d\t%s\t%.4g\t%.4g\t%.4g\t%.4f\n", item.Layer, item.Field, item.NormA, item.NormB,
+			item.Distance, item.Similarity)
+	}
+	_ = writer.Flush()
+	return builder.String()
+}
+
+// String returns a table of the norms, a row per layer and a column per field
+func (my StateNorms) String() string {
+	var builder strings.Builder
+	var writer = tabwriter.NewWriter(&builder, 0, 8, 2, ' ', 0)
+	_, _ = fmt.Fprint(writer, "layer")
+	for i, item := range my {
+		if i > 0 && item.Layer == my[0].Layer+1 {
+			break
+		}
+		_, _ = fmt.Fprintf(writer, "\t%s", item.Field)
+	}
+
+	for i, item := range my {
+		if i == 0 || item.Layer != my[i-1].Layer {
+			_, _ = fmt.Fprintf(writer, "\n%d", item.Layer)
+		}
+		_, _ = fmt.Fprintf(writer, "\t%.4g", item.Norm)
+	}
+	_, _ = fmt.Fprintln(writer)
+	_ = writer.Flush()
+	return builder.String()
+}
@@ -0,0 +1,117 @@
+package rwkv
+
+import (
+	"errors"
+	"math"
+	"slices"
+	"strings"
+	"testing"
+)
+
+/********************************************************************
+created:    2026-10-18
+author:     lixianmin
+
+Copyright (C) - All Rights Reserved
+*********************************************************************/
+
+func TestStateArithmetic(t *testing.T) {
+	var layout, _ = NewStateLayout(2, 4, 2*5*4)
+	var a, b = make([]float32, layout.StateLength()), make([]float32, layout.StateLength())
+	for i := range a {
+		a[i], b[i] = float32(i), float32(2*i)
+	}
+
+	// a starts like RwkvInitState: nothing averaged, with the exponent at -1e30
+	for layer := 0; layer < layout.NLayer; layer++ {
+		var aa, _ = layout.Field(a, layer, StateAttA)
+		var bb, _ = layout.Field(a, layer, StateAttB)
+		var pp, _ = layout.Field(a, layer, StateAttP)
+		for j := range pp {
+			aa[j], bb[j], pp[j] = 0, 0, -1e30
+		}
+	}
+
+	var mixed, err = layout.Interpolate(a, b, 0.5, StateSelection{})
+	assert(t, err == nil)
+	var ffn, _ = layout.Field(mixed, 1, StateFfnX)
+	var want, _ = layout.Field(a, 1, StateFfnX)
+	assert(t, ffn[1] == 1.5*want[1], "the fields should be mixed linearly")
+
+	var aa, _ = layout.Field(mixed, 0, StateAttA)
+	var pp, _ = layout.Field(mixed, 0, StateAttP)
+	var wantA, _ = layout.Field(b, 0, StateAttA)
+	var wantP, _ = layout.Field(b, 0, StateAttP)
+	assert(t, aa[2] == wantA[2]/2 && pp[2] == wantP[2], "the wkv average should be mixed with its exponent")
+
+	same, _ := layout.Interpolate(a, b, 1, StateSelection{})
+	assert(t, slices.Equal(same, b))
+	same, _ = layout.Average([][]float32{b, b}, nil, StateSelection{})
+	assert(t, slices.Equal(same, b), "the average of equal states should be the state")
+
+	// only the last layer of ffn_xx is mixed, the rest comes from a
+	mixed, _ = layout.Interpolate(a, b, 1, StateSelection{Layers: []int{-1}, Fields: []StateField{StateFfnX}})
+	ffn, _ = layout.Field(mixed, -1, StateFfnX)
+	want, _ = layout.Field(b, -1, StateFfnX)
+	assert(t, slices.Equal(ffn, want))
+	var first, _ = layout.Layer(mixed, 0)
+	var firstA, _ = layout.Layer(a, 0)
+	assert(t, slices.Equal(first, firstA))
+
+	weighted, _ := layout.Average([][]float32{a, b}, []float32{3, 1}, StateSelection{Fields: []StateField{StateAttX}})
+	var att, _ = layout.Field(weighted, 0, StateAttX)
+	var attA, _ = layout.Field(a, 0, StateAttX)
+	assert(t, math.Abs(float64(att[3]-1.25*attA[3])) < 1e-5, "the weights should be normalized")
+
+	_, err = layout.Average([][]float32{a, b}, []float32{1}, StateSelection{})
+	assert(t, errors.Is(err, ErrInvalidStateWeights))
+	_, err = layout.Interpolate(a, b[1:], 0.5, StateSelection{})
+	assert(t, errors.Is(err, ErrInvalidStateLayout))
+	_, err = layout.Interpolate(a, b, 0.5, StateSelection{Fields: []StateField{StateAttHeads}})
+	assert(t, errors.Is(err, ErrInvalidStateField))
+	_, err = layout.Interpolate(a, b, 0.5, StateSelection{Layers: []int{2}})
+	assert(t, errors.Is(err, ErrInvalidStateField))
+
+	diff, err := layout.Diff(b, b)
+	assert(t, err == nil && len(diff) == 2*5 && diff[3].Layer == 0 && diff[3].Field == StateAttB)
+	for _, item := range diff {
+		assert(t, item.Distance == 0 && math.Abs(item.Similarity-1) < 1e-12)
+	}
+
+	diff, _ = layout.Diff(a, b)
+	assert(t, diff[5].Field == StateFfnX && math.Abs(diff[5].Similarity-1) < 1e-9, "b is 2a in ffn_xx")
+	assert(t, math.Abs(diff[5].Distance-diff[5].NormA) < 1e-6)
+	assert(t, strings.Contains(diff.String(), "att_pp"))
+
+	var selection = StateSelection{Fields: []StateField{StateFfnX, StateAttX}}
+	similarity, _ := layout.Similarity(a, b, selection)
+	assert(t, math.Abs(similarity-1) < 1e-9)
+	similarity, _ = layout.Similarity(a, make([]float32, len(a)), selection)
+	assert(t, similarity == 0)
+
+	norms, err := layout.Norms(b)
+	assert(t, err == nil && len(norms) == 2*5 && norms[5].Layer == 1)
+	assert(t, math.Abs(norms[0].Norm-math.Sqrt(4*(1+4+9))) < 1e-6)
+	var lines = strings.Split(strings.TrimSpace(norms.String()), "\n")
+	assert(t, len(lines) == 3 && strings.Contains(lines[0], "ffn_xx"), norms.String())
+}
+
+func TestStateArithmeticOnModel(t *testing.T) {
+	var model = newTinyChatModel(t)
+	var layout, _ = model.StateLayout()
+
+	var formal, _ = model.EvalSequence(model.Encode("Dear Sir, I am writing to inform you"), nil)
+	var casual, _ = model.EvalSequence(model.Encode("hey dude what's up lol"), nil)
+	var mixed, err = layout.Interpolate(formal, casual, 0.5, StateSelection{})
+	assert(t, err == nil)
+
+	// the mixed state is between the two, and still evaluates
+	var toFormal, _ = layout.Similarity(mixed, formal, StateSelection{Fields: []StateField{StateFfnX, StateAttX}})
+	var between, _ = layout.Similarity(casual, formal, StateSelection{Fields: []StateField{StateFfnX, StateAttX}})
+	assert(t, toFormal > between, "the mixed state should be closer to formal than casual is")
+
+	var _, logits = model.EvalSequence(model.Encode(" and"), mixed)
+	for _, v := range logits {
+		assert(t, !math.IsNaN(float64(v)) && !math.IsInf(float64(v), 0), "the mixed state should evaluate")
+	}
+}
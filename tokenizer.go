@@ -4,123 +4,112 @@
 package rwkv
 
 import (
-	"embed"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"github.com/sugarme/tokenizer"
-	"github.com/sugarme/tokenizer/pretrained"
 )
 
 type TokenizerType uint8
 
+// the values of Normal and World are kept from before Auto, Normal is still the zero value
 const (
-	Normal TokenizerType = iota
-	World
+	Normal TokenizerType = 0
+	World  TokenizerType = 1
+	Auto   TokenizerType = 2 // detect the tokenizer from the vocabulary size of the loaded model
 )
 
-type Tokenizer interface {
-	Encode(in string) ([]int, error)
-	Decode(in []int) string
-}
-
-//go:embed 20B_tokenizer.json
-var tokenizerFS embed.FS
+const (
+	normalVocabSize = 50277 // 20B_tokenizer models
+	worldVocabSize  = 65536 // World models
+)
 
-type NormalTokenizer struct {
-	tk *tokenizer.Tokenizer
+func (t TokenizerType) String() string {
+	switch t {
+	case Auto:
+		return "Auto"
+	case Normal:
+		return "Normal"
+	case World:
+		return "World"
+	default:
+		return fmt.Sprintf("TokenizerType(%d)", uint8(t))
+	}
 }
 
-func NewNormalTokenizer() (*NormalTokenizer, error) {
-	f, err := tokenizerFS.Open("20B_tokenizer.json")
-	if err != nil {
-		return nil, err
+// detectTokenizerType tells 20B_tokenizer models apart from World models by n_vocab, see RwkvGetNVocab
+func detectTokenizerType(nVocab uint64) (TokenizerType, error) {
+	switch nVocab {
+	case normalVocabSize:
+		return Normal, nil
+	case worldVocabSize:
+		return World, nil
+	default:
+		return Auto, fmt.Errorf("cannot detect tokenizer for vocabulary size %d, please set TokenizerType", nVocab)
 	}
+}
 
-	dec := json.NewDecoder(f)
-
-	var config *tokenizer.Config
-	err = dec.Decode(&config)
-	if err != nil {
-		return nil, err
+// resolveTokenizerType checks the configured tokenizer type against the vocabulary size of the model,
+// Auto is replaced by the detected type, and a known vocabulary size that conflicts with the configured type is an error
+func resolveTokenizerType(configured TokenizerType, nVocab uint64) (TokenizerType, error) {
+	var detected, err = detectTokenizerType(nVocab)
+	if configured == Auto {
+		return detected, err
 	}
 
-	model, err := pretrained.CreateModel(config)
-	if err != nil {
-		err := fmt.Errorf("creating Model failed: %v", err)
-		return nil, err
+	if err == nil && detected != configured {
+		return configured, fmt.Errorf("TokenizerType=%v conflicts with the model, whose vocabulary size %d requires %v", configured, nVocab, detected)
 	}
-	tk := tokenizer.NewTokenizer(model)
 
-	// 2. Normalizer
-	n, err := pretrained.CreateNormalizer(config.Normalizer)
-	if err != nil {
-		err = fmt.Errorf("creating Normalizer failed: %v", err)
-		return nil, err
-	}
-	tk.WithNormalizer(n)
+	return configured, nil
+}
 
-	// 3. PreTokenizer
-	preTok, err := pretrained.CreatePreTokenizer(config.PreTokenizer)
-	if err != nil {
-		err = fmt.Errorf("creating PreTokenizer failed: %v", err)
-		return nil, err
+func newTokenizer(tokenizerType TokenizerType) (Tokenizer, error) {
+	switch tokenizerType {
+	case Normal:
+		return NewNormalTokenizer()
+	case World:
+		return NewWorldTokenizer()
+	default:
+		return nil, fmt.Errorf("unsupported TokenizerType=%v", tokenizerType)
 	}
-	tk.WithPreTokenizer(preTok)
+}
 
-	// 4. PostProcessor
-	postProcessor, err := pretrained.CreatePostProcessor(config.PostProcessor)
-	if err != nil {
-		err = fmt.Errorf("creating PostProcessor failed: %v", err)
-		return nil, err
-	}
-	tk.WithPostProcessor(postProcessor)
+type Tokenizer interface {
+	Encode(in string) ([]int, error)
+	Decode(in []int) string
+}
 
-	// 5. Decoder
-	decoder, err := pretrained.CreateDecoder(config.Decoder)
-	if err != nil {
-		err = fmt.Errorf("creating Decoder failed: %v", err)
-		return nil, err
-	}
-	tk.WithDecoder(decoder)
+// TokenOffset is the byte span [Start, End) of the input which a token comes from
+type TokenOffset struct {
+	Start int
+	End   int
+}
 
-	// 6. AddedVocabulary
-	specialAddedTokens, addedTokens := pretrained.CreateAddedTokens(config.AddedTokens)
-	if len(specialAddedTokens) > 0 {
-		tk.AddSpecialTokens(specialAddedTokens)
-	}
-	if len(addedTokens) > 0 {
-		tk.AddTokens(addedTokens)
-	}
+// OffsetTokenizer is a Tokenizer which also tells where every token comes from, for highlighting and truncation
+type OffsetTokenizer interface {
+	Tokenizer
+	EncodeWithOffsets(in string) ([]int, []TokenOffset, error)
+}
 
-	// 7. TruncationParams
-	truncParams, err := pretrained.CreateTruncationParams(config.Truncation)
-	if err != nil {
-		err = fmt.Errorf("creating TruncationParams failed: %v", err)
-		return nil, err
-	}
-	tk.WithTruncation(truncParams)
+// TokenCounter is a Tokenizer which counts tokens cheaply, for budget enforcement
+type TokenCounter interface {
+	Tokenizer
+	CountTokens(in string) (int, error)
+}
 
-	// 8. PaddingParams
-	paddingParams, err := pretrained.CreatePaddingParams(config.Padding)
-	if err != nil {
-		err = fmt.Errorf("creating PaddingParams failed: %v", err)
-		return nil, err
+// CountTokens counts the tokens of in, using TokenCounter when tk implements it
+func CountTokens(tk Tokenizer, in string) (int, error) {
+	if counter, ok := tk.(TokenCounter); ok {
+		return counter.CountTokens(in)
 	}
-	tk.WithPadding(paddingParams)
 
-	return &NormalTokenizer{tk: tk}, nil
+	var tokens, err = tk.Encode(in)
+	return len(tokens), err
 }
 
-func (t *NormalTokenizer) Encode(input string) ([]int, error) {
-	in := tokenizer.NewSingleEncodeInput(tokenizer.NewInputSequence(input))
-	encode, err := t.tk.Encode(in, false)
-	if err != nil {
-		return nil, err
-	}
-	return encode.Ids, nil
-}
+// ErrNoEmbeddedVocabulary is returned by NewNormalTokenizer and NewWorldTokenizer when the binary is built with
+// the `rwkv_noembed` tag, use the FromFile / FromReader constructors or RwkvOptions.Tokenizer instead
+var ErrNoEmbeddedVocabulary = errors.New("embedded vocabularies are excluded by the rwkv_noembed build tag")
 
-func (t *NormalTokenizer) Decode(ids []int) string {
-	out := t.tk.Decode(ids, false)
-	return out
-}
+// ErrNormalTokenizerUnsupported is returned by the constructors of NormalTokenizer on 32-bit platforms, where the
+// HuggingFace tokenizer does not build
+var ErrNormalTokenizerUnsupported = errors.New("the 20B tokenizer needs a 64-bit platform, use a World model")
@@ -0,0 +1,197 @@
+// Copyright (c) seasonjs. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+//go:build !386 && !arm && !mips && !mipsle
+
+package rwkv
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"unicode/utf8"
+
+	"github.com/sugarme/tokenizer"
+	"github.com/sugarme/tokenizer/pretrained"
+)
+
+type NormalTokenizer struct {
+	tk *tokenizer.Tokenizer
+}
+
+// NewNormalTokenizer creates the 20B tokenizer from the embedded 20B_tokenizer.json
+func NewNormalTokenizer() (*NormalTokenizer, error) {
+	f, err := openEmbeddedVocabulary(normalVocabularyName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return NewNormalTokenizerFromReader(f)
+}
+
+// NewNormalTokenizerFromFile creates a tokenizer from a HuggingFace tokenizer.json file
+func NewNormalTokenizerFromFile(path string) (*NormalTokenizer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return NewNormalTokenizerFromReader(f)
+}
+
+// NewNormalTokenizerFromReader creates a tokenizer from the content of a HuggingFace tokenizer.json
+func NewNormalTokenizerFromReader(reader io.Reader) (*NormalTokenizer, error) {
+	dec := json.NewDecoder(reader)
+
+	var config *tokenizer.Config
+	err := dec.Decode(&config)
+	if err != nil {
+		return nil, err
+	}
+
+	model, err := pretrained.CreateModel(config)
+	if err != nil {
+		err := fmt.Errorf("creating Model failed: %v", err)
+		return nil, err
+	}
+	tk := tokenizer.NewTokenizer(model)
+
+	// 2. Normalizer
+	n, err := pretrained.CreateNormalizer(config.Normalizer)
+	if err != nil {
+		err = fmt.Errorf("creating Normalizer failed: %v", err)
+		return nil, err
+	}
+	tk.WithNormalizer(n)
+
+	// 3. PreTokenizer
+	preTok, err := pretrained.CreatePreTokenizer(config.PreTokenizer)
+	if err != nil {
+		err = fmt.Errorf("creating PreTokenizer failed: %v", err)
+		return nil, err
+	}
+	tk.WithPreTokenizer(preTok)
+
+	// 4. PostProcessor
+	postProcessor, err := pretrained.CreatePostProcessor(config.PostProcessor)
+	if err != nil {
+		err = fmt.Errorf("creating PostProcessor failed: %v", err)
+		return nil, err
+	}
+	tk.WithPostProcessor(postProcessor)
+
+	// 5. Decoder
+	decoder, err := pretrained.CreateDecoder(config.Decoder)
+	if err != nil {
+		err = fmt.Errorf("creating Decoder failed: %v", err)
+		return nil, err
+	}
+	tk.WithDecoder(decoder)
+
+	// 6. AddedVocabulary
+	specialAddedTokens, addedTokens := pretrained.CreateAddedTokens(config.AddedTokens)
+	if len(specialAddedTokens) > 0 {
+		tk.AddSpecialTokens(specialAddedTokens)
+	}
+	if len(addedTokens) > 0 {
+		tk.AddTokens(addedTokens)
+	}
+
+	// 7. TruncationParams
+	truncParams, err := pretrained.CreateTruncationParams(config.Truncation)
+	if err != nil {
+		err = fmt.Errorf("creating TruncationParams failed: %v", err)
+		return nil, err
+	}
+	tk.WithTruncation(truncParams)
+
+	// 8. PaddingParams
+	paddingParams, err := pretrained.CreatePaddingParams(config.Padding)
+	if err != nil {
+		err = fmt.Errorf("creating PaddingParams failed: %v", err)
+		return nil, err
+	}
+	tk.WithPadding(paddingParams)
+
+	return &NormalTokenizer{tk: tk}, nil
+}
+
+func (t *NormalTokenizer) Encode(input string) ([]int, error) {
+	in := tokenizer.NewSingleEncodeInput(tokenizer.NewInputSequence(input))
+	encode, err := t.tk.Encode(in, false)
+	if err != nil {
+		return nil, err
+	}
+	return encode.Ids, nil
+}
+
+// EncodeWithOffsets encodes input and returns the byte span of input for every token. The spans of the tokenizer
+// leave out the leading space of a word, and give every token of a split character the span of the whole character,
+// so the spans are rebuilt from the bytes of the tokens, backwards from the end of each span.
+func (t *NormalTokenizer) EncodeWithOffsets(input string) ([]int, []TokenOffset, error) {
+	in := tokenizer.NewSingleEncodeInput(tokenizer.NewInputSequence(input))
+	encode, err := t.tk.Encode(in, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var ids = encode.Ids
+	var offsets = make([]TokenOffset, len(ids))
+	var last = 0 // the end of the previous span
+	for i := 0; i < len(ids); {
+		// the tokens sharing a span of the tokenizer cover its bytes together
+		var span = spanOf(encode.Offsets, i)
+		var j, size = i, 0
+		for ; j < len(ids) && spanOf(encode.Offsets, j) == span; j++ {
+			size += t.byteLen(ids[j])
+		}
+
+		var start = max(span.End-size, last)
+		for ; i < j; i++ {
+			var end = min(start+t.byteLen(ids[i]), span.End)
+			offsets[i] = TokenOffset{Start: start, End: end}
+			start = end
+		}
+		last = span.End
+	}
+
+	return ids, offsets, nil
+}
+
+// spanOf returns the span which the tokenizer gives to the token i
+func spanOf(offsets [][]int, i int) TokenOffset {
+	if i < len(offsets) && len(offsets[i]) == 2 {
+		return TokenOffset{Start: offsets[i][0], End: offsets[i][1]}
+	}
+
+	return TokenOffset{}
+}
+
+// byteLen returns the count of input bytes of token, the byte level vocabulary spells every byte with one rune
+func (t *NormalTokenizer) byteLen(token int) int {
+	var text, _ = t.tk.IdToToken(token)
+	return utf8.RuneCountInString(text)
+}
+
+// CountTokens returns the number of tokens of input
+func (t *NormalTokenizer) CountTokens(input string) (int, error) {
+	var tokens, err = t.Encode(input)
+	return len(tokens), err
+}
+
+// EOS returns the end of text token
+func (t *NormalTokenizer) EOS() int {
+	if id, ok := t.tk.TokenToId(EndOfTextToken); ok {
+		return id
+	}
+
+	return END_OF_TEXT
+}
+
+func (t *NormalTokenizer) Decode(ids []int) string {
+	out := t.tk.Decode(ids, false)
+	return out
+}
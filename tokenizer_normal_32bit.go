@@ -0,0 +1,50 @@
+//go:build 386 || arm || mips || mipsle
+
+package rwkv
+
+import "io"
+
+/********************************************************************
+created:    2026-10-18
+author:     lixianmin
+
+Copyright (C) - All Rights Reserved
+*********************************************************************/
+
+// NormalTokenizer is never created on 32-bit platforms, where github.com/sugarme/tokenizer does not build
+type NormalTokenizer struct{}
+
+// NewNormalTokenizer returns ErrNormalTokenizerUnsupported
+func NewNormalTokenizer() (*NormalTokenizer, error) {
+	return nil, ErrNormalTokenizerUnsupported
+}
+
+// NewNormalTokenizerFromFile returns ErrNormalTokenizerUnsupported
+func NewNormalTokenizerFromFile(path string) (*NormalTokenizer, error) {
+	return nil, ErrNormalTokenizerUnsupported
+}
+
+// NewNormalTokenizerFromReader returns ErrNormalTokenizerUnsupported
+func NewNormalTokenizerFromReader(reader io.Reader) (*NormalTokenizer, error) {
+	return nil, ErrNormalTokenizerUnsupported
+}
+
+func (t *NormalTokenizer) Encode(input string) ([]int, error) {
+	return nil, ErrNormalTokenizerUnsupported
+}
+
+func (t *NormalTokenizer) EncodeWithOffsets(input string) ([]int, []TokenOffset, error) {
+	return nil, nil, ErrNormalTokenizerUnsupported
+}
+
+func (t *NormalTokenizer) CountTokens(input string) (int, error) {
+	return 0, ErrNormalTokenizerUnsupported
+}
+
+func (t *NormalTokenizer) EOS() int {
+	return END_OF_TEXT
+}
+
+func (t *NormalTokenizer) Decode(ids []int) string {
+	return ""
+}
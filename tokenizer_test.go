@@ -4,6 +4,9 @@
 package rwkv
 
 import (
+	"fmt"
+	"slices"
+	"strings"
 	"testing"
 )
 
@@ -18,6 +21,7 @@ func assertEncodeAndDecode(t *testing.T, tk Tokenizer, input string) {
 
 func TestNormalTokenizer(t *testing.T) {
 	tk, err := NewNormalTokenizer()
+	skipUnsupported(t, err)
 	if err != nil {
 		t.Error(err)
 	}
@@ -70,3 +74,98 @@ func TestWorldTokenizer(t *testing.T) {
 		assertEncodeAndDecode(t, tk, seq6)
 	})
 }
+
+func TestResolveTokenizerType(t *testing.T) {
+	var cases = []struct {
+		configured TokenizerType
+		nVocab     uint64
+		want       TokenizerType
+		hasErr     bool
+	}{
+		{Auto, 50277, Normal, false},
+		{Auto, 65536, World, false},
+		{Auto, 12345, Auto, true},
+		{Normal, 50277, Normal, false},
+		{World, 65536, World, false},
+		{Normal, 65536, Normal, true},
+		{World, 50277, World, true},
+		{World, 12345, World, false}, // unknown vocabulary, trust the configured type
+	}
+
+	// the values may be persisted, Auto was added after the others
+	assert(t, Normal == 0 && World == 1 && Auto == 2)
+
+	for _, c := range cases {
+		var got, err = resolveTokenizerType(c.configured, c.nVocab)
+		assert(t, got == c.want, "configured="+c.configured.String()+", got="+got.String())
+		assert(t, (err != nil) == c.hasErr, "configured="+c.configured.String()+", unexpected error state")
+	}
+}
+
+func TestTokenizerFromFile(t *testing.T) {
+	normal, err := NewNormalTokenizerFromFile("./20B_tokenizer.json")
+	skipUnsupported(t, err)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEncodeAndDecode(t, normal, "hello world")
+
+	world, err := NewWorldTokenizerFromFile("./rwkv_vocab_v20230424.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEncodeAndDecode(t, world, "你好世界")
+}
+
+func TestWorldTokenizerFromReader(t *testing.T) {
+	var vocab = "1 'a' 1\n2 'b' 1\n3 'ab' 2\n4 b'\\xe4\\xbd\\xa0' 3\n"
+	tk, err := NewWorldTokenizerFromReader(strings.NewReader(vocab))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokens, _ := tk.Encode("abba你")
+	assert(t, slices.Equal(tokens, []int{3, 2, 1, 4}), fmt.Sprint(tokens))
+	assertEncodeAndDecode(t, tk, "abba你")
+
+	_, err = NewWorldTokenizerFromReader(strings.NewReader("broken\n"))
+	assert(t, err != nil, "broken vocabulary should fail")
+}
+
+func assertOffsets(t *testing.T, tk OffsetTokenizer, input string) {
+	tokens, offsets, err := tk.EncodeWithOffsets(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encoded, _ := tk.Encode(input)
+	assert(t, slices.Equal(tokens, encoded), "EncodeWithOffsets should encode as Encode")
+	assert(t, len(tokens) == len(offsets), "every token should have an offset")
+
+	for i, offset := range offsets {
+		var piece = input[offset.Start:offset.End]
+		assert(t, tk.Decode(tokens[i:i+1]) == piece, fmt.Sprintf("token %d: %q", i, piece))
+	}
+
+	count, err := CountTokens(tk, input)
+	assert(t, err == nil && count == len(tokens), fmt.Sprintf("count=%d, len(tokens)=%d", count, len(tokens)))
+}
+
+func TestTokenizerOffsets(t *testing.T) {
+	world, err := NewWorldTokenizer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertOffsets(t, world, "hello world, 你好世界")
+
+	normal, err := NewNormalTokenizer()
+	skipUnsupported(t, err)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertOffsets(t, normal, "hello world, how are you")
+	assertOffsets(t, normal, "a 龘𝔘 😀b, é ü")
+
+	_, offsets, _ := normal.EncodeWithOffsets("hello world")
+	assert(t, offsets[1] == TokenOffset{Start: 5, End: 11}, "the span should include the leading space")
+}
@@ -0,0 +1,181 @@
+package rwkv
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+/********************************************************************
+created:    2026-10-18
+author:     lixianmin
+
+Copyright (C) - All Rights Reserved
+*********************************************************************/
+
+const (
+	RoleTool = "tool" // the result of a tool call
+
+	// the model calls a tool by replying ToolCallStart{"name": ..., "arguments": {...}}ToolCallEnd
+	ToolCallStart   = "<tool_call>"
+	ToolCallEnd     = "</tool_call>"
+	ToolResultStart = "<tool_result>"
+	ToolResultEnd   = "</tool_result>"
+
+	maxToolRounds = 8
+)
+
+var (
+	ErrInvalidTool      = errors.New("the tool has no name, or its parameters are not valid json")
+	ErrDuplicateTool    = errors.New("the tool is declared more than once")
+	ErrTooManyToolCalls = errors.New("the model kept calling tools without giving an answer")
+)
+
+// Tool is a Go function which the model can call
+type Tool struct {
+	Name        string
+	Description string
+	Parameters  json.RawMessage                                                      // the json schema of the arguments
+	Handler     func(ctx context.Context, arguments json.RawMessage) (string, error) // returns the result shown to the model
+}
+
+// ToolCall is what the model writes between ToolCallStart and ToolCallEnd
+type ToolCall struct {
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+// SetTools declares the tools the model can call in ProcessWithTools. They are described to the model in a system
+// message right after the prompt, and the turns so far are evaluated again on top of it.
+func (my *Chatbot) SetTools(tools ...Tool) error {
+	var names = make([]string, 0, len(tools))
+	for _, tool := range tools {
+		if tool.Name == "" || len(tool.Parameters) > 0 && !json.Valid(tool.Parameters) {
+			return ErrInvalidTool
+		}
+
+		if slices.Contains(names, tool.Name) {
+			return ErrDuplicateTool
+		}
+		names = append(names, tool.Name)
+	}
+
+	my.tools = slices.Clone(tools)
+	my.promptState = my.basePromptState
+	if len(tools) > 0 {
+		var text, err = my.template.Render([]Message{{Role: RoleSystem, Content: describeTools(tools)}}, false)
+		if err != nil {
+			return err
+		}
+
+		my.promptState, _ = my.runRnn(my.model.Encode(text), slices.Clone(my.basePromptState), 0)
+	}
+
+	return my.rebuildState(my.history)
+}
+
+func (my *Chatbot) Tools() []Tool {
+	return slices.Clone(my.tools)
+}
+
+// ProcessWithTools runs a turn in which the model may call the declared tools. Every call is dispatched to the
+// handler of the tool, and its result is fed back to the model, until the model gives an answer without a call.
+// The calls and the results are kept in the history as assistant and RoleTool messages.
+func (my *Chatbot) ProcessWithTools(ctx context.Context, message string) (string, error) {
+	message = strings.TrimSpace(strings.ReplaceAll(message, "\r\n", "\n"))
+
+	var stopTexts = append(slices.Clone(my.stopTexts), ToolCallEnd)
+	var turn = []Message{{Role: RoleUser, Content: message}}
+	var prompt = my.retrieve(ctx, turn) // the documents are rendered but not kept in the history
+	var extra = len(prompt) - len(turn)
+
+	for round := 0; round < maxToolRounds; round++ {
+		// the turn is rendered as a whole every round, which keeps the tool results in the format of the template
+		var text, err = my.template.Render(append(prompt[:extra:extra], turn...), true)
+		if err != nil {
+			return "", err
+		}
+
+		output, state, err := my.generate(ctx, my.state, text, stopTexts)
+		if err != nil {
+			return "", err
+		}
+
+		var index = strings.Index(output, ToolCallStart)
+		if index < 0 {
+			turn = append(turn, Message{Role: RoleAssistant, Content: output})
+			my.state = state
+			my.history = append(my.history, turn...)
+			my.applyHistoryPolicy()
+			return output, nil
+		}
+
+		var body = strings.TrimSpace(strings.TrimSuffix(output[index+len(ToolCallStart):], ToolCallEnd))
+		var result = my.callTool(ctx, body)
+		turn = append(turn,
+			Message{Role: RoleAssistant, Content: output[:index] + ToolCallStart + body + ToolCallEnd},
+			Message{Role: RoleTool, Content: ToolResultStart + result + ToolResultEnd},
+		)
+	}
+
+	return "", ErrTooManyToolCalls
+}
+
+// callTool runs the call written in body, the errors are returned as the result so that the model can retry
+func (my *Chatbot) callTool(ctx context.Context, body string) string {
+	var call ToolCall
+	if err := json.Unmarshal([]byte(body), &call); err != nil {
+		return fmt.Sprintf("error: the tool call is not valid json: %v", err)
+	}
+
+	var index = slices.IndexFunc(my.tools, func(tool Tool) bool { return tool.Name == call.Name })
+	if index < 0 {
+		return fmt.Sprintf("error: there is no tool named %q", call.Name)
+	}
+
+	var tool = my.tools[index]
+	if tool.Handler == nil {
+		return fmt.Sprintf("error: the tool %q cannot be called", call.Name)
+	}
+
+	var result, err = tool.Handler(ctx, call.Arguments)
+	if err != nil {
+		return "error: " + err.Error()
+	}
+
+	return result
+}
+
+func describeTools(tools []Tool) string {
+	var sb strings.Builder
+	sb.WriteString("You can call the following tools. To call a tool, reply with ")
+	sb.WriteString(ToolCallStart)
+	sb.WriteString(`{"name": "the tool name", "arguments": {...}}`)
+	sb.WriteString(ToolCallEnd)
+	sb.WriteString(", then its result is given between ")
+	sb.WriteString(ToolResultStart)
+	sb.WriteString(" and ")
+	sb.WriteString(ToolResultEnd)
+	sb.WriteString(".")
+
+	for _, tool := range tools {
+		sb.WriteString("\n- ")
+		sb.WriteString(tool.Name)
+		if tool.Description != "" {
+			sb.WriteString(": ")
+			sb.WriteString(tool.Description)
+		}
+
+		var parameters bytes.Buffer
+		if len(tool.Parameters) > 0 && json.Compact(&parameters, tool.Parameters) == nil {
+			sb.WriteString(" Parameters: ")
+			sb.WriteString(parameters.String())
+		}
+	}
+
+	return sb.String()
+}
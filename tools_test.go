@@ -0,0 +1,146 @@
+package rwkv
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+/********************************************************************
+created:    2026-10-18
+author:     lixianmin
+
+Copyright (C) - All Rights Reserved
+*********************************************************************/
+
+func TestTools(t *testing.T) {
+	var model = newTinyChatModel(t)
+	var bot = NewChatbotWithTemplate(model, WorldTemplate, "")
+	bot.SetSampling(SamplingOptions{MaxTokens: 4, Temperature: 1, TopP: 1})
+
+	var weather = Tool{
+		Name:        "get_weather",
+		Description: "Get the weather of a city.",
+		Parameters: json.RawMessage(`{
+			"type": "object",
+			"properties": {"city": {"type": "string"}},
+			"required": ["city"]
+		}`),
+		Handler: func(ctx context.Context, arguments json.RawMessage) (string, error) {
+			var args struct{ City string }
+			if err := json.Unmarshal(arguments, &args); err != nil {
+				return "", err
+			}
+
+			if args.City == "" {
+				return "", errors.New("the city is required")
+			}
+
+			return "sunny in " + args.City, nil
+		},
+	}
+
+	assert(t, errors.Is(bot.SetTools(weather, weather), ErrDuplicateTool))
+	assert(t, errors.Is(bot.SetTools(Tool{Name: "broken", Parameters: json.RawMessage("{")}), ErrInvalidTool))
+	if err := bot.SetTools(weather); err != nil {
+		t.Fatal(err)
+	}
+
+	var description = describeTools(bot.Tools())
+	assert(t, strings.Contains(description, `get_weather: Get the weather of a city. Parameters: {"type":"object"`), description)
+
+	var ctx = context.Background()
+	assert(t, bot.callTool(ctx, `{"name": "get_weather", "arguments": {"city": "Paris"}}`) == "sunny in Paris")
+	assert(t, bot.callTool(ctx, `{"name": "get_weather", "arguments": {}}`) == "error: the city is required")
+	assert(t, strings.HasPrefix(bot.callTool(ctx, `{"name": "unknown"}`), "error: there is no tool"))
+	assert(t, strings.HasPrefix(bot.callTool(ctx, `get_weather(Paris)`), "error: the tool call is not valid json"))
+
+	// the scripted model calls the tool, then answers with the result fed back
+	var calls []string
+	var handler = weather.Handler
+	weather.Handler = func(ctx context.Context, arguments json.RawMessage) (string, error) {
+		calls = append(calls, string(arguments))
+		return handler(ctx, arguments)
+	}
+
+	var call = ToolCallStart + `{"name": "get_weather", "arguments": {"city": "Paris"}}` + ToolCallEnd
+	var script = newScriptedRwkv(model, call, "It is sunny in Paris.")
+	bot = NewChatbotWithTemplate(model, WorldTemplate, "")
+	bot.SetSampling(SamplingOptions{MaxTokens: 64, Temperature: 1, TopP: 1})
+	if err := bot.SetTools(weather); err != nil {
+		t.Fatal(err)
+	}
+
+	var reply, err = bot.ProcessWithTools(ctx, "What is the weather in Paris?")
+	assert(t, err == nil && reply == "It is sunny in Paris.", reply)
+	assert(t, len(calls) == 1 && calls[0] == `{"city": "Paris"}`, "the handler should be invoked once")
+	var result = ToolResultStart + "sunny in Paris" + ToolResultEnd
+	assert(t, strings.Contains(model.Decode(script.fed), result), "the result should be fed back to the model")
+
+	var history = bot.History()
+	assert(t, len(history) == 4 && history[1].Content == call && history[3].Content == reply)
+	assert(t, history[2].Role == RoleTool && history[2].Content == result)
+
+	// a model which keeps calling tools is stopped after maxToolRounds
+	calls = nil
+	newScriptedRwkv(model, call)
+	_, err = bot.ProcessWithTools(ctx, "And in Paris again?")
+	assert(t, errors.Is(err, ErrTooManyToolCalls) && len(calls) == maxToolRounds)
+	assert(t, len(bot.History()) == 4, "an unfinished turn should not be kept")
+}
+
+// scriptedRwkv evaluates with the backend it wraps, but forces the logits so that the model replies the scripted
+// texts one after another, and the last one again and again. The answers end at EOS.
+type scriptedRwkv struct {
+	CRwkv
+	replies [][]int
+	round   int
+	forced  int   // the index of the token forced in the current reply, -1 when none is
+	fed     []int // every token evaluated
+}
+
+func newScriptedRwkv(model *ChatModel, replies ...string) *scriptedRwkv {
+	var backend = model.cRwkv
+	if scripted, ok := backend.(*scriptedRwkv); ok {
+		backend = scripted.CRwkv
+	}
+
+	var script = &scriptedRwkv{CRwkv: backend, forced: -1}
+	for _, reply := range replies {
+		var tokens = model.Encode(reply)
+		if !strings.HasSuffix(reply, ToolCallEnd) {
+			tokens = append(tokens, model.EOS())
+		}
+		script.replies = append(script.replies, tokens)
+	}
+
+	model.cRwkv = script
+	return script
+}
+
+func (my *scriptedRwkv) RwkvEval(ctx *RwkvCtx, token uint32, stateIn []float32, stateOut []float32,
+	logitsOut []float32) error {
+	if err := my.CRwkv.RwkvEval(ctx, token, stateIn, stateOut, logitsOut); err != nil {
+		return err
+	}
+	my.fed = append(my.fed, int(token))
+
+	// a sampled token is fed back, anything else is a prompt, after which the reply starts over
+	var reply = my.replies[min(my.round, len(my.replies)-1)]
+	if my.forced >= 0 && int(token) == reply[my.forced] {
+		my.forced++
+	} else {
+		my.forced = 0
+	}
+
+	if my.forced == len(reply) {
+		my.round++
+		my.forced = -1
+		return nil
+	}
+
+	logitsOut[reply[my.forced]] = 1e9
+	return nil
+}
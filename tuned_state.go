@@ -0,0 +1,234 @@
+package rwkv
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"sort"
+	"sync"
+)
+
+/********************************************************************
+created:    2026-10-18
+author:     lixianmin
+
+Copyright (C) - All Rights Reserved
+*********************************************************************/
+
+var (
+	ErrStateExists   = errors.New("the tuned state already exists")
+	ErrStateNotFound = errors.New("the tuned state is not found")
+)
+
+// tunedStates holds the named initial states of a ChatModel, which state tuning learns instead of weight changes
+type tunedStates struct {
+	lock       sync.Mutex
+	states     map[string][]float32
+	current    string // the state new states start from, "" for the one of RwkvInitState
+	generation uint64 // changes with current, so that the caches of Chat know when to drop their states
+}
+
+func (my *tunedStates) currentGeneration() uint64 {
+	my.lock.Lock()
+	defer my.lock.Unlock()
+	return my.generation
+}
+
+// use changes the state in use, the caller holds my.lock
+func (my *tunedStates) use(name string) {
+	if my.current != name {
+		my.current = name
+		my.generation++
+	}
+}
+
+// ReadStateFile reads a state saved as little endian float32s, in the layout of StateLayout
+func ReadStateFile(path string) ([]float32, error) {
+	var data, err = os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) == 0 || len(data)%4 != 0 {
+		return nil, fmt.Errorf("%w: %s has %d bytes, which are not float32s", ErrInvalidStateLayout, path, len(data))
+	}
+
+	var state = make([]float32, len(data)/4)
+	for i := range state {
+		state[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[i*4:]))
+	}
+
+	return state, nil
+}
+
+// WriteStateFile writes state as little endian float32s, which ReadStateFile reads back
+func WriteStateFile(path string, state []float32) error {
+	return writeFileAtomically(path, func(writer io.Writer) error {
+		var data = make([]byte, 4*len(state))
+		for i, v := range state {
+			binary.LittleEndian.PutUint32(data[i*4:], math.Float32bits(v))
+		}
+
+		var _, err = writer.Write(data)
+		return err
+	})
+}
+
+// AddState registers state as the tuned state of name, its length should be the one of RwkvGetStateLength
+func (my *ChatModel) AddState(name string, state []float32) error {
+	my.lock.Lock()
+	var stateLength = int(my.cRwkv.RwkvGetStateLength(my.ctx))
+	my.lock.Unlock()
+
+	if len(state) != stateLength {
+		return stateLengthError(len(state), stateLength)
+	}
+
+	var registry = my.states
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	// "" is the name of the state of RwkvInitState
+	if _, ok := registry.states[name]; ok || name == "" {
+		return ErrStateExists
+	}
+
+	if registry.states == nil {
+		registry.states = make(map[string][]float32)
+	}
+
+	registry.states[name] = slices.Clone(state)
+	return nil
+}
+
+// LoadState reads the state file of path by ReadStateFile, and registers it as name
+func (my *ChatModel) LoadState(name string, path string) error {
+	var state, err = ReadStateFile(path)
+	if err != nil {
+		return err
+	}
+
+	return my.AddState(name, state)
+}
+
+// RemoveState unregisters the tuned state of name, new states start from RwkvInitState again if it is in use
+func (my *ChatModel) RemoveState(name string) error {
+	var registry = my.states
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	if _, ok := registry.states[name]; !ok {
+		return ErrStateNotFound
+	}
+
+	delete(registry.states, name)
+	if registry.current == name {
+		registry.use("")
+	}
+
+	return nil
+}
+
+// States returns the sorted names of the tuned states
+func (my *ChatModel) States() []string {
+	var registry = my.states
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	var names = make([]string, 0, len(registry.states))
+	for name := range registry.states {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// UseState makes the tuned state of name the start of the new states of EvalSequence, Eval, Chat and the chatbots
+// created after, "" goes back to RwkvInitState
+func (my *ChatModel) UseState(name string) error {
+	var registry = my.states
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	if _, ok := registry.states[name]; !ok && name != "" {
+		return ErrStateNotFound
+	}
+
+	registry.use(name)
+	return nil
+}
+
+// TunedState returns a copy of the tuned state of name, or a state initialized by RwkvInitState for "". Pass it to
+// EvalSequence to start from it once.
+func (my *ChatModel) TunedState(name string) ([]float32, error) {
+	if name == "" {
+		my.lock.Lock()
+		defer my.lock.Unlock()
+
+		var state = make([]float32, my.cRwkv.RwkvGetStateLength(my.ctx))
+		my.cRwkv.RwkvInitState(my.ctx, state)
+		return state, nil
+	}
+
+	var registry = my.states
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	if state, ok := registry.states[name]; ok {
+		return slices.Clone(state), nil
+	}
+
+	return nil, ErrStateNotFound
+}
+
+func stateLengthError(length int, want int) error {
+	return fmt.Errorf("%w: the state has %d elements, but the model has %d", ErrInvalidStateLayout, length, want)
+}
+
+// initState fills state with the tuned state in use, or by RwkvInitState, and returns the generation of the tuned
+// state. The caller holds my.lock.
+func (my *ChatModel) initState(state []float32) uint64 {
+	var registry = my.states
+	registry.lock.Lock()
+	var tuned, generation = registry.states[registry.current], registry.generation
+	registry.lock.Unlock()
+
+	if tuned != nil {
+		copy(state, tuned)
+		return generation
+	}
+
+	my.cRwkv.RwkvInitState(my.ctx, state)
+	return generation
+}
+
+// UseState restarts the prompt from the tuned state of name registered on the model, or from RwkvInitState for "",
+// then replays the tools and the turns so far on it
+func (my *Chatbot) UseState(name string) error {
+	var state, err = my.model.TunedState(name)
+	if err != nil {
+		return err
+	}
+
+	my.basePromptState, _ = my.runRnn(my.promptTokens, state, 0)
+	return my.SetTools(my.tools...)
+}
+
+// InitStateFrom gives a new state for a new chat context, starting from tuned instead of the state of InitState
+func (m *RwkvModel) InitStateFrom(tuned []float32) (*RwkvState, error) {
+	var state, err = m.InitState()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tuned) != len(state.state) {
+		return nil, stateLengthError(len(tuned), len(state.state))
+	}
+
+	copy(state.state, tuned)
+	return state, nil
+}
@@ -0,0 +1,84 @@
+package rwkv
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+/********************************************************************
+created:    2026-10-18
+author:     lixianmin
+
+Copyright (C) - All Rights Reserved
+*********************************************************************/
+
+func TestTunedStates(t *testing.T) {
+	var model = newTinyChatModel(t)
+	defer model.Close()
+
+	// a state evaluated from a text stands for a tuned one
+	var tuned, _ = model.EvalSequence(model.Encode("You are a pirate, arr!"), nil)
+	var path = filepath.Join(t.TempDir(), "pirate.state")
+	assert(t, WriteStateFile(path, tuned) == nil)
+	var read, err = ReadStateFile(path)
+	assert(t, err == nil && slices.Equal(read, tuned), "a state file should be read back")
+
+	assert(t, errors.Is(model.AddState("short", tuned[1:]), ErrInvalidStateLayout), "the length should match")
+	assert(t, model.LoadState("pirate", path) == nil)
+	assert(t, errors.Is(model.AddState("pirate", tuned), ErrStateExists))
+	assert(t, slices.Equal(model.States(), []string{"pirate"}))
+	assert(t, errors.Is(model.UseState("missing"), ErrStateNotFound))
+
+	var tokens = model.Encode("hello")
+	var _, plainLogits = model.EvalSequence(tokens, nil)
+	var _, plainCached, _ = model.evalHistory("hello")
+
+	// new states start from the tuned one in use, the cache of Chat included
+	assert(t, model.UseState("pirate") == nil)
+	var pirate, _ = model.TunedState("pirate")
+	var _, wantLogits = model.EvalSequence(tokens, slices.Clone(pirate))
+	var _, logits = model.EvalSequence(tokens, nil)
+	var _, cached, _ = model.evalHistory("hello")
+	assert(t, slices.Equal(logits, wantLogits) && !slices.Equal(logits, plainLogits),
+		"EvalSequence should start from the tuned state")
+	assert(t, slices.Equal(cached, wantLogits) && !slices.Equal(cached, plainCached),
+		"Chat should drop the states cached before")
+
+	var clone, _ = model.clone(1)
+	defer clone.Close()
+	var _, cloneLogits = clone.EvalSequence(tokens, nil)
+	assert(t, slices.Equal(cloneLogits, wantLogits), "the clones should share the tuned states")
+
+	// a chatbot switches its own state, and replays its turns on it
+	assert(t, model.UseState("") == nil)
+	var bot = NewChatbot(model, "User", "Assistant", "Chat with the user.\n\n")
+	bot.Process("hi")
+	assert(t, bot.UseState("pirate") == nil)
+	var promptState, _ = model.EvalSequence(model.Encode("Chat with the user.\n\n"), slices.Clone(pirate))
+	assert(t, slices.Equal(bot.promptState, promptState), "the prompt should run from the tuned state")
+	assert(t, len(bot.History()) == 2 && !slices.Equal(bot.state, promptState), "the turns should be replayed")
+	assert(t, errors.Is(bot.UseState("missing"), ErrStateNotFound))
+
+	assert(t, model.UseState("pirate") == nil)
+	assert(t, model.RemoveState("pirate") == nil)
+	assert(t, errors.Is(model.RemoveState("pirate"), ErrStateNotFound))
+	_, logits = model.EvalSequence(tokens, nil)
+	assert(t, slices.Equal(logits, plainLogits), "removing the state in use should go back to RwkvInitState")
+
+	const nVocab, nEmbed, nLayer = worldVocabSize, 32, 2
+	var modelPath = filepath.Join(t.TempDir(), "tiny.bin")
+	assert(t, writeFixtureModel(modelPath, nVocab, nEmbed, nLayer, newTinyModelTensors(1, nVocab, nEmbed, nLayer)) == nil)
+	var legacy, err2 = NewRwkvAutoModel(RwkvOptions{MaxTokens: 8, Temperature: 1, TopP: 1, Backend: BackendGo,
+		TokenizerType: Auto})
+	if err2 != nil {
+		t.Fatal(err2)
+	}
+	defer legacy.Close()
+	assert(t, legacy.LoadFromFile(modelPath) == nil)
+	var state, err3 = legacy.InitStateFrom(tuned)
+	assert(t, err3 == nil && slices.Equal(state.state, tuned), "InitStateFrom should start from the tuned state")
+	_, err3 = legacy.InitStateFrom(tuned[1:])
+	assert(t, errors.Is(err3, ErrInvalidStateLayout))
+}
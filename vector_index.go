@@ -0,0 +1,266 @@
+package rwkv
+
+import (
+	"encoding/gob"
+	"errors"
+	"io"
+	"os"
+	"slices"
+	"sync"
+)
+
+/********************************************************************
+created:    2026-10-18
+author:     lixianmin
+
+Copyright (C) - All Rights Reserved
+*********************************************************************/
+
+// Similarity is how the vectors of an index are compared, a higher score means more similar
+type Similarity int
+
+const (
+	SimilarityCosine Similarity = iota // the vectors are normalized when added, so that scores are in [-1, 1]
+	SimilarityDot
+)
+
+var (
+	ErrDimensionMismatch  = errors.New("the vector does not have the dimension of the index")
+	ErrUnknownVectorIndex = errors.New("the file does not hold a known vector index")
+)
+
+type SearchResult struct {
+	ID    string
+	Score float32
+}
+
+// VectorIndex finds the vectors most similar to a query, such as the embeddings returned by ChatModel.Embed
+type VectorIndex interface {
+	// Add adds vector by id, replacing the one with the same id
+	Add(id string, vector []float32) error
+
+	// Delete removes the vector of id, and returns false when there is none
+	Delete(id string) bool
+
+	// Search returns at most k vectors most similar to query, the most similar first
+	Search(query []float32, k int) ([]SearchResult, error)
+
+	Len() int
+
+	// Save writes the index to writer, ReadVectorIndex reads it back
+	Save(writer io.Writer) error
+}
+
+// vectorIndexSnapshot is what a saved index holds, the fields of HNSWIndex are empty for a FlatIndex
+type vectorIndexSnapshot struct {
+	Kind       string
+	Dim        int
+	Similarity Similarity
+	IDs        []string
+	Vectors    [][]float32
+
+	Options   HNSWOptions
+	Neighbors [][][]int32
+	Deleted   []bool
+	Entry     int
+	MaxLevel  int
+}
+
+const (
+	flatIndexKind = "flat"
+	hnswIndexKind = "hnsw"
+)
+
+// ReadVectorIndex reads an index written by VectorIndex.Save
+func ReadVectorIndex(reader io.Reader) (VectorIndex, error) {
+	var snapshot vectorIndexSnapshot
+	if err := gob.NewDecoder(reader).Decode(&snapshot); err != nil {
+		return nil, err
+	}
+
+	switch snapshot.Kind {
+	case flatIndexKind:
+		return newFlatIndexFromSnapshot(&snapshot)
+	case hnswIndexKind:
+		return newHNSWIndexFromSnapshot(&snapshot)
+	default:
+		return nil, ErrUnknownVectorIndex
+	}
+}
+
+// SaveVectorIndexFile writes index to the file of path, replacing it only when the whole index is written
+func SaveVectorIndexFile(path string, index VectorIndex) error {
+	var temp = path + ".tmp"
+	var file, err = os.Create(temp)
+	if err != nil {
+		return err
+	}
+
+	if err = index.Save(file); err != nil {
+		_ = file.Close()
+		_ = os.Remove(temp)
+		return err
+	}
+
+	if err = file.Close(); err != nil {
+		_ = os.Remove(temp)
+		return err
+	}
+
+	return os.Rename(temp, path)
+}
+
+// LoadVectorIndexFile reads the index saved in the file of path
+func LoadVectorIndexFile(path string) (VectorIndex, error) {
+	var file, err = os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ReadVectorIndex(file)
+}
+
+// FlatIndex compares the query with every vector, which is exact and fast enough for some ten thousands vectors
+type FlatIndex struct {
+	lock       sync.RWMutex
+	dim        int
+	similarity Similarity
+	ids        []string
+	vectors    [][]float32
+	positions  map[string]int
+}
+
+func NewFlatIndex(dim int, similarity Similarity) *FlatIndex {
+	return &FlatIndex{dim: dim, similarity: similarity, positions: make(map[string]int)}
+}
+
+func newFlatIndexFromSnapshot(snapshot *vectorIndexSnapshot) (*FlatIndex, error) {
+	if len(snapshot.IDs) != len(snapshot.Vectors) {
+		return nil, ErrUnknownVectorIndex
+	}
+
+	var index = NewFlatIndex(snapshot.Dim, snapshot.Similarity)
+	for i, id := range snapshot.IDs {
+		if len(snapshot.Vectors[i]) != snapshot.Dim {
+			return nil, ErrDimensionMismatch
+		}
+
+		index.positions[id] = i
+	}
+
+	index.ids = snapshot.IDs
+	index.vectors = snapshot.Vectors
+	return index, nil
+}
+
+func (my *FlatIndex) Add(id string, vector []float32) error {
+	if len(vector) != my.dim {
+		return ErrDimensionMismatch
+	}
+
+	vector = prepareVector(vector, my.similarity)
+
+	my.lock.Lock()
+	defer my.lock.Unlock()
+
+	if position, ok := my.positions[id]; ok {
+		my.vectors[position] = vector
+		return nil
+	}
+
+	my.positions[id] = len(my.ids)
+	my.ids = append(my.ids, id)
+	my.vectors = append(my.vectors, vector)
+	return nil
+}
+
+func (my *FlatIndex) Delete(id string) bool {
+	my.lock.Lock()
+	defer my.lock.Unlock()
+
+	var position, ok = my.positions[id]
+	if !ok {
+		return false
+	}
+
+	// move the last vector into the hole
+	var last = len(my.ids) - 1
+	my.ids[position] = my.ids[last]
+	my.vectors[position] = my.vectors[last]
+	my.positions[my.ids[position]] = position
+
+	my.ids = my.ids[:last]
+	my.vectors = my.vectors[:last]
+	delete(my.positions, id)
+	return true
+}
+
+func (my *FlatIndex) Search(query []float32, k int) ([]SearchResult, error) {
+	if len(query) != my.dim {
+		return nil, ErrDimensionMismatch
+	}
+
+	query = prepareVector(query, my.similarity)
+
+	my.lock.RLock()
+	defer my.lock.RUnlock()
+
+	var results = make([]SearchResult, len(my.ids))
+	for i, vector := range my.vectors {
+		results[i] = SearchResult{ID: my.ids[i], Score: dotProduct(query, vector)}
+	}
+
+	sortSearchResults(results)
+	return results[:min(max(k, 0), len(results))], nil
+}
+
+func (my *FlatIndex) Len() int {
+	my.lock.RLock()
+	defer my.lock.RUnlock()
+	return len(my.ids)
+}
+
+func (my *FlatIndex) Save(writer io.Writer) error {
+	my.lock.RLock()
+	defer my.lock.RUnlock()
+
+	return gob.NewEncoder(writer).Encode(&vectorIndexSnapshot{
+		Kind:       flatIndexKind,
+		Dim:        my.dim,
+		Similarity: my.similarity,
+		IDs:        my.ids,
+		Vectors:    my.vectors,
+	})
+}
+
+// prepareVector returns a copy of vector, normalized for the cosine similarity
+func prepareVector(vector []float32, similarity Similarity) []float32 {
+	vector = slices.Clone(vector)
+	if similarity == SimilarityCosine {
+		normalizeL2(vector)
+	}
+
+	return vector
+}
+
+func dotProduct(a []float32, b []float32) float32 {
+	var sum float32
+	for i, v := range a {
+		sum += v * b[i]
+	}
+
+	return sum
+}
+
+func sortSearchResults(results []SearchResult) {
+	slices.SortFunc(results, func(a, b SearchResult) int {
+		if a.Score > b.Score {
+			return -1
+		} else if a.Score < b.Score {
+			return 1
+		}
+
+		return 0
+	})
+}
@@ -0,0 +1,125 @@
+package rwkv
+
+import (
+	"errors"
+	"math/rand"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+/********************************************************************
+created:    2026-10-18
+author:     lixianmin
+
+Copyright (C) - All Rights Reserved
+*********************************************************************/
+
+func newRandomVectors(count int, dim int) [][]float32 {
+	var random = rand.New(rand.NewSource(7))
+	var vectors = make([][]float32, count)
+	for i := range vectors {
+		vectors[i] = make([]float32, dim)
+		for j := range vectors[i] {
+			vectors[i][j] = random.Float32()*2 - 1
+		}
+	}
+
+	return vectors
+}
+
+func TestFlatIndex(t *testing.T) {
+	var index = NewFlatIndex(2, SimilarityCosine)
+	assert(t, index.Add("x", []float32{1, 0}) == nil)
+	assert(t, index.Add("y", []float32{0, 3}) == nil)
+	assert(t, index.Add("xy", []float32{1, 1}) == nil)
+	assert(t, errors.Is(index.Add("z", []float32{1}), ErrDimensionMismatch))
+
+	var results, _ = index.Search([]float32{2, 0.1}, 2)
+	assert(t, len(results) == 2 && results[0].ID == "x" && results[1].ID == "xy")
+
+	assert(t, index.Delete("x") && !index.Delete("x") && index.Len() == 2)
+	results, _ = index.Search([]float32{2, 0.1}, 5)
+	assert(t, len(results) == 2 && results[0].ID == "xy")
+
+	var dot = NewFlatIndex(2, SimilarityDot)
+	_ = dot.Add("short", []float32{1, 0})
+	_ = dot.Add("long", []float32{3, 1})
+	results, _ = dot.Search([]float32{1, 0}, 1)
+	assert(t, results[0].ID == "long" && results[0].Score == 3)
+}
+
+func TestHNSWIndex(t *testing.T) {
+	const count, dim, k = 2000, 16, 10
+	var vectors = newRandomVectors(count+50, dim)
+	var flat = NewFlatIndex(dim, SimilarityCosine)
+	var hnsw = NewHNSWIndex(dim, SimilarityCosine, HNSWOptions{})
+
+	for i, vector := range vectors[:count] {
+		var id = strconv.Itoa(i)
+		_ = flat.Add(id, vector)
+		_ = hnsw.Add(id, vector)
+	}
+
+	// deleted vectors are no longer returned
+	for i := 0; i < count; i += 10 {
+		var id = strconv.Itoa(i)
+		assert(t, flat.Delete(id) && hnsw.Delete(id))
+	}
+	assert(t, hnsw.Len() == flat.Len())
+
+	var recall = func(index VectorIndex) float64 {
+		var hits = 0
+		for _, query := range vectors[count:] {
+			var want, _ = flat.Search(query, k)
+			var got, _ = index.Search(query, k)
+			for _, result := range got {
+				if slices.ContainsFunc(want, func(w SearchResult) bool { return w.ID == result.ID }) {
+					hits++
+				}
+
+				var id, _ = strconv.Atoi(result.ID)
+				assert(t, id%10 != 0, "a deleted vector is returned")
+			}
+		}
+
+		return float64(hits) / float64(len(vectors[count:])*k)
+	}
+
+	var r = recall(hnsw)
+	assert(t, r >= 0.9, "the recall is too low: "+strconv.FormatFloat(r, 'f', 3, 64))
+
+	// the saved index searches the same
+	var path = filepath.Join(t.TempDir(), "index.hnsw")
+	assert(t, SaveVectorIndexFile(path, hnsw) == nil)
+	var loaded, err = LoadVectorIndexFile(path)
+	assert(t, err == nil && loaded.Len() == hnsw.Len())
+
+	var want, _ = hnsw.Search(vectors[count], k)
+	var got, _ = loaded.Search(vectors[count], k)
+	assert(t, slices.Equal(want, got))
+
+	// updating a vector reuses its node
+	var nodes = len(hnsw.nodes)
+	assert(t, hnsw.Add("1", vectors[count]) == nil && len(hnsw.nodes) == nodes && hnsw.Len() == flat.Len())
+	got, _ = hnsw.Search(vectors[count], 1)
+	assert(t, len(got) == 1 && got[0].ID == "1", "the updated vector should be found")
+
+	// the deleted nodes are dropped once they outnumber the live ones, and the rest is still found
+	for i := 1; i < count; i++ {
+		if i%10 != 0 && i%4 != 0 {
+			var id = strconv.Itoa(i)
+			assert(t, flat.Delete(id) && hnsw.Delete(id))
+		}
+	}
+	assert(t, hnsw.Len() == flat.Len() && len(hnsw.nodes) < 2*hnsw.Len(), strconv.Itoa(len(hnsw.nodes)))
+	r = recall(hnsw)
+	assert(t, r >= 0.9, "the recall after rebuilding is too low: "+strconv.FormatFloat(r, 'f', 3, 64))
+
+	path = filepath.Join(t.TempDir(), "index.flat")
+	assert(t, SaveVectorIndexFile(path, flat) == nil)
+	loaded, err = LoadVectorIndexFile(path)
+	assert(t, err == nil && loaded.Len() == flat.Len())
+	assert(t, recall(loaded) == 1)
+}
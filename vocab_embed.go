@@ -0,0 +1,23 @@
+// Copyright (c) seasonjs. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+//go:build !rwkv_noembed
+
+package rwkv
+
+import (
+	"embed"
+	"io"
+)
+
+const (
+	normalVocabularyName = "20B_tokenizer.json"
+	worldVocabularyName  = "rwkv_vocab_v20230424.txt"
+)
+
+//go:embed 20B_tokenizer.json rwkv_vocab_v20230424.txt
+var vocabularyFS embed.FS
+
+func openEmbeddedVocabulary(name string) (io.ReadCloser, error) {
+	return vocabularyFS.Open(name)
+}
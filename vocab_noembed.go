@@ -0,0 +1,20 @@
+// Copyright (c) seasonjs. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+//go:build rwkv_noembed
+
+package rwkv
+
+import (
+	"io"
+)
+
+const (
+	normalVocabularyName = "20B_tokenizer.json"
+	worldVocabularyName  = "rwkv_vocab_v20230424.txt"
+)
+
+// openEmbeddedVocabulary always fails, the vocabularies are not embedded with the rwkv_noembed build tag
+func openEmbeddedVocabulary(name string) (io.ReadCloser, error) {
+	return nil, ErrNoEmbeddedVocabulary
+}
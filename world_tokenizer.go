@@ -5,20 +5,22 @@ package rwkv
 
 import (
 	"bufio"
-	"embed"
 	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"unicode/utf8"
 )
 
-//go:embed rwkv_vocab_v20230424.txt
-var worldTokenizerFS embed.FS
-
-// Trie represents the trie data structure
+// Trie represents the trie data structure. NewTrie and Add build a pointer based trie. The trie of a World tokenizer
+// is compiled into a double-array one instead, keys added to it later are looked up in both.
 type Trie struct {
-	to     []*Trie
-	values map[int]byte
+	to       []*Trie
+	values   map[int]byte
+	compiled *doubleArrayTrie
 }
 
 func NewTrie() *Trie {
@@ -31,6 +33,11 @@ func NewTrie() *Trie {
 }
 
 func (my *Trie) Add(key string, index int, value int) *Trie {
+	if my.to == nil {
+		my.to = make([]*Trie, 256)
+		my.values = make(map[int]byte)
+	}
+
 	if index == len(key) {
 		my.values[value] = 0
 		return my
@@ -44,58 +51,261 @@ func (my *Trie) Add(key string, index int, value int) *Trie {
 	return my.to[ch].Add(key, index+1, value)
 }
 
+// FindLongest finds the longest token which is a prefix of key[index:], retIndex is the end of the token in key.
+// retIndex is 0 when there is no such token.
 func (my *Trie) FindLongest(key string, index int) (retIndex int, retToken int) {
+	if my.compiled != nil {
+		retIndex, retToken = my.compiled.FindLongest(key, index)
+	}
+
 	var u = my
-	var ch = key[index]
+	for ; u.to != nil && index < len(key); index++ {
+		if u = u.to[key[index]]; u == nil {
+			break
+		}
+
+		if len(u.values) != 0 && index+1 > retIndex {
+			retIndex = index + 1
+			retToken = -1
+			for token := range u.values { // the smallest token of a duplicated key wins
+				if retToken < 0 || token < retToken {
+					retToken = token
+				}
+			}
+		}
+	}
+
+	return
+}
+
+// doubleArrayTrie is a double-array trie over the byte strings of a vocabulary. The child of node s by byte c is
+// t = base[s] + c + 1, which exists only when check[t] == s. The root is node 0.
+type doubleArrayTrie struct {
+	base  []int32
+	check []int32
+	value []int32 // the token which ends at the node, -1 for none
+}
+
+type trieKey struct {
+	word  string
+	token int32
+}
+
+type trieBuilder struct {
+	trie     *doubleArrayTrie
+	keys     []trieKey
+	nextFree int // every slot before nextFree is occupied
+}
+
+// newDoubleArrayTrie builds a trie from words, words[token] is the byte string of the token, empty words are
+// skipped. If the same word appears more than once, the smallest token wins.
+func newDoubleArrayTrie(words []string) *doubleArrayTrie {
+	var keys = make([]trieKey, 0, len(words))
+	for token, word := range words {
+		if word != "" {
+			keys = append(keys, trieKey{word: word, token: int32(token)})
+		}
+	}
+
+	slices.SortFunc(keys, func(a, b trieKey) int {
+		if c := strings.Compare(a.word, b.word); c != 0 {
+			return c
+		}
+		return int(a.token - b.token)
+	})
+
+	// roughly one slot per byte is enough for a vocabulary, it grows on demand anyway
+	var size = 256
+	for _, key := range keys {
+		size += len(key.word)
+	}
+
+	var builder = &trieBuilder{
+		trie:     &doubleArrayTrie{},
+		keys:     keys,
+		nextFree: 1,
+	}
+
+	builder.grow(size / 2)
+	builder.trie.check[0] = 0
+	builder.build(0, 0, len(keys), 0)
 
-	for u.to[ch] != nil {
-		u = u.to[ch]
-		index += 1
+	// drop the unused tail
+	var last = len(builder.trie.check) - 1
+	for last > 0 && builder.trie.check[last] < 0 {
+		last--
+	}
+
+	var trie = builder.trie
+	trie.base = slices.Clip(trie.base[:last+1])
+	trie.check = slices.Clip(trie.check[:last+1])
+	trie.value = slices.Clip(trie.value[:last+1])
+	return trie
+}
+
+func (my *trieBuilder) grow(size int) {
+	var trie = my.trie
+	for len(trie.check) < size {
+		trie.base = append(trie.base, 0)
+		trie.check = append(trie.check, -1)
+		trie.value = append(trie.value, -1)
+	}
+}
+
+// build places the children of node, keys[lo:hi] are the keys under node and share their first depth bytes
+func (my *trieBuilder) build(node int32, lo int, hi int, depth int) {
+	var trie = my.trie
+	var keys = my.keys
 
-		if len(u.values) != 0 {
-			retIndex = index
+	// sorted keys put the key which ends at this node first, and the smallest token of them first
+	if lo < hi && len(keys[lo].word) == depth {
+		trie.value[node] = keys[lo].token
+	}
+
+	for lo < hi && len(keys[lo].word) == depth {
+		lo++
+	}
+
+	if lo == hi {
+		return
+	}
+
+	var labels = make([]byte, 0, 4)
+	for i := lo; i < hi; i++ {
+		var ch = keys[i].word[depth]
+		if len(labels) == 0 || labels[len(labels)-1] != ch {
+			labels = append(labels, ch)
+		}
+	}
 
-			// just use the first
-			for token := range u.values {
-				retToken = token
+	var base = my.findBase(labels)
+	trie.base[node] = base
+	for _, ch := range labels {
+		trie.check[base+int32(ch)+1] = node
+	}
+
+	for my.nextFree < len(trie.check) && trie.check[my.nextFree] >= 0 {
+		my.nextFree++
+	}
+
+	var start = lo
+	for i := lo + 1; i <= hi; i++ {
+		if i == hi || keys[i].word[depth] != keys[start].word[depth] {
+			var child = base + int32(keys[start].word[depth]) + 1
+			my.build(child, start, i, depth+1)
+			start = i
+		}
+	}
+}
+
+// findBase returns the first base whose slots for all labels are free
+func (my *trieBuilder) findBase(labels []byte) int32 {
+	var first = int(labels[0]) + 1
+	var start = max(my.nextFree, first)
+	var occupied = 0
+
+	for pos := start; ; pos++ {
+		var base = pos - first
+		my.grow(base + int(labels[len(labels)-1]) + 2)
+
+		var check = my.trie.check
+		if check[pos] >= 0 {
+			occupied++
+			continue
+		}
+
+		var ok = true
+		for _, ch := range labels[1:] {
+			if check[base+int(ch)+1] >= 0 {
+				ok = false
 				break
 			}
 		}
 
-		if index == len(key) {
+		if ok {
+			// the scanned range is almost full, give up its few holes rather than scanning it again and again
+			if start == my.nextFree && occupied*20 >= (pos-start+1)*19 {
+				my.nextFree = pos
+			}
+
+			return int32(base)
+		}
+	}
+}
+
+// FindLongest works as Trie.FindLongest
+func (my *doubleArrayTrie) FindLongest(key string, index int) (retIndex int, retToken int) {
+	var node int32 = 0
+	var size = int32(len(my.check))
+
+	for ; index < len(key); index++ {
+		var next = my.base[node] + int32(key[index]) + 1
+		if next >= size || my.check[next] != node {
 			break
 		}
 
-		ch = key[index]
+		node = next
+		if token := my.value[node]; token >= 0 {
+			retIndex = index + 1
+			retToken = int(token)
+		}
 	}
 
 	return
 }
 
+// maxVocabularyIndex guards the decode table against a corrupted vocabulary file
+const maxVocabularyIndex = 1 << 24
+
 // WorldTokenizer represents a tokenizer for encoding and decoding bytes to tokens
 type WorldTokenizer struct {
+	// Deprecated: IndexToToken is empty since the vocabulary moved into a slice, use Token to read a token. The tokens
+	// added to it are still decoded, in place of the ones of the vocabulary.
 	IndexToToken map[int]string
 	Trie         *Trie
+	words        []string // the decode table, words[token] is the byte string of the token
+	specials     *SpecialTokens
 }
 
-// NewWorldTokenizer initializes a new world tokenizer
+// NewWorldTokenizer initializes a new world tokenizer from the embedded rwkv_vocab_v20230424.txt
 func NewWorldTokenizer() (*WorldTokenizer, error) {
-	f, err := worldTokenizerFS.Open("rwkv_vocab_v20230424.txt")
+	f, err := openEmbeddedVocabulary(worldVocabularyName)
 	if err != nil {
 		return nil, err
 	}
 	defer f.Close()
 
-	wt := &WorldTokenizer{
-		IndexToToken: make(map[int]string),
-		Trie:         NewTrie(),
+	return NewWorldTokenizerFromReader(f)
+}
+
+// NewWorldTokenizerFromFile initializes a world tokenizer from a vocabulary file in the rwkv_vocab_v20230424.txt format
+func NewWorldTokenizerFromFile(path string) (*WorldTokenizer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
 	}
+	defer f.Close()
+
+	return NewWorldTokenizerFromReader(f)
+}
+
+// NewWorldTokenizerFromReader initializes a world tokenizer from the content of a vocabulary file,
+// every line is `index token length`, such as `33 '@' 1` or `258 b'\x80' 1`
+func NewWorldTokenizerFromReader(reader io.Reader) (*WorldTokenizer, error) {
+	var words = make([]string, 0, worldVocabSize)
 
-	scanner := bufio.NewScanner(f)
+	scanner := bufio.NewScanner(reader)
 	for scanner.Scan() {
 		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+
 		leftIndex := strings.Index(line, " ")
 		rightIndex := strings.LastIndex(line, " ")
+		if leftIndex < 0 || rightIndex <= leftIndex {
+			return nil, fmt.Errorf("invalid vocabulary line: %q", line)
+		}
 
 		index, err := strconv.Atoi(line[:leftIndex])
 		if err != nil {
@@ -113,46 +323,151 @@ func NewWorldTokenizer() (*WorldTokenizer, error) {
 			return nil, err
 		}
 
-		wt.IndexToToken[index] = token
-		wt.Trie.Add(token, 0, index)
+		if index < 0 || index > maxVocabularyIndex {
+			return nil, fmt.Errorf("invalid token index %d in vocabulary line: %q", index, line)
+		}
+
+		if index >= len(words) {
+			words = append(words, make([]string, index+1-len(words))...)
+		}
+		words[index] = token
 	}
 
 	if err := scanner.Err(); err != nil {
 		return nil, err
 	}
 
+	// token 0 is not in the vocabulary file, World models use it as <|endoftext|>
+	var specials = NewSpecialTokens()
+	_ = specials.Add(EndOfTextToken, END_OF_TEXT)
+
+	wt := &WorldTokenizer{
+		IndexToToken: make(map[int]string),
+		Trie:         &Trie{compiled: newDoubleArrayTrie(words)},
+		words:        slices.Clip(words),
+		specials:     specials,
+	}
+
 	return wt, nil
 }
 
-// EncodeBytes encodes bytes to tokens
+// EncodeBytes encodes bytes to tokens, bytes which no token starts with are skipped
 func (wt *WorldTokenizer) EncodeBytes(src string) []int {
-	var tokens = make([]int, 0, len(src))
-	var index, token = 0, 0
+	var tokens, _ = wt.encodeBytes(src)
+	return tokens
+}
 
-	for index < len(src) {
-		index, token = wt.Trie.FindLongest(src, index)
+func (wt *WorldTokenizer) encodeBytes(src string) ([]int, error) {
+	var tokens = make([]int, 0, len(src))
+	var err = wt.scan(src, func(token, start, end int) {
 		tokens = append(tokens, token)
+	})
+
+	return tokens, err
+}
+
+// scan walks text token by token, bytes which no token starts with are skipped and reported by the returned error
+func (wt *WorldTokenizer) scan(text string, visit func(token, start, end int)) error {
+	var err error
+	for index := 0; index < len(text); {
+		var next, token = wt.Trie.FindLongest(text, index)
+		if next <= index {
+			if err == nil {
+				err = fmt.Errorf("no token for byte 0x%02x at %d", text[index], index)
+			}
+			index++
+			continue
+		}
+
+		visit(token, index, next)
+		index = next
 	}
 
-	return tokens
+	return err
 }
 
 // Encode encodes a string to tokens
 func (wt *WorldTokenizer) Encode(text string) ([]int, error) {
 	// this method is extremely fast, a text with 200 word only cost about 55.125µs
-	var tokens = wt.EncodeBytes(text)
-	return tokens, nil
+	return wt.encodeBytes(text)
+}
+
+// EncodeWithMode encodes a string to tokens, mode tells whether the text of special tokens is parsed or escaped
+func (wt *WorldTokenizer) EncodeWithMode(text string, mode SpecialTokenMode) ([]int, error) {
+	if mode == EscapeSpecialTokens || wt.specials.Len() == 0 {
+		return wt.encodeBytes(text)
+	}
+
+	var tokens = make([]int, 0, len(text))
+	var err = wt.specials.split(text, func(piece string, token int) error {
+		if token >= 0 {
+			tokens = append(tokens, token)
+			return nil
+		}
+
+		var pieceTokens, err = wt.encodeBytes(piece)
+		tokens = append(tokens, pieceTokens...)
+		return err
+	})
+
+	return tokens, err
+}
+
+// SpecialTokens returns the special token registry, which can be used to add custom control tokens
+func (wt *WorldTokenizer) SpecialTokens() *SpecialTokens {
+	return wt.specials
+}
+
+// EOS returns the end of text token
+func (wt *WorldTokenizer) EOS() int {
+	return wt.specials.EOS()
+}
+
+// EncodeWithOffsets encodes a string to tokens, and returns the byte span of text for every token
+func (wt *WorldTokenizer) EncodeWithOffsets(text string) ([]int, []TokenOffset, error) {
+	var tokens = make([]int, 0, len(text))
+	var offsets = make([]TokenOffset, 0, len(text))
+	var err = wt.scan(text, func(token, start, end int) {
+		tokens = append(tokens, token)
+		offsets = append(offsets, TokenOffset{Start: start, End: end})
+	})
+
+	return tokens, offsets, err
+}
+
+// CountTokens returns the number of tokens of text without building the token list
+func (wt *WorldTokenizer) CountTokens(text string) (int, error) {
+	var count = 0
+	var err = wt.scan(text, func(token, start, end int) {
+		count++
+	})
+
+	return count, err
+}
+
+// Token returns the byte string of token in the vocabulary
+func (wt *WorldTokenizer) Token(token int) (string, bool) {
+	if token >= 0 && token < len(wt.words) && wt.words[token] != "" {
+		return wt.words[token], true
+	}
+
+	return "", false
 }
 
 // Decode decodes tokens to a string
 func (wt *WorldTokenizer) Decode(tokens []int) string {
-	var results = make([]string, len(tokens))
-	for i, token := range tokens {
-		results[i] = wt.IndexToToken[token]
+	var sb strings.Builder
+	for _, token := range tokens {
+		if text, ok := wt.IndexToToken[token]; ok {
+			sb.WriteString(text)
+		} else if text, ok := wt.Token(token); ok {
+			sb.WriteString(text)
+		} else if text, ok := wt.specials.Text(token); ok {
+			sb.WriteString(text)
+		}
 	}
 
-	var text = strings.Join(results, "")
-	return text
+	return sb.String()
 }
 
 func parseInput(input string, size int) (string, error) {
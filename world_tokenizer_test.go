@@ -0,0 +1,217 @@
+// Copyright (c) seasonjs. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package rwkv
+
+import (
+	"math/rand"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// buildLegacyTokenizer builds the pointer based trie and the decode map used before the double-array trie, they are
+// kept as the reference for output equality and as the baseline of the benchmarks
+func buildLegacyTokenizer(words []string) (*Trie, map[int]string) {
+	var trie = NewTrie()
+	var decode = make(map[int]string, len(words))
+	for token, word := range words {
+		if word != "" {
+			trie.Add(word, 0, token)
+			decode[token] = word
+		}
+	}
+
+	return trie, decode
+}
+
+func legacyEncode(trie *Trie, src string) []int {
+	var tokens = make([]int, 0, len(src))
+	var index, token = 0, 0
+	for index < len(src) {
+		index, token = trie.FindLongest(src, index)
+		tokens = append(tokens, token)
+	}
+
+	return tokens
+}
+
+func legacyDecode(decode map[int]string, tokens []int) string {
+	var results = make([]string, len(tokens))
+	for i, token := range tokens {
+		results[i] = decode[token]
+	}
+
+	return strings.Join(results, "")
+}
+
+var tokenizerSampleText = strings.Repeat("RWKV is an RNN with transformer-level LLM performance. "+
+	"它可以像 GPT 一样直接训练, 同时推理速度快、省显存. こんにちは世界, Привет, мир! <|endoftext|>\n\n", 20)
+
+func mustWorldTokenizer(t testing.TB) *WorldTokenizer {
+	tk, err := NewWorldTokenizer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tk
+}
+
+func TestTrieMatchesLegacy(t *testing.T) {
+	var tk = mustWorldTokenizer(t)
+	var trie, decode = buildLegacyTokenizer(tk.words)
+
+	var texts = []string{"", "hello world", "你好世界", tokenizerSampleText}
+	var random = rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		var buf = make([]byte, random.Intn(64)+1)
+		random.Read(buf)
+		texts = append(texts, string(buf))
+	}
+
+	for _, text := range texts {
+		var want = legacyEncode(trie, text)
+		var got, err = tk.Encode(text)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !slices.Equal(want, got) {
+			t.Fatalf("encode %q: want %v, got %v", text, want, got)
+		}
+
+		if legacyDecode(decode, got) != tk.Decode(got) {
+			t.Fatalf("decode %v differs", got)
+		}
+	}
+}
+
+func TestTrieFindLongest(t *testing.T) {
+	var trie = newDoubleArrayTrie([]string{"", "a", "ab", "abc", "b", "", "ab"})
+
+	var index, token = trie.FindLongest("abd", 0)
+	assert(t, index == 2 && token == 2, "the first token of a duplicated word wins")
+
+	index, token = trie.FindLongest("xbc", 1)
+	assert(t, index == 2 && token == 4)
+
+	index, _ = trie.FindLongest("x", 0)
+	assert(t, index == 0, "no token matches")
+}
+
+func TestWorldTokenizerCompatibility(t *testing.T) {
+	var tk = mustWorldTokenizer(t)
+	var _, hasEOS = tk.Token(0)
+	var word, _ = tk.Token(300)
+	assert(t, word != "" && word == tk.words[300])
+	assert(t, !hasEOS, "token 0 is not in the vocabulary")
+
+	// tokens added by the exported fields are encoded and decoded, and the longer match wins
+	var text = "qqzzxxqqzz"
+	tk.Trie.Add(text, 0, 70000)
+	tk.IndexToToken[70000] = text
+	var tokens, err = tk.Encode(text + "!")
+	assert(t, err == nil && len(tokens) == 2 && tokens[0] == 70000)
+	assert(t, tk.Decode(tokens) == text+"!")
+
+	// the tokens put into IndexToToken replace the ones of the vocabulary in Decode
+	tk.IndexToToken[300] = "<300>"
+	assert(t, tk.Decode([]int{300, 70000}) == "<300>"+text)
+
+	var trie = NewTrie()
+	trie.Add("ab", 0, 5).Add("", 0, 4)
+	trie.Add("ab", 0, 3)
+	var index, token = trie.FindLongest("abc", 0)
+	assert(t, index == 2 && token == 3, "the smallest token of a duplicated key wins")
+	index, _ = trie.FindLongest("abc", 3)
+	assert(t, index == 0)
+}
+
+func BenchmarkTrieConstruction(b *testing.B) {
+	var words = mustWorldTokenizer(b).words
+
+	b.Run("legacy", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			buildLegacyTokenizer(words)
+		}
+	})
+
+	b.Run("double-array", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			newDoubleArrayTrie(words)
+		}
+	})
+}
+
+func BenchmarkWorldTokenizerEncode(b *testing.B) {
+	var tk = mustWorldTokenizer(b)
+	var trie, _ = buildLegacyTokenizer(tk.words)
+
+	b.Run("legacy", func(b *testing.B) {
+		b.SetBytes(int64(len(tokenizerSampleText)))
+		for i := 0; i < b.N; i++ {
+			legacyEncode(trie, tokenizerSampleText)
+		}
+	})
+
+	b.Run("double-array", func(b *testing.B) {
+		b.SetBytes(int64(len(tokenizerSampleText)))
+		for i := 0; i < b.N; i++ {
+			_, _ = tk.Encode(tokenizerSampleText)
+		}
+	})
+}
+
+func BenchmarkWorldTokenizerDecode(b *testing.B) {
+	var tk = mustWorldTokenizer(b)
+	var _, decode = buildLegacyTokenizer(tk.words)
+	var tokens, _ = tk.Encode(tokenizerSampleText)
+
+	b.Run("legacy", func(b *testing.B) {
+		b.SetBytes(int64(len(tokenizerSampleText)))
+		for i := 0; i < b.N; i++ {
+			legacyDecode(decode, tokens)
+		}
+	})
+
+	b.Run("slice", func(b *testing.B) {
+		b.SetBytes(int64(len(tokenizerSampleText)))
+		for i := 0; i < b.N; i++ {
+			tk.Decode(tokens)
+		}
+	})
+}
+
+func TestWorldTokenizerSpecialTokens(t *testing.T) {
+	var tk = mustWorldTokenizer(t)
+	assert(t, tk.EOS() == END_OF_TEXT)
+
+	var text = "hi<|endoftext|>there<|tool|>"
+	escaped, _ := tk.EncodeWithMode(text, EscapeSpecialTokens)
+	plain, _ := tk.Encode(text)
+	assert(t, slices.Equal(escaped, plain), "escape mode should encode as Encode")
+	assert(t, !slices.Contains(escaped, END_OF_TEXT))
+
+	parsed, err := tk.EncodeWithMode(text, ParseSpecialTokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert(t, slices.Contains(parsed, END_OF_TEXT), "<|endoftext|> should be parsed as a single token")
+	assert(t, tk.Decode(parsed) == text)
+
+	var specials = tk.SpecialTokens()
+	if err = specials.Add("<|tool|>", 65530); err != nil {
+		t.Fatal(err)
+	}
+	specials.SetPadding(65530)
+
+	parsed, _ = tk.EncodeWithMode(text, ParseSpecialTokens)
+	assert(t, parsed[len(parsed)-1] == 65530 && specials.Padding() == 65530)
+	assert(t, tk.Decode(parsed) == text)
+
+	specials.Remove("<|tool|>")
+	parsed, _ = tk.EncodeWithMode(text, ParseSpecialTokens)
+	assert(t, !slices.Contains(parsed, 65530))
+}